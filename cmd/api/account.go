@@ -0,0 +1,259 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCurrentUserHandler changes the caller's name and/or email. Changing
+// the email takes the current password, as the email is what the account
+// signs in with. A new email address has to be verified again, so the account is
+// deactivated and a fresh activation token is returned alongside the user.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name            *string `json:"name"`
+		Email           *string `json:"email"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	emailChanged := input.Email != nil && !strings.EqualFold(*input.Email, user.Email)
+	if emailChanged && !app.confirmPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "user.update", "user", user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	if input.Email != nil {
+		user.Email = *input.Email
+	}
+
+	if emailChanged {
+		user.Activated = false
+	}
+
+	v := validator.New()
+	if models.ValidateUser(v, user); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.WithAudit(event).Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.faliedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := map[string]any{"user": user}
+
+	if emailChanged {
+		// Tokens sent to the old address must not activate the new one.
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), models.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		activationToken, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		response["activation_token"] = struct {
+			Token  string    `json:"token"`
+			Expiry time.Time `json:"expiry"`
+		}{
+			Token:  activationToken.Plaintext,
+			Expiry: activationToken.Expiry,
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changePasswordHandler sets a new password once the current one has been
+// confirmed. Wrong guesses count towards the login lockout, and every
+// existing session is signed out afterwards.
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.confirmPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "user.password_change", "user", user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidateUserDetails(v, user)
+	app.passwordPolicy.Check(v, "password", input.Password, user.Name, user.Email)
+	v.Check(input.Password != input.CurrentPassword, "password", "must be different from your current password")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.WithAudit(event).Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{models.ScopeAuthentication, models.ScopeTwoFactorPending} {
+		err = app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "your password was changed, please log in again"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCurrentUserHandler removes the caller's account. Tokens, permissions,
+// API keys and the watchlist go with it through the foreign keys.
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if !app.confirmPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "user.delete", "user", user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Users.WithAudit(event).Delete(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "your account was deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmPassword checks password against the user's current one, going
+// through the same lockout as a login so a stolen token can't be used to
+// guess it. It sends the error response itself and reports whether the
+// handler may carry on.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	v := validator.New()
+	v.Check(password != "", "current_password", "must be provided")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	retryAfter, err := app.loginRetryAfter(r.Context(), user.Email, app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return false
+	}
+
+	match, err := user.Password.Matches(password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !match {
+		app.failedLoginResponse(w, r, user.Email)
+		return false
+	}
+
+	return true
+}
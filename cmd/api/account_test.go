@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestShowCurrentUserHandler tests that the caller's own account is returned
+func TestShowCurrentUserHandler(t *testing.T) {
+	app := &application{
+		logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+	req = app.contextSetUser(req, &models.User{ID: 7, Name: "Jane", Email: "jane@example.com"})
+	rr := httptest.NewRecorder()
+	app.showCurrentUserHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), `"email":"jane@example.com"`) {
+		t.Errorf("response body %q does not contain the user's email", rr.Body.String())
+	}
+}
+
+// TestAccountHandlersValidation tests that account changes are rejected
+// before the current password is checked or the database is touched
+func TestAccountHandlersValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        func(app *application) http.HandlerFunc
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Password change without current password",
+			handler:        func(app *application) http.HandlerFunc { return app.changePasswordHandler },
+			body:           `{"password": "new-password-123"}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Password change with unknown field",
+			handler:        func(app *application) http.HandlerFunc { return app.changePasswordHandler },
+			body:           `{"old_password": "pa55word123"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Delete without current password",
+			handler:        func(app *application) http.HandlerFunc { return app.deleteCurrentUserHandler },
+			body:           `{}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Email change without current password",
+			handler:        func(app *application) http.HandlerFunc { return app.updateCurrentUserHandler },
+			body:           `{"email": "jane.doe@example.com"}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Update with malformed body",
+			handler:        func(app *application) http.HandlerFunc { return app.updateCurrentUserHandler },
+			body:           `{"name": }`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodPut, "/v1/users/me", strings.NewReader(tt.body))
+			req = app.contextSetUser(req, &models.User{ID: 1, Email: "jane@example.com", Activated: true})
+			rr := httptest.NewRecorder()
+			tt.handler(app)(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+// TestUpdateCurrentUserEmail tests that changing the email takes the current
+// password while changing the name doesn't
+func TestUpdateCurrentUserEmail(t *testing.T) {
+	app, _, token := newMemoryApp(t)
+
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "Name only", body: `{"name": "Alice Liddell"}`, wantStatusCode: http.StatusOK},
+		{name: "Wrong password", body: `{"email": "alice@example.org", "current_password": "wrong-password"}`, wantStatusCode: http.StatusUnauthorized},
+		{name: "Same email", body: `{"email": "ALICE@example.com"}`, wantStatusCode: http.StatusOK},
+		{name: "Current password", body: `{"email": "alice@example.org", "current_password": "pa55word1234"}`, wantStatusCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(t, app, http.MethodPatch, "/v1/users/me", token, tt.body, nil)
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("got status %d and body %s, want %d", rr.Code, rr.Body, tt.wantStatusCode)
+			}
+		})
+	}
+}
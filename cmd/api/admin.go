@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search     string
+		Permission string
+		Filters    models.Filters
+	}
+
+	v := validator.New()
+	queryString := r.URL.Query()
+	input.Search = app.readString(queryString, "q", "")
+	input.Permission = app.readString(queryString, "permission", "")
+	input.Filters.Page = app.readInt(queryString, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	input.Filters.SortValues = app.readCSV(queryString, "sort", []string{})
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.Users.GetAll(r.Context(), input.Search, input.Permission, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.userPermissions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserStatusHandler lets an administrator activate, deactivate,
+// suspend or reinstate an account.
+func (app *application) updateUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Activated *bool `json:"activated"`
+		Suspended *bool `json:"suspended"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Activated != nil || input.Suspended != nil, "body", "must contain activated or suspended")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if input.Suspended != nil {
+		admin := app.contextGetUser(r)
+		v.Check(!*input.Suspended || user.ID != admin.ID, "suspended", "you can't suspend your own account")
+		if !v.Valid() {
+			app.faliedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	event, err := app.newAuditEvent(r, "", "user", user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var actions []string
+
+	if input.Activated != nil && *input.Activated != user.Activated {
+		user.Activated = *input.Activated
+		if user.Activated {
+			actions = append(actions, "user.activate")
+		} else {
+			actions = append(actions, "user.deactivate")
+		}
+	}
+
+	if input.Suspended != nil && *input.Suspended != user.Suspended {
+		user.Suspended = *input.Suspended
+		if user.Suspended {
+			actions = append(actions, "user.suspend")
+		} else {
+			actions = append(actions, "user.reinstate")
+		}
+	}
+
+	if len(actions) > 0 {
+		// Both toggles in one request share a single, transactional entry.
+		event.Action = actions[0]
+		if len(actions) > 1 {
+			event.Action = "user.update_status"
+		}
+
+		err = app.models.Users.WithAudit(event).Update(r.Context(), user)
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// logoutUserHandler signs a user out everywhere by deleting their
+// authentication tokens. API keys are left alone; they're revoked separately.
+func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	for _, scope := range []string{models.ScopeAuthentication, models.ScopeTwoFactorPending} {
+		err := app.models.Tokens.DeleteAllForUser(r.Context(), scope, user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	event, err := app.newAuditEvent(r, "user.logout", "user", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Audit.Insert(r.Context(), event, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "user logged out successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.userPermissions(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ActorID      int
+		Action       string
+		ResourceType string
+		ResourceID   int
+		From         *time.Time
+		To           *time.Time
+		Filters      models.Filters
+	}
+
+	v := validator.New()
+	queryString := r.URL.Query()
+	input.ActorID = app.readInt(queryString, "actor_id", 0, v)
+	input.Action = app.readString(queryString, "action", "")
+	input.ResourceType = app.readString(queryString, "resource_type", "")
+	input.ResourceID = app.readInt(queryString, "resource_id", 0, v)
+	input.From = app.readTime(queryString, "from", v)
+	input.To = app.readTime(queryString, "to", v)
+	input.Filters.Page = app.readInt(queryString, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	input.Filters.SortValues = app.readCSV(queryString, "sort", []string{"-created_at"})
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	if input.From != nil && input.To != nil {
+		v.Check(input.From.Before(*input.To), "to", "must be after from")
+	}
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := app.models.Audit.GetAll(
+		r.Context(),
+		int64(input.ActorID),
+		input.Action,
+		input.ResourceType,
+		int64(input.ResourceID),
+		input.From,
+		input.To,
+		input.Filters,
+	)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"audit_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showCacheStatsHandler reports how well the film cache is doing.
+func (app *application) showCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	data := map[string]any{"enabled": false}
+
+	if app.filmCache != nil {
+		stats := app.filmCache.Stats()
+		data = map[string]any{
+			"enabled":   true,
+			"hits":      stats.Hits,
+			"misses":    stats.Misses,
+			"evictions": stats.Evictions,
+			"entries":   stats.Entries,
+			"capacity":  stats.Capacity,
+		}
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"cache": data}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showLogLevelHandler reports the lowest level being logged.
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"log_level": app.logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateLogLevelHandler changes the lowest level being logged without a
+// restart, such as to turn on debug logging while chasing a problem. It
+// only affects the instance that serves the request.
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	level, err := jsonlog.ParseLevel(input.Level)
+
+	v := validator.New()
+	v.Check(err == nil && level >= jsonlog.LevelDebug && level <= jsonlog.LevelError, "level", "must be one of debug, info, warn or error")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	previous := app.logger.Level()
+	app.logger.SetLevel(level)
+
+	app.requestLogger(r).Warn("log level changed",
+		jsonlog.String("from", previous.String()),
+		jsonlog.String("to", level.String()),
+		jsonlog.Int64("user_id", app.contextGetUser(r).ID),
+	)
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"log_level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userFromPath loads the user named by the {id} path value. When it returns
+// false the error response has already been sent.
+func (app *application) userFromPath(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.Users.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// userPermissions is GetAllForUser with an empty list rather than null for
+// users who hold no permissions.
+func (app *application) userPermissions(ctx context.Context, userID int64) (models.Permissions, error) {
+	permissions, err := app.models.Permissions.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permissions == nil {
+		permissions = models.Permissions{}
+	}
+
+	return permissions, nil
+}
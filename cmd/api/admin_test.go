@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/cache"
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestNewAuditEvent tests that audit events are attributed to the caller and
+// request and capture the resource before it's modified
+func TestNewAuditEvent(t *testing.T) {
+	app := &application{}
+
+	req := httptest.NewRequest(http.MethodPatch, "/v1/admin/users/42", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req = app.contextSetRequestInfo(req, &requestInfo{id: "req-1"})
+	req = app.contextSetUser(req, &models.User{ID: 1})
+
+	user := &models.User{ID: 42, Name: "Jane", Email: "jane@example.com"}
+	event, err := app.newAuditEvent(req, "user.suspend", "user", user)
+	if err != nil {
+		t.Fatalf("newAuditEvent() error = %v", err)
+	}
+
+	user.Suspended = true
+
+	if event.ActorID == nil || *event.ActorID != 1 {
+		t.Errorf("ActorID = %v, want 1", event.ActorID)
+	}
+	if event.IP != "203.0.113.7" {
+		t.Errorf("IP = %q, want %q", event.IP, "203.0.113.7")
+	}
+	if event.RequestID != "req-1" {
+		t.Errorf("RequestID = %q, want %q", event.RequestID, "req-1")
+	}
+
+	var before models.User
+	if err := json.Unmarshal(event.Before, &before); err != nil {
+		t.Fatalf("Before is not valid JSON: %v", err)
+	}
+	if before.Suspended {
+		t.Error("Before should capture the user ahead of the change")
+	}
+}
+
+// TestNewAuditEventAnonymous tests that unauthenticated changes have no actor
+func TestNewAuditEventAnonymous(t *testing.T) {
+	app := &application{}
+
+	req := httptest.NewRequest(http.MethodPut, "/v1/users/activate", nil)
+	req = app.contextSetUser(req, models.AnonymousUser)
+
+	event, err := app.newAuditEvent(req, "user.activate", "user", nil)
+	if err != nil {
+		t.Fatalf("newAuditEvent() error = %v", err)
+	}
+
+	if event.ActorID != nil {
+		t.Errorf("ActorID = %v, want nil", *event.ActorID)
+	}
+	if event.Before != nil {
+		t.Errorf("Before = %s, want nil", event.Before)
+	}
+}
+
+// TestAdminHandlersValidation tests requests that are rejected before any
+// user is looked up
+func TestAdminHandlersValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        func(app *application) http.HandlerFunc
+		method         string
+		target         string
+		id             string
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Status update without changes",
+			handler:        func(app *application) http.HandlerFunc { return app.updateUserStatusHandler },
+			method:         http.MethodPatch,
+			id:             "2",
+			body:           `{}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Status update with unknown field",
+			handler:        func(app *application) http.HandlerFunc { return app.updateUserStatusHandler },
+			method:         http.MethodPatch,
+			id:             "2",
+			body:           `{"admin": true}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "Non-numeric ID",
+			handler:        func(app *application) http.HandlerFunc { return app.showUserHandler },
+			method:         http.MethodGet,
+			id:             "abc",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid page size",
+			handler:        func(app *application) http.HandlerFunc { return app.listUsersHandler },
+			method:         http.MethodGet,
+			target:         "?page_size=500",
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Audit with malformed time",
+			handler:        func(app *application) http.HandlerFunc { return app.listAuditEventsHandler },
+			method:         http.MethodGet,
+			target:         "?from=yesterday",
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Audit with empty time range",
+			handler:        func(app *application) http.HandlerFunc { return app.listAuditEventsHandler },
+			method:         http.MethodGet,
+			target:         "?from=2025-01-02T00:00:00Z&to=2025-01-01T00:00:00Z",
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Unsafe sort",
+			handler:        func(app *application) http.HandlerFunc { return app.listUsersHandler },
+			method:         http.MethodGet,
+			target:         "?sort=password_hash",
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(tt.method, "/v1/admin/users"+tt.target, strings.NewReader(tt.body))
+			req.SetPathValue("id", tt.id)
+			req = app.contextSetUser(req, &models.User{ID: 1, Activated: true})
+			rr := httptest.NewRecorder()
+			tt.handler(app)(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+// TestShowCacheStatsHandler tests that cache counters are reported, and that
+// a disabled cache says so
+func TestShowCacheStatsHandler(t *testing.T) {
+	withCache := cache.NewLRU(10, 0)
+	withCache.Set("film:1", &models.Film{ID: 1})
+	withCache.Get("film:1")
+	withCache.Get("film:2")
+
+	tests := []struct {
+		name     string
+		cache    cache.Cache
+		wantBody string
+	}{
+		{name: "Disabled", wantBody: `{"cache":{"enabled":false}}`},
+		{name: "Enabled", cache: withCache, wantBody: `{"cache":{"capacity":10,"enabled":true,"entries":1,"evictions":0,"hits":1,"misses":1}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+			app.filmCache = tt.cache
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/cache", nil)
+			rr := httptest.NewRecorder()
+			app.showCacheStatsHandler(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d, want %d", rr.Code, http.StatusOK)
+			}
+
+			if got := strings.TrimSpace(rr.Body.String()); got != tt.wantBody {
+				t.Errorf("got body %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestUpdateLogLevelHandler tests that admins can change the log level and
+// that levels which would hide errors are refused
+func TestUpdateLogLevelHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLevel  jsonlog.Level
+	}{
+		{name: "Debug", body: `{"level":"debug"}`, wantStatus: http.StatusOK, wantLevel: jsonlog.LevelDebug},
+		{name: "Warn", body: `{"level":"WARN"}`, wantStatus: http.StatusOK, wantLevel: jsonlog.LevelWarn},
+		{name: "Off", body: `{"level":"off"}`, wantStatus: http.StatusUnprocessableEntity, wantLevel: jsonlog.LevelInfo},
+		{name: "Unknown", body: `{"level":"verbose"}`, wantStatus: http.StatusUnprocessableEntity, wantLevel: jsonlog.LevelInfo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+			req := httptest.NewRequest(http.MethodPut, "/v1/admin/log-level", strings.NewReader(tt.body))
+			req = app.contextSetUser(req, &models.User{ID: 1})
+			rr := httptest.NewRecorder()
+			app.updateLogLevelHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if got := app.logger.Level(); got != tt.wantLevel {
+				t.Errorf("got level %s, want %s", got, tt.wantLevel)
+			}
+		})
+	}
+}
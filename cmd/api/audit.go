@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// newAuditEvent starts an audit entry for an action the request is taking
+// against a resource, attributed to the authenticated user (if there is one)
+// and the client's IP address. before is serialised straight away, so pass
+// the resource before modifying it, or nil when creating one.
+func (app *application) newAuditEvent(r *http.Request, action, resourceType string, before any) (*models.AuditEvent, error) {
+	event := &models.AuditEvent{
+		Action:       action,
+		ResourceType: resourceType,
+		IP:           app.clientIP(r),
+		RequestID:    app.contextGetRequestID(r),
+	}
+
+	if actor, ok := r.Context().Value(userContextKey).(*models.User); ok && !actor.IsAnonyomous() {
+		event.ActorID = &actor.ID
+	}
+
+	if before != nil {
+		if err := event.SetBefore(before); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+const commandUsage = `usage: api [flags] [command]
+
+commands:
+  serve                 run the API server (the default)
+  migrate up | down [steps] | status | force <version>
+  users create -name <name> -email <email> [-password <password>] [-activated] [-grant <codes>]
+  users activate <email>
+  users grant <email> <code>...
+  tokens purge          delete expired tokens
+  films import [file]   add the films in a JSON file, or standard input
+  films export [file]   write every film as JSON to a file, or standard output
+  seed [file]           import films if there are none yet (default ./static/json/films.json)
+
+Flags such as -db-dsn go before the command. Commands print their result as
+JSON and exit 0 on success, 1 on failure and 2 if they're used wrongly.`
+
+// defaultSeedFile holds the films seed imports.
+const defaultSeedFile = "./static/json/films.json"
+
+// errUsage means a command was called with the wrong arguments.
+var errUsage = errors.New("wrong arguments")
+
+// errImportIncomplete means some films in an import were rejected.
+var errImportIncomplete = errors.New("some films were not imported")
+
+// commandFunc carries out a parsed command and returns its result, which is
+// printed as JSON. db is nil when the command runs against other models, as
+// in tests.
+type commandFunc func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error)
+
+// validationError reports input that failed validation, field by field, as
+// the API's 422 responses do.
+type validationError map[string]string
+
+func (e validationError) Error() string {
+	return "failed validation"
+}
+
+// runCommand carries out a command such as `api users create` instead of
+// starting the server, and returns the exit code: 0 on success, 1 if the
+// command failed and 2 if it was used wrongly. Arguments are checked before
+// the database is opened.
+func runCommand(cfg config, logger *jsonlog.Logger, args []string) int {
+	run, err := parseCommand(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, commandUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := openDB(cfg)
+	if err != nil {
+		return writeCommandResult(os.Stdout, nil, err)
+	}
+	defer db.Close()
+
+	app := &application{
+		config: cfg,
+		logger: logger,
+		models: models.New(db, cfg.db.queryTimeout),
+	}
+
+	err = app.loadPasswordPolicy()
+	if err != nil {
+		return writeCommandResult(os.Stdout, nil, err)
+	}
+
+	result, err := run(ctx, app, db)
+	return writeCommandResult(os.Stdout, result, err)
+}
+
+// parseCommand checks args and returns the command they name.
+func parseCommand(args []string) (commandFunc, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "users":
+		return usersCommand(args[1:])
+	case "tokens":
+		if !slices.Equal(args[1:], []string{"purge"}) {
+			return nil, errUsage
+		}
+		return purgeTokensCommand, nil
+	case "films":
+		return filmsCommand(args[1:])
+	case "seed":
+		if len(args) > 2 {
+			return nil, errUsage
+		}
+
+		file := defaultSeedFile
+		if len(args) == 2 {
+			file = args[1]
+		}
+
+		return seedCommand(file), nil
+	default:
+		return nil, errUsage
+	}
+}
+
+// writeCommandResult prints a command's result, or its error, as JSON and
+// returns the exit code. An error alongside a result, such as a partly
+// failed import, is added to the result.
+func writeCommandResult(out io.Writer, result map[string]any, err error) int {
+	code := 0
+
+	if err != nil {
+		code = 1
+
+		if result == nil {
+			result = map[string]any{}
+		}
+
+		var invalid validationError
+		if errors.As(err, &invalid) {
+			result["error"] = map[string]string(invalid)
+		} else {
+			result["error"] = err.Error()
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "\t")
+
+	if encodeErr := encoder.Encode(result); encodeErr != nil {
+		return 1
+	}
+
+	return code
+}
+
+// commandAuditEvent returns an audit event for a change made from the
+// command line, which has no actor, request or client address.
+func commandAuditEvent(action, resourceType string, before any) (*models.AuditEvent, error) {
+	event := &models.AuditEvent{
+		Action:       action,
+		ResourceType: resourceType,
+	}
+
+	if before != nil {
+		if err := event.SetBefore(before); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
+
+// newFlagSet returns a flag set for a command's own flags. Its errors are
+// reported as errUsage rather than printed.
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+func usersCommand(args []string) (commandFunc, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		var input struct {
+			name      string
+			email     string
+			password  string
+			activated bool
+			grant     string
+		}
+
+		flags := newFlagSet("users create")
+		flags.StringVar(&input.name, "name", "", "")
+		flags.StringVar(&input.email, "email", "", "")
+		flags.StringVar(&input.password, "password", os.Getenv("FILMAPI_USER_PASSWORD"), "")
+		flags.BoolVar(&input.activated, "activated", false, "")
+		flags.StringVar(&input.grant, "grant", "", "")
+
+		if err := flags.Parse(args[1:]); err != nil || flags.NArg() > 0 {
+			return nil, errUsage
+		}
+
+		codes := []string{"films:read"}
+		for _, code := range strings.Split(input.grant, ",") {
+			if code = strings.TrimSpace(code); code != "" && !slices.Contains(codes, code) {
+				codes = append(codes, code)
+			}
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			return app.createUser(ctx, input.name, input.email, input.password, input.activated, codes)
+		}, nil
+
+	case "activate":
+		if len(args) != 2 {
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			return app.activateUser(ctx, args[1])
+		}, nil
+
+	case "grant":
+		if len(args) < 3 {
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			user, err := app.userForEmail(ctx, args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			permissions, err := app.grantPermissions(ctx, user, args[2:])
+			return map[string]any{"user": user, "permissions": permissions}, err
+		}, nil
+
+	default:
+		return nil, errUsage
+	}
+}
+
+// createUser adds an account as the registration endpoint does, except that
+// it can be activated straight away and granted more than films:read.
+func (app *application) createUser(ctx context.Context, name, email, password string, activated bool, codes []string) (map[string]any, error) {
+	user := &models.User{
+		Name:      name,
+		Email:     email,
+		Activated: activated,
+	}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		return nil, err
+	}
+
+	v := validator.New()
+	models.ValidateUserDetails(v, user)
+	app.passwordPolicy.Check(v, "password", password, user.Name, user.Email)
+	if !v.Valid() {
+		return nil, validationError(v.Errors)
+	}
+
+	event, err := commandAuditEvent("user.create", "user", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.WithAudit(event).Insert(ctx, user)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			return nil, validationError{"email": "a user with this email already exists"}
+		default:
+			return nil, err
+		}
+	}
+
+	// The user is reported even if a permission couldn't be granted, as
+	// they've been created by then.
+	permissions, err := app.grantPermissions(ctx, user, codes)
+	return map[string]any{"user": user, "permissions": permissions}, err
+}
+
+// activateUser activates the account with the given email, as following
+// its activation link would.
+func (app *application) activateUser(ctx context.Context, email string) (map[string]any, error) {
+	user, err := app.userForEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Activated {
+		event, err := commandAuditEvent("user.activate", "user", user)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Activated = true
+		err = app.models.Users.WithAudit(event).Update(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(ctx, models.ScopeActivation, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"user": user}, nil
+}
+
+func (app *application) userForEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := app.models.Users.GetByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with email %q", email)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// grantPermissions grants the codes to the user and returns everything they
+// now hold. Granting an unknown code does nothing, so it's reported as an
+// error once the known ones are granted.
+func (app *application) grantPermissions(ctx context.Context, user *models.User, codes []string) (models.Permissions, error) {
+	event, err := commandAuditEvent("permissions.grant", "user", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Permissions.WithAudit(event).AddForUser(ctx, user.ID, codes...)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := app.userPermissions(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, code := range codes {
+		if !permissions.Include(code) {
+			unknown = append(unknown, code)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return permissions, fmt.Errorf("unknown permissions: %s", strings.Join(unknown, ", "))
+	}
+
+	return permissions, nil
+}
+
+// purgeTokensCommand deletes expired tokens, which the sweeper otherwise
+// does every -sweeper-interval.
+func purgeTokensCommand(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+	deleted, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{"deleted": deleted}, nil
+}
+
+func filmsCommand(args []string) (commandFunc, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errUsage
+	}
+
+	file := "-"
+	if len(args) == 2 {
+		file = args[1]
+	}
+
+	switch args[0] {
+	case "import":
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			records, err := readFilmRecords(file)
+			if err != nil {
+				return nil, err
+			}
+
+			return app.importFilms(ctx, records)
+		}, nil
+
+	case "export":
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			films, err := app.allFilms(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if file == "-" {
+				return map[string]any{"films": films}, nil
+			}
+
+			err = writeFilmsFile(file, films)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{"exported": len(films), "file": file}, nil
+		}, nil
+
+	default:
+		return nil, errUsage
+	}
+}
+
+// seedCommand imports the films in file into an empty database, so a new
+// deployment has something to serve. It does nothing if there are films
+// already, so it's safe to run on every deploy.
+func seedCommand(file string) commandFunc {
+	return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+		count, err := app.models.Films.Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if count > 0 {
+			return map[string]any{"imported": 0, "skipped": true, "films": count}, nil
+		}
+
+		records, err := readFilmRecords(file)
+		if err != nil {
+			return nil, err
+		}
+
+		return app.importFilms(ctx, records)
+	}
+}
+
+// filmRecord is a film as it's imported. Films are exported as the API
+// returns them, which has the same fields and more, so exports can be
+// imported again.
+type filmRecord struct {
+	Title       string         `json:"title"`
+	Year        int32          `json:"year"`
+	Runtime     models.Runtime `json:"runtime"`
+	Rating      float32        `json:"rating"`
+	Description string         `json:"description"`
+	Image       string         `json:"image"`
+	Genres      []string       `json:"genres"`
+	Directors   []string       `json:"directors"`
+	Actors      []string       `json:"actors"`
+}
+
+func (record filmRecord) film() *models.Film {
+	film := &models.Film{
+		Title:       record.Title,
+		Year:        record.Year,
+		Runtime:     record.Runtime,
+		Rating:      record.Rating,
+		Description: record.Description,
+		Img:         record.Image,
+		Genres:      make([]models.Genre, len(record.Genres)),
+		Directors:   make([]models.Director, len(record.Directors)),
+		Actors:      make([]models.Actor, len(record.Actors)),
+	}
+
+	for i, name := range record.Genres {
+		film.Genres[i] = models.Genre{Name: name}
+	}
+
+	for i, name := range record.Directors {
+		film.Directors[i] = models.Director{Name: name}
+	}
+
+	for i, name := range record.Actors {
+		film.Actors[i] = models.Actor{Name: name}
+	}
+
+	return film
+}
+
+// readFilmRecords reads films from file, or standard input if it's "-".
+// Either a bare array or an export's {"films": [...]} is accepted.
+func readFilmRecords(file string) ([]filmRecord, error) {
+	var data []byte
+	var err error
+
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeFilmRecords(data)
+}
+
+func decodeFilmRecords(data []byte) ([]filmRecord, error) {
+	var records []filmRecord
+
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "{") {
+		var export struct {
+			Films []filmRecord `json:"films"`
+		}
+
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, fmt.Errorf("reading films: %w", err)
+		}
+
+		records = export.Films
+	} else if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("reading films: %w", err)
+	}
+
+	return records, nil
+}
+
+// importFilms validates and inserts each film. A film that's rejected is
+// reported with its position and the import carries on, but the result is
+// then an errImportIncomplete failure.
+func (app *application) importFilms(ctx context.Context, records []filmRecord) (map[string]any, error) {
+	type failure struct {
+		Index int    `json:"index"`
+		Title string `json:"title"`
+		Error any    `json:"error"`
+	}
+
+	imported := 0
+	failed := []failure{}
+
+	for i, record := range records {
+		if err := ctx.Err(); err != nil {
+			return map[string]any{"imported": imported, "failed": failed}, err
+		}
+
+		film := record.film()
+
+		v := validator.New()
+		if models.ValidateFilm(v, film); !v.Valid() {
+			failed = append(failed, failure{Index: i, Title: record.Title, Error: v.Errors})
+			continue
+		}
+
+		event, err := commandAuditEvent("film.create", "film", nil)
+		if err != nil {
+			return nil, err
+		}
+
+		err = app.models.Films.WithAudit(event).Insert(ctx, film)
+		if err != nil {
+			failed = append(failed, failure{Index: i, Title: record.Title, Error: err.Error()})
+			continue
+		}
+
+		imported++
+	}
+
+	app.logger.Info("films imported", jsonlog.Int("imported", imported), jsonlog.Int("failed", len(failed)))
+
+	result := map[string]any{"imported": imported, "failed": failed}
+	if len(failed) > 0 {
+		return result, errImportIncomplete
+	}
+
+	return result, nil
+}
+
+// allFilms returns every film in ID order, a page at a time.
+func (app *application) allFilms(ctx context.Context) ([]*models.Film, error) {
+	const pageSize = 100
+
+	films := []*models.Film{}
+
+	for page := 1; ; page++ {
+		filters := models.Filters{
+			Page:         page,
+			PageSize:     pageSize,
+			SortValues:   []string{"id"},
+			SortSafelist: []string{"id"},
+		}
+
+		batch, _, err := app.models.Films.GetAll(ctx, "", nil, nil, nil, filters)
+		if err != nil {
+			return nil, err
+		}
+
+		films = append(films, batch...)
+
+		if len(batch) < pageSize {
+			return films, nil
+		}
+	}
+}
+
+func writeFilmsFile(file string, films []*models.Film) error {
+	data, err := json.MarshalIndent(map[string]any{"films": films}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, append(data, '\n'), 0o644)
+}
+
+// nonNil returns s, or an empty slice if it's nil, so it's encoded as [].
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	return s
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// runTestCommand parses and runs args against app, failing the test if they
+// don't parse.
+func runTestCommand(t *testing.T, app *application, args ...string) (map[string]any, error) {
+	t.Helper()
+
+	run, err := parseCommand(args)
+	if err != nil {
+		t.Fatalf("args %q: %v", args, err)
+	}
+
+	return run(context.Background(), app, nil)
+}
+
+// writeFilmsJSON writes data to a file in a temporary directory and returns
+// its path.
+func writeFilmsJSON(t *testing.T, data string) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "films.json")
+	if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+const testFilmsJSON = `[
+	{"title": "Inception", "year": 2010, "runtime": "148 mins", "genres": ["sci-fi"], "directors": ["Christopher Nolan"], "actors": ["Elliot Page"], "image": "https://example.com/inception.jpg"},
+	{"title": "", "year": 2010, "runtime": "100 mins", "genres": ["drama"], "image": "https://example.com/untitled.jpg"},
+	{"title": "Heat", "year": 1995, "runtime": "170 mins", "genres": ["crime", "drama"], "image": "https://example.com/heat.jpg"}
+]`
+
+// TestParseCommand tests that malformed commands are rejected before touching the database
+func TestParseCommand(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"launch"},
+		{"users"},
+		{"users", "delete", "alice@example.com"},
+		{"users", "create", "-nickname", "alice"},
+		{"users", "create", "-email", "alice@example.com", "extra"},
+		{"users", "activate"},
+		{"users", "activate", "alice@example.com", "bob@example.com"},
+		{"users", "grant", "alice@example.com"},
+		{"tokens"},
+		{"tokens", "purge", "now"},
+		{"films"},
+		{"films", "copy"},
+		{"films", "import", "a.json", "b.json"},
+		{"seed", "a.json", "b.json"},
+		{"migrate", "sideways"},
+	} {
+		_, err := parseCommand(args)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("args %q: got %v, want %v", args, err, errUsage)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"users", "create", "-name", "Alice", "-email", "alice@example.com", "-activated", "-grant", "films:write"},
+		{"users", "activate", "alice@example.com"},
+		{"users", "grant", "alice@example.com", "films:write", "users:admin"},
+		{"tokens", "purge"},
+		{"films", "import"},
+		{"films", "export", "films.json"},
+		{"seed"},
+		{"migrate", "status"},
+	} {
+		if _, err := parseCommand(args); err != nil {
+			t.Errorf("args %q: %v", args, err)
+		}
+	}
+}
+
+// TestWriteCommandResult tests how results and errors are printed and the exit codes returned
+func TestWriteCommandResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   map[string]any
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{name: "Success", result: map[string]any{"deleted": 3}, wantBody: `{"deleted":3}`},
+		{name: "Error", err: errors.New("connection refused"), wantCode: 1, wantBody: `{"error":"connection refused"}`},
+		{name: "Validation", err: validationError{"email": "must be provided"}, wantCode: 1, wantBody: `{"error":{"email":"must be provided"}}`},
+		{name: "Partial", result: map[string]any{"imported": 2}, err: errImportIncomplete, wantCode: 1, wantBody: `{"error":"some films were not imported","imported":2}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			code := writeCommandResult(&out, tt.result, tt.err)
+			if code != tt.wantCode {
+				t.Errorf("got exit code %d, want %d", code, tt.wantCode)
+			}
+
+			var compact bytes.Buffer
+			if err := json.Compact(&compact, out.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+
+			if compact.String() != tt.wantBody {
+				t.Errorf("got %s, want %s", compact.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestUsersCommands tests creating, activating and granting permissions to users
+func TestUsersCommands(t *testing.T) {
+	app, store, _ := newMemoryApp(t)
+	ctx := context.Background()
+
+	result, err := runTestCommand(t, app, "users", "create", "-name", "Bob", "-email", "bob@example.com", "-password", "pa55word1234", "-grant", "films:write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := result["user"].(*models.User)
+	if user.Activated {
+		t.Error("user activated without -activated")
+	}
+
+	permissions := result["permissions"].(models.Permissions)
+	if !permissions.Include("films:read") || !permissions.Include("films:write") {
+		t.Errorf("got permissions %v, want films:read and films:write", permissions)
+	}
+
+	_, err = runTestCommand(t, app, "users", "create", "-name", "Bob", "-email", "BOB@example.com", "-password", "pa55word1234")
+	var invalid validationError
+	if !errors.As(err, &invalid) || invalid["email"] == "" {
+		t.Errorf("creating a duplicate user: got %v, want an email validation error", err)
+	}
+
+	_, err = runTestCommand(t, app, "users", "create", "-name", "Carol", "-email", "carol@example.com")
+	if !errors.As(err, &invalid) || invalid["password"] == "" {
+		t.Errorf("creating a user without a password: got %v, want a password validation error", err)
+	}
+
+	activation, err := app.models.Tokens.New(ctx, user.ID, time.Hour, models.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = runTestCommand(t, app, "users", "activate", "bob@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result["user"].(*models.User).Activated {
+		t.Error("user not activated")
+	}
+
+	if _, err := app.models.Users.GetForToken(ctx, models.ScopeActivation, activation.Plaintext); !errors.Is(err, models.ErrRecordNotFound) {
+		t.Errorf("activation token still valid: got %v, want %v", err, models.ErrRecordNotFound)
+	}
+
+	result, err = runTestCommand(t, app, "users", "grant", "bob@example.com", "users:admin", "films:delete")
+	if err == nil {
+		t.Error("granting an unknown permission succeeded")
+	}
+
+	permissions = result["permissions"].(models.Permissions)
+	if !permissions.Include("users:admin") || permissions.Include("films:delete") {
+		t.Errorf("got permissions %v, want users:admin granted and films:delete ignored", permissions)
+	}
+
+	if _, err := runTestCommand(t, app, "users", "activate", "nobody@example.com"); err == nil {
+		t.Error("activated a user who doesn't exist")
+	}
+
+	var actions []string
+	for _, event := range store.AuditEvents() {
+		if event.ActorID != nil {
+			t.Errorf("audit event %q has actor %d, want none", event.Action, *event.ActorID)
+		}
+		actions = append(actions, event.Action)
+	}
+
+	if !slices.Contains(actions, "user.activate") || !slices.Contains(actions, "permissions.grant") {
+		t.Errorf("got audit events %v, want user.activate and permissions.grant", actions)
+	}
+}
+
+// TestPurgeTokensCommand tests that only expired tokens are purged
+func TestPurgeTokensCommand(t *testing.T) {
+	app, _, token := newMemoryApp(t)
+	ctx := context.Background()
+
+	user, err := app.models.Users.GetForToken(ctx, models.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.models.Tokens.New(ctx, user.ID, -time.Hour, models.ScopeAuthentication); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := runTestCommand(t, app, "tokens", "purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result["deleted"] != int64(1) {
+		t.Errorf("got %v deleted, want 1", result["deleted"])
+	}
+
+	if _, err := app.models.Users.GetForToken(ctx, models.ScopeAuthentication, token); err != nil {
+		t.Errorf("unexpired token purged: %v", err)
+	}
+}
+
+// TestFilmsImportExport tests that films are validated on import and that an export can be imported again
+func TestFilmsImportExport(t *testing.T) {
+	app, _, _ := newMemoryApp(t)
+
+	result, err := runTestCommand(t, app, "films", "import", writeFilmsJSON(t, testFilmsJSON))
+	if !errors.Is(err, errImportIncomplete) {
+		t.Errorf("got error %v, want %v", err, errImportIncomplete)
+	}
+
+	if result["imported"] != 2 {
+		t.Errorf("got %v imported, want 2", result["imported"])
+	}
+
+	body, err := json.Marshal(result["failed"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []struct {
+		Index int               `json:"index"`
+		Error map[string]string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &failed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 1 || failed[0].Index != 1 || failed[0].Error["title"] == "" {
+		t.Errorf("got failures %s, want film 1 rejected for its title", body)
+	}
+
+	export := filepath.Join(t.TempDir(), "export.json")
+
+	result, err = runTestCommand(t, app, "films", "export", export)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result["exported"] != 2 {
+		t.Errorf("got %v exported, want 2", result["exported"])
+	}
+
+	other, _, _ := newMemoryApp(t)
+
+	if _, err := runTestCommand(t, other, "films", "import", export); err != nil {
+		t.Fatal(err)
+	}
+
+	films, err := other.allFilms(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(films) != 2 || films[0].Title != "Inception" || films[1].Runtime != 170 || films[0].Directors[0].Name != "Christopher Nolan" {
+		t.Errorf("got films %+v after importing the export, want Inception and Heat", films)
+	}
+}
+
+// TestSeedCommand tests that seeding only imports into an empty database
+func TestSeedCommand(t *testing.T) {
+	app, _, _ := newMemoryApp(t)
+	file := writeFilmsJSON(t, `[{"title": "Heat", "year": 1995, "runtime": "170 mins", "genres": ["crime"], "image": "https://example.com/heat.jpg"}]`)
+
+	result, err := runTestCommand(t, app, "seed", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result["imported"] != 1 {
+		t.Errorf("got %v imported, want 1", result["imported"])
+	}
+
+	result, err = runTestCommand(t, app, "seed", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result["skipped"] != true {
+		t.Errorf("got %v, want the second seed skipped", result)
+	}
+
+	count, err := app.models.Films.Count(context.Background())
+	if err != nil || count != 1 {
+		t.Errorf("got %d films (error %v), want 1", count, err)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"filmapi.zeyadtarek.net/internals/codec"
+	"github.com/andybalholm/brotli"
+)
+
+// compressionEncodings are the content codings the API can apply, in order
+// of preference when the client accepts several equally.
+var compressionEncodings = []string{"br", "gzip"}
+
+// compress compresses responses of at least config.compression.minSize bytes
+// with the best coding the client's Accept-Encoding allows. Smaller
+// responses aren't worth the CPU and usually end up no smaller.
+//
+// ETags aren't touched here. Handlers already include the coding in strong
+// ETags, using contentCoding to tell which one this middleware will apply.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: app.config.compression.minSize}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// contentCoding returns the content coding compress applies to a response
+// of size bytes to r, or "" if the response is sent as it is.
+func (app *application) contentCoding(r *http.Request, size int) string {
+	if !app.config.compression.enabled || size < app.config.compression.minSize {
+		return ""
+	}
+
+	return negotiateEncoding(r.Header.Get("Accept-Encoding"))
+}
+
+// negotiateEncoding returns the content coding to use for an Accept-Encoding
+// header, or "" to send the response as it is.
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+
+	preferences := codec.ParseQuality(header)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range compressionEncodings {
+		q, found := 0.0, false
+		for _, preference := range preferences {
+			if preference.Value == encoding {
+				q, found = preference.Q, true
+				break
+			}
+			if preference.Value == "*" && !found {
+				q = preference.Q
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the response is big enough to compress, then either compresses the rest
+// or passes it through.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	// Like http.ResponseWriter, ignore a second status.
+	if cw.status != 0 {
+		return
+	}
+
+	// Informational responses don't end the header.
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	cw.status = status
+
+	// Bodiless responses have nothing to compress.
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.minSize {
+			cw.decide(true)
+		}
+		return len(p), nil
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(p)
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far. A response flushed before it
+// reaches the minimum size is sent uncompressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(len(cw.buf) >= cw.minSize)
+	}
+
+	if flusher, ok := cw.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the header, compressing the body if big is true and the
+// response can be compressed, followed by anything buffered.
+func (cw *compressWriter) decide(big bool) {
+	cw.decided = true
+	header := cw.Header()
+
+	compressible := cw.compressible()
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if big && compressible && cw.encoding != "" {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		switch cw.encoding {
+		case "br":
+			cw.writer = brotli.NewWriterLevel(cw.ResponseWriter, brotli.DefaultCompression)
+		case "gzip":
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	if len(cw.buf) > 0 {
+		if cw.writer != nil {
+			cw.writer.Write(cw.buf)
+		} else {
+			cw.ResponseWriter.Write(cw.buf)
+		}
+		cw.buf = nil
+	}
+}
+
+// compressible reports whether the response's content may be compressed.
+// Event streams are left alone, since a compressor holds back data that
+// should reach the client straight away.
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := header.Get("Content-Type")
+	return contentType != "" && !strings.HasPrefix(contentType, "text/event-stream")
+}
+
+// close finishes the response once the handler has returned.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// A handler that wrote nothing leaves the status to the server.
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		cw.decide(len(cw.buf) >= cw.minSize)
+	}
+
+	if cw.writer != nil {
+		cw.writer.Close()
+	}
+}
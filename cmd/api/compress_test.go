@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"github.com/andybalholm/brotli"
+)
+
+// TestNegotiateEncoding tests that the best accepted coding is chosen
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "gzip", want: "gzip"},
+		{header: "gzip, deflate, br", want: "br"},
+		{header: "br;q=0.5, gzip", want: "gzip"},
+		{header: "*", want: "br"},
+		{header: "*, br;q=0", want: "gzip"},
+		{header: "gzip;q=0", want: ""},
+		{header: "deflate, identity", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := negotiateEncoding(tt.header); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompress tests that large responses are compressed with the negotiated
+// coding and that small, bodiless and streamed responses are not
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title":"Heat"},`, 200)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		status         int
+		body           string
+		wantEncoding   string
+	}{
+		{name: "Gzip", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusOK, body: large, wantEncoding: "gzip"},
+		{name: "Brotli", acceptEncoding: "gzip, br", contentType: "application/json", status: http.StatusOK, body: large, wantEncoding: "br"},
+		{name: "Below minimum", acceptEncoding: "gzip", contentType: "application/json", status: http.StatusOK, body: `{"title":"Heat"}`},
+		{name: "Not accepted", contentType: "application/json", status: http.StatusOK, body: large},
+		{name: "Event stream", acceptEncoding: "gzip", contentType: "text/event-stream", status: http.StatusOK, body: large},
+		{name: "Not modified", acceptEncoding: "gzip", status: http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+			app.config.compression.minSize = 1024
+
+			handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+				// Write in pieces to cross the threshold part way.
+				for i := 0; i < len(tt.body); i += 100 {
+					w.Write([]byte(tt.body[i:min(i+100, len(tt.body))]))
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/films", nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("got status %d, want %d", rr.Code, tt.status)
+			}
+
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("got Content-Encoding %q, want %q", got, tt.wantEncoding)
+			}
+
+			var reader io.Reader = rr.Body
+			switch tt.wantEncoding {
+			case "gzip":
+				gz, err := gzip.NewReader(rr.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gz
+			case "br":
+				reader = brotli.NewReader(rr.Body)
+			}
+
+			body, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tt.body {
+				t.Errorf("got body of %d bytes, want %d", len(body), len(tt.body))
+			}
+
+			wantVary := tt.contentType == "application/json"
+			if gotVary := strings.Contains(strings.Join(rr.Header().Values("Vary"), ","), "Accept-Encoding"); gotVary != wantVary {
+				t.Errorf("Vary: Accept-Encoding is %v, want %v", gotVary, wantVary)
+			}
+		})
+	}
+}
+
+// TestCompressFlush tests that a flushed response goes out straight away
+func TestCompressFlush(t *testing.T) {
+	app := &application{}
+	app.config.compression.minSize = 1024
+
+	flushed := make(chan string, 1)
+	handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("partial"))
+		http.NewResponseController(w).Flush()
+		flushed <- w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.String()
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := <-flushed; got != "partial" {
+		t.Errorf("got %q written after Flush, want %q", got, "partial")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// filmETag returns the strong ETag of a film. The version is bumped on every
+// change, so together with the ID it identifies the film's state; responses
+// add the representation with representationETag.
+func filmETag(film *models.Film) string {
+	return fmt.Sprintf(`"%d-%d"`, film.ID, film.Version)
+}
+
+// watchlistETag returns the strong ETag of a watchlist entry. Entries embed
+// their film, so the film's version is part of it too.
+func watchlistETag(entry *models.Watchlist) string {
+	var filmVersion int32
+	if entry.Film != nil {
+		filmVersion = entry.Film.Version
+	}
+
+	return fmt.Sprintf(`"%d-%d-%d"`, entry.ID, entry.Version, filmVersion)
+}
+
+// representationETag extends the strong ETag of a resource's state to the
+// representation a response to r carries: a media type other than JSON and
+// the content coding compress will apply to size bytes are appended, as in
+// "42-3+cbor+br", so that no two representations share a strong ETag. Weak
+// ETags are returned as they are, since every format and coding of a body
+// is equivalent.
+func (app *application) representationETag(r *http.Request, etag, contentType string, size int) string {
+	if strings.HasPrefix(etag, "W/") || len(etag) < 2 {
+		return etag
+	}
+
+	var suffix string
+	if mediaType, _, _ := strings.Cut(contentType, ";"); mediaType != "application/json" {
+		_, subtype, _ := strings.Cut(mediaType, "/")
+		suffix += "+" + subtype
+	}
+
+	if coding := app.contentCoding(r, size); coding != "" {
+		suffix += "+" + coding
+	}
+
+	return etag[:len(etag)-1] + suffix + `"`
+}
+
+// stateETags strips the representation from each ETag in header, leaving
+// the ETags of the states they were taken from.
+func stateETags(header string) string {
+	candidates := strings.Split(header, ",")
+	for i, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if before, _, found := strings.Cut(candidate, "+"); found && strings.HasSuffix(candidate, `"`) {
+			candidate = before + `"`
+		}
+		candidates[i] = candidate
+	}
+
+	return strings.Join(candidates, ", ")
+}
+
+// weakETag returns a weak ETag derived from a response body, for listings
+// that have no version of their own.
+func weakETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether etag is in header, a comma-separated list of
+// ETags or "*". Weak comparison, used for If-None-Match, ignores the W/
+// prefix; strong comparison, used for If-Match, never matches a weak ETag.
+func etagMatches(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSONWithETag writes data like writeJSON, tagged with etag extended to
+// the representation, or if etag is empty with a weak ETag of the encoded
+// body. A GET whose If-None-Match matches gets 304 Not Modified instead.
+func (app *application) writeJSONWithETag(w http.ResponseWriter, r *http.Request, status int, data any, etag string) error {
+	contentType, body, err := encodeResponse(r, data)
+	if err != nil {
+		return err
+	}
+
+	if etag == "" {
+		etag = weakETag(body)
+	} else {
+		etag = app.representationETag(r, etag, contentType, len(body))
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+			// compress only adds this to responses with content, but the
+			// 304 must vary the same way as the 200 it stands in for.
+			if app.config.compression.enabled {
+				w.Header().Add("Vary", "Accept-Encoding")
+			}
+
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(body)
+
+	return nil
+}
+
+// checkIfMatch enforces an If-Match header against the current ETag of the
+// resource about to be changed. The change applies to the resource, not to
+// one representation of it, so an ETag of any representation of the current
+// state matches. When it returns false the request has already been answered
+// with 412 Precondition Failed.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" || etagMatches(stateETags(match), etag, false) {
+		return true
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
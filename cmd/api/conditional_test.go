@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestETags tests that resource ETags change with the version and that
+// watchlist entries follow their film
+func TestETags(t *testing.T) {
+	film := &models.Film{ID: 42, Version: 3}
+	if got := filmETag(film); got != `"42-3"` {
+		t.Errorf("filmETag() = %s, want \"42-3\"", got)
+	}
+
+	entry := &models.Watchlist{ID: 7, Version: 2, Film: film}
+	before := watchlistETag(entry)
+	film.Version++
+	if watchlistETag(entry) == before {
+		t.Error("watchlist ETag didn't change with its film")
+	}
+
+	if weakETag([]byte("a")) != weakETag([]byte("a")) || weakETag([]byte("a")) == weakETag([]byte("b")) {
+		t.Error("weak ETags don't follow the body")
+	}
+}
+
+// TestETagMatches tests weak and strong comparison against ETag lists
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{name: "Exact", header: `"1-2"`, etag: `"1-2"`, want: true},
+		{name: "Different", header: `"1-1"`, etag: `"1-2"`, want: false},
+		{name: "List", header: `"1-1", "1-2"`, etag: `"1-2"`, want: true},
+		{name: "Any", header: `*`, etag: `"1-2"`, want: true},
+		{name: "Weak header, strong comparison", header: `W/"1-2"`, etag: `"1-2"`, want: false},
+		{name: "Weak header, weak comparison", header: `W/"1-2"`, etag: `"1-2"`, weak: true, want: true},
+		{name: "Weak ETag, weak comparison", header: `"abc"`, etag: `W/"abc"`, weak: true, want: true},
+		{name: "Weak ETag, strong comparison", header: `W/"abc"`, etag: `W/"abc"`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := etagMatches(tt.header, tt.etag, tt.weak); got != tt.want {
+				t.Errorf("etagMatches(%s, %s, %v) = %v, want %v", tt.header, tt.etag, tt.weak, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWriteJSONWithETag tests that responses are tagged and that a matching
+// If-None-Match gets 304 with no body
+func TestWriteJSONWithETag(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+	data := map[string]any{"films": []string{"Heat"}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/v1/films", nil)
+	if err := app.writeJSONWithETag(rr, req, http.StatusOK, data, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || len(etag) < 3 || etag[:2] != "W/" {
+		t.Fatalf("got status %d and ETag %q, want 200 and a weak ETag", rr.Code, etag)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{name: "Matching", method: http.MethodGet, ifNoneMatch: etag, wantStatus: http.StatusNotModified},
+		{name: "Stale", method: http.MethodGet, ifNoneMatch: `W/"stale"`, wantStatus: http.StatusOK},
+		{name: "Not a read", method: http.MethodPost, ifNoneMatch: etag, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/v1/films", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+
+			if err := app.writeJSONWithETag(rr, req, http.StatusOK, data, ""); err != nil {
+				t.Fatal(err)
+			}
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("304 response has a body: %q", rr.Body.String())
+			}
+			if rr.Header().Get("ETag") != etag {
+				t.Errorf("got ETag %q, want %q", rr.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
+
+// TestCheckIfMatch tests that a stale If-Match is refused with 412 and that
+// requests without one go ahead
+func TestCheckIfMatch(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantOK     bool
+		wantStatus int
+	}{
+		{name: "No header", wantOK: true, wantStatus: http.StatusOK},
+		{name: "Current", ifMatch: `"1-2"`, wantOK: true, wantStatus: http.StatusOK},
+		{name: "Current in another representation", ifMatch: `"1-1", "1-2+cbor+gzip"`, wantOK: true, wantStatus: http.StatusOK},
+		{name: "Stale in another representation", ifMatch: `"1-1+msgpack"`, wantOK: false, wantStatus: http.StatusPreconditionFailed},
+		{name: "Stale", ifMatch: `"1-1"`, wantOK: false, wantStatus: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPatch, "/v1/films/1", nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			if got := app.checkIfMatch(rr, req, `"1-2"`); got != tt.wantOK {
+				t.Errorf("checkIfMatch() = %v, want %v", got, tt.wantOK)
+			}
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestRepresentationETag tests that strong ETags name the media type and
+// content coding of the response and that weak ones are left alone
+func TestRepresentationETag(t *testing.T) {
+	app := &application{}
+	app.config.compression.enabled = true
+	app.config.compression.minSize = 100
+
+	tests := []struct {
+		name           string
+		etag           string
+		accept         string
+		acceptEncoding string
+		size           int
+		want           string
+	}{
+		{name: "JSON", etag: `"42-3"`, acceptEncoding: "gzip", size: 99, want: `"42-3"`},
+		{name: "CBOR", etag: `"42-3"`, accept: "application/cbor", size: 99, want: `"42-3+cbor"`},
+		{name: "Gzip", etag: `"42-3"`, acceptEncoding: "gzip", size: 100, want: `"42-3+gzip"`},
+		{name: "MessagePack and Brotli", etag: `"42-3"`, accept: "application/msgpack", acceptEncoding: "gzip, br", size: 100, want: `"42-3+msgpack+br"`},
+		{name: "Weak", etag: `W/"abc"`, accept: "application/cbor", acceptEncoding: "br", size: 100, want: `W/"abc"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/films/42", nil)
+			req.Header.Set("Accept", tt.accept)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			contentType := encoders.Negotiate(tt.accept).ContentType()
+			if got := app.representationETag(req, tt.etag, contentType, tt.size); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFilmETagsByRepresentation tests that each format and coding of a film
+// is revalidated on its own, and that any of them can be sent in If-Match
+func TestFilmETagsByRepresentation(t *testing.T) {
+	app, _, token := newMemoryApp(t, "films:read", "films:write")
+	app.config.compression.enabled = true
+	app.config.compression.minSize = 1
+	film := addMemoryFilm(t, app, "Inception")
+	target := fmt.Sprintf("/v1/films/%d", film.ID)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		return serve(t, app, http.MethodGet, target, token, "", header)
+	}
+
+	jsonTag := get(nil).Header().Get("ETag")
+	cborTag := get(http.Header{"Accept": {"application/cbor"}}).Header().Get("ETag")
+	gzipTag := get(http.Header{"Accept-Encoding": {"gzip"}}).Header().Get("ETag")
+
+	if jsonTag == cborTag || jsonTag == gzipTag || cborTag == gzipTag {
+		t.Fatalf("got ETags %s, %s and %s, want one per representation", jsonTag, cborTag, gzipTag)
+	}
+
+	if rr := get(http.Header{"Accept": {"application/cbor"}, "If-None-Match": {jsonTag}}); rr.Code != http.StatusOK {
+		t.Errorf("revalidating CBOR with the JSON ETag: got status %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	rr := get(http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipTag}})
+	if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != gzipTag {
+		t.Errorf("revalidating gzip: got status %d and ETag %s, want %d and %s", rr.Code, rr.Header().Get("ETag"), http.StatusNotModified, gzipTag)
+	}
+
+	if vary := rr.Header().Values("Vary"); !slices.Contains(vary, "Accept") || !slices.Contains(vary, "Accept-Encoding") {
+		t.Errorf("revalidating gzip: got Vary %v, want Accept and Accept-Encoding", vary)
+	}
+
+	rr = serve(t, app, http.MethodPatch, target, token, `{"year": 2011}`, http.Header{"If-Match": {cborTag}})
+	if rr.Code != http.StatusOK {
+		t.Errorf("updating with the CBOR ETag: got status %d, want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+}
@@ -9,9 +9,25 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	apiKeyContextKey      = contextKey("apiKey")
+	requestInfoContextKey = contextKey("requestInfo")
+)
+
+// requestInfo is shared by every handler a request passes through. It's a
+// pointer, so middleware that runs before authentication can still see who
+// the request turned out to be from once the handlers have finished.
+type requestInfo struct {
+	id   string
+	user *models.User
+}
 
 func (app *application) contextSetUser(r *http.Request, user *models.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.user = user
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -24,3 +40,31 @@ func (app *application) contextGetUser(r *http.Request) *models.User {
 
 	return user
 }
+
+func (app *application) contextSetAPIKey(r *http.Request, key *models.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, or
+// nil when the request used a bearer token or no credentials at all.
+func (app *application) contextGetAPIKey(r *http.Request) *models.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	return key
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the ID the requestID middleware gave the
+// request, or "" outside of it.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return ""
+	}
+
+	return info.id
+}
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
 )
 
+// statusClientClosedRequest is nginx's status for a request the client gave
+// up on before it was answered. The client never sees it, but it keeps
+// abandoned requests apart from real failures in logs and metrics.
+const statusClientClosedRequest = 499
+
 func (app *application) faliedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -13,6 +25,11 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since it was fetched, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -40,7 +57,73 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) accountSuspendedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid, expired or revoked api key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an api key, use an authentication token instead"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidLoginStateResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired login attempt, please start the login again"
+	app.errorResponse(w, r, http.StatusBadRequest, message)
+}
+
+func (app *application) unverifiedEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your identity provider hasn't verified your email address"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) noLinkedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "there is no account linked to this identity, please register first"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidSecondFactorResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or already used authentication code"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
+// queryCanceledResponse answers a request whose query was canceled. A query
+// that ran past its timeout is the server's problem but a temporary one, so
+// it's 503 rather than 500. One canceled because the client went away gets
+// 499, which nobody reads.
+func (app *application) queryCanceledResponse(w http.ResponseWriter, r *http.Request, err error) {
+	fields := []jsonlog.Field{
+		jsonlog.Err(err),
+		jsonlog.String("request_method", r.Method),
+		jsonlog.String("request_url", r.URL.String()),
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil {
+		app.requestLogger(r).Warn("query timed out", fields...)
+
+		w.Header().Set("Retry-After", "1")
+		message := "the database took too long to respond, please try again"
+		app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+		return
+	}
+
+	app.requestLogger(r).Debug("request canceled by client", fields...)
+	app.errorResponse(w, r, statusClientClosedRequest, "the request was canceled")
+}
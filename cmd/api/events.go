@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/events"
+)
+
+// eventsKeepAlive is how often an idle stream gets a comment line, so
+// proxies don't close it.
+const eventsKeepAlive = 15 * time.Second
+
+// eventsBackfillBatch is how many stored events are read at a time when a
+// client resumes.
+const eventsBackfillBatch = 500
+
+// streamEventsHandler sends catalogue changes, and the caller's own watchlist
+// changes, as Server-Sent Events. A client that reconnects with
+// Last-Event-ID first receives what it missed, as long as it's still stored;
+// otherwise it gets a reset event and should reload what it has cached.
+func (app *application) streamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if app.events == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	lastID, ok := readLastEventID(r)
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("Last-Event-ID must be a non-negative integer"))
+		return
+	}
+
+	// The stream outlives the server's WriteTimeout, so lift the deadline
+	// for this response.
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sub := app.events.Subscribe()
+	defer sub.Close()
+
+	user := app.contextGetUser(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	cursor := sub.From
+	if lastID >= 0 && lastID < sub.From {
+		cursor, err = app.backfillEvents(r.Context(), w, lastID, sub.From, user.ID)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	err = rc.Flush()
+	if err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-sub.C:
+			// Closed because the server is shutting down or the client
+			// fell behind; either way it should reconnect and resume.
+			if !ok {
+				return
+			}
+
+			if event.ID <= cursor || !event.VisibleTo(user.ID) {
+				continue
+			}
+
+			cursor = event.ID
+			err = writeEvent(w, event)
+			if err != nil {
+				return
+			}
+		}
+
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// backfillEvents writes the stored events after lastID up to and including
+// until, and returns the ID of the last one considered. If events after
+// lastID have already been pruned it writes a reset event first.
+func (app *application) backfillEvents(ctx context.Context, w http.ResponseWriter, lastID, until, userID int64) (int64, error) {
+	source := app.events.Source()
+
+	oldest, err := source.Oldest(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if lastID+1 < oldest {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		lastID = oldest - 1
+	}
+
+	for lastID < until {
+		batch, err := source.After(ctx, lastID, eventsBackfillBatch)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, event := range batch {
+			if event.ID > until {
+				return until, nil
+			}
+
+			lastID = event.ID
+			if !event.VisibleTo(userID) {
+				continue
+			}
+
+			err = writeEvent(w, event)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return until, nil
+}
+
+// readLastEventID returns the ID a reconnecting client last received, from
+// the Last-Event-ID header or, for clients that can't set headers, the
+// last_event_id query parameter. It returns -1 if neither is set.
+func readLastEventID(r *http.Request) (int64, bool) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+
+	if value == "" {
+		return -1, true
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(map[string]any{
+		"id":          event.ID,
+		"event":       event.Name,
+		"occurred_at": event.CreatedAt,
+		"data":        event.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Name, data)
+	return err
+}
+
+// pruneChangeEventsJob deletes stored events older than the resume window.
+func (app *application) pruneChangeEventsJob(ctx context.Context) error {
+	pruned, err := app.events.Source().Prune(ctx, time.Now().Add(-app.config.events.retention))
+	if err != nil {
+		return err
+	}
+
+	if pruned > 0 {
+		app.logger.PrintInfo("pruned change events", map[string]string{
+			"events": strconv.FormatInt(pruned, 10),
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/events"
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// newStoppedBroker returns a broker that has caught up with source and then
+// stopped, so streams backfill and return straight away.
+func newStoppedBroker(t *testing.T, source events.Source) *events.Broker {
+	t.Helper()
+
+	broker := events.NewBroker(source, jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo), events.Config{PollInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wake := make(chan struct{})
+	done := make(chan error)
+
+	go func() { done <- broker.Run(ctx, wake) }()
+	wake <- struct{}{}
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	return broker
+}
+
+// TestStreamEventsHandler tests that a resuming client receives the events it
+// missed that it may see, and a reset when they have been pruned
+func TestStreamEventsHandler(t *testing.T) {
+	owner := int64(1)
+	other := int64(2)
+
+	source := events.NewMemorySource()
+	source.Append("film.created", nil, `{"id":1}`)
+	source.Append("watchlist.added", &other, `{"id":1}`)
+	source.Append("watchlist.added", &owner, `{"id":2}`)
+	source.Append("film.updated", nil, `{"id":1}`)
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		query       string
+		wantStatus  int
+		wantIDs     []string
+		notIDs      []string
+		wantReset   bool
+	}{
+		{name: "New stream", wantStatus: http.StatusOK, notIDs: []string{"1", "2", "3", "4"}},
+		{name: "Resume", lastEventID: "1", wantStatus: http.StatusOK, wantIDs: []string{"3", "4"}, notIDs: []string{"1", "2"}},
+		{name: "Resume from query", query: "?last_event_id=3", wantStatus: http.StatusOK, wantIDs: []string{"4"}, notIDs: []string{"3"}},
+		{name: "Up to date", lastEventID: "4", wantStatus: http.StatusOK, notIDs: []string{"4"}},
+		{name: "Invalid ID", lastEventID: "abc", wantStatus: http.StatusBadRequest},
+		{name: "Negative ID", lastEventID: "-1", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+				events: newStoppedBroker(t, source),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/events"+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			req = app.contextSetUser(req, &models.User{ID: owner})
+			rr := httptest.NewRecorder()
+
+			app.streamEventsHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			if got := rr.Header().Get("Content-Type"); got != "text/event-stream" {
+				t.Errorf("got Content-Type %q, want text/event-stream", got)
+			}
+
+			body := rr.Body.String()
+			if !strings.HasPrefix(body, "retry: 3000\n\n") {
+				t.Errorf("body doesn't start with a retry interval: %q", body)
+			}
+			for _, id := range tt.wantIDs {
+				if !strings.Contains(body, "id: "+id+"\n") {
+					t.Errorf("event %s missing from %q", id, body)
+				}
+			}
+			for _, id := range tt.notIDs {
+				if strings.Contains(body, "id: "+id+"\n") {
+					t.Errorf("unexpected event %s in %q", id, body)
+				}
+			}
+			if strings.Contains(body, "event: reset") {
+				t.Errorf("unexpected reset in %q", body)
+			}
+		})
+	}
+}
+
+// TestStreamEventsHandlerReset tests that a client resuming from before the
+// oldest stored event is told to reset
+func TestStreamEventsHandlerReset(t *testing.T) {
+	source := events.NewMemorySource()
+	source.Append("film.created", nil, `{"id":1}`)
+	source.Append("film.created", nil, `{"id":2}`)
+	source.Prune(context.Background(), time.Now().Add(time.Hour))
+	source.Append("film.updated", nil, `{"id":2}`)
+
+	app := &application{
+		logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+		events: newStoppedBroker(t, source),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	req = app.contextSetUser(req, &models.User{ID: 1})
+	rr := httptest.NewRecorder()
+
+	app.streamEventsHandler(rr, req)
+
+	body := rr.Body.String()
+	reset := strings.Index(body, "event: reset\n")
+	event := strings.Index(body, "id: 3\n")
+	if reset == -1 || event == -1 || reset > event {
+		t.Errorf("want a reset followed by event 3, got %q", body)
+	}
+}
+
+// TestStreamEventsHandlerDisabled tests that the stream is missing when no
+// broker is running
+func TestStreamEventsHandlerDisabled(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+	rr := httptest.NewRecorder()
+
+	app.streamEventsHandler(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"filmapi.zeyadtarek.net/internals/models"
@@ -34,6 +35,7 @@ Welcome to the Film API - Your Gateway to Cinema Data!
    GET    /v1/films/{id}      - Get film by ID
    PATCH  /v1/films/{id}      - Update film
    DELETE /v1/films/{id}      - Delete film
+   GET    /v1/events          - Live stream of changes (Server-Sent Events)
 
 🎯 Films Filtering & Searching:
    • Title Search:
@@ -68,7 +70,43 @@ Welcome to the Film API - Your Gateway to Cinema Data!
 👤 User Endpoints:
    POST   /v1/users           - Register new user
    PUT    /v1/users/activate  - Activate user account
+   POST   /v1/tokens/activation - Get a new activation token
    POST   /v1/tokens/authentication - Login
+   POST   /v1/tokens/2fa      - Finish a login with an authenticator code
+   GET    /v1/users/me        - Show your account
+   PATCH  /v1/users/me        - Change your name or email
+   PUT    /v1/users/me/password - Change your password
+   DELETE /v1/users/me        - Delete your account
+
+🛡️ Two-Factor Authentication:
+   POST   /v1/users/me/totp          - Start authenticator enrolment
+   POST   /v1/users/me/totp/confirm  - Confirm enrolment, get recovery codes
+   DELETE /v1/users/me/totp          - Turn two-factor authentication off
+
+🔑 API Key Endpoints:
+   GET    /v1/users/me/api-keys      - List your API keys
+   POST   /v1/users/me/api-keys      - Create a named, scoped API key
+   DELETE /v1/users/me/api-keys/{id} - Revoke an API key
+
+🪝 Webhook Endpoints (webhooks:manage):
+   GET    /v1/webhooks                 - List your webhooks
+   POST   /v1/webhooks                 - Subscribe a URL to events
+   GET    /v1/webhooks/{id}            - Show a webhook
+   PATCH  /v1/webhooks/{id}            - Change a webhook's URL, events or state
+   DELETE /v1/webhooks/{id}            - Delete a webhook
+   GET    /v1/webhooks/{id}/deliveries - Delivery log
+   POST   /v1/webhooks/{id}/deliveries/{delivery_id}/redeliver - Send again
+
+🛠️ Admin Endpoints (users:admin):
+   GET    /v1/admin/users            - Search and list users
+   GET    /v1/admin/users/{id}       - Show a user and their permissions
+   PATCH  /v1/admin/users/{id}       - Activate or suspend a user
+   POST   /v1/admin/users/{id}/logout - Sign a user out everywhere
+   GET    /v1/admin/audit            - Query the audit log
+   GET    /v1/admin/jobs             - Background job status
+   GET    /v1/admin/cache            - Film cache hit and miss counts
+   GET    /v1/admin/tasks/dead       - List dead-lettered tasks
+   POST   /v1/admin/tasks/{id}/retry - Retry a dead-lettered task
 
 📋 Watchlist Endpoints:
    GET    /v1/watchlist       - Get user's watchlist
@@ -95,6 +133,7 @@ Welcome to the Film API - Your Gateway to Cinema Data!
 ═══════════════════════════════════════════════════════════════
 
 🔐 Authentication: Most endpoints require authentication tokens
+   (Authorization: Bearer <token>) or an API key (X-API-Key: <key>)
 📄 Format: All responses are in JSON format
 🌍 Version: v1
 📊 Status: Online and Ready
@@ -115,21 +154,10 @@ Happy Coding! 🚀
 	w.Write([]byte(welcomeText))
 }
 
+// healthCheckHandler is the original liveness endpoint, kept for clients
+// that still use it.
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
-	env := map[string]any{
-		"status": "available",
-		"system_info": map[string]string{
-			"environment": app.config.env,
-			"version":     "1",
-		},
-	}
-
-	err := app.writeJSON(w, http.StatusOK, env, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
+	app.healthzHandler(w, r)
 }
 
 func (app *application) getFilmHandler(w http.ResponseWriter, r *http.Request) {
@@ -140,7 +168,7 @@ func (app *application) getFilmHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	film, err := app.models.Films.Get(int64(id))
+	film, err := app.models.Films.Get(r.Context(), int64(id))
 	if err != nil {
 		if errors.Is(err, models.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
@@ -153,7 +181,7 @@ func (app *application) getFilmHandler(w http.ResponseWriter, r *http.Request) {
 	env := map[string]any{
 		"film": *film,
 	}
-	err = app.writeJSON(w, http.StatusOK, env, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, env, filmETag(film))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -214,8 +242,14 @@ func (app *application) createFilmHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	event, err := app.newAuditEvent(r, "film.create", "film", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Insert the film and its relationships
-	err = app.models.Films.Insert(film)
+	err = app.models.Films.WithAudit(event).Insert(r.Context(), film)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -223,8 +257,9 @@ func (app *application) createFilmHandler(w http.ResponseWriter, r *http.Request
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/films/%d", film.ID))
+	headers.Set("ETag", filmETag(film))
 
-	err = app.writeJSON(w, http.StatusCreated, map[string]any{"film": film}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"film": film}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -240,12 +275,16 @@ func (app *application) updateFilmHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Fetch the latest version of the film
-	film, err := app.models.Films.Get(id)
+	film, err := app.models.Films.Get(r.Context(), id)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
+	if !app.checkIfMatch(w, r, filmETag(film)) {
+		return
+	}
+
 	var input struct {
 		Title       *string         `json:"title"`
 		Year        *int32          `json:"year"`
@@ -264,6 +303,12 @@ func (app *application) updateFilmHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	event, err := app.newAuditEvent(r, "film.update", "film", film)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Apply partial updates
 	if input.Title != nil {
 		film.Title = *input.Title
@@ -303,9 +348,12 @@ func (app *application) updateFilmHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	// Retry the update
-	err = app.models.Films.Update(film)
+	err = app.models.Films.WithAudit(event).Update(r.Context(), film)
 	if err != nil {
 		switch {
+		// The film changed after If-Match was checked.
+		case errors.Is(err, models.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, models.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -314,7 +362,10 @@ func (app *application) updateFilmHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"film": film}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", filmETag(film))
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"film": film}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -328,19 +379,41 @@ func (app *application) deleteFilmHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Films.Delete(int64(id))
+	film, err := app.models.Films.Get(r.Context(), int64(id))
 	if err != nil {
 		if errors.Is(err, models.ErrRecordNotFound) {
 			app.notFoundResponse(w, r)
-			return
 		} else {
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, filmETag(film)) {
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "film.delete", "film", film)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	err = app.models.Films.WithAudit(event).Delete(r.Context(), film)
+	if err != nil {
+		switch {
+		// The film changed or was deleted after If-Match was checked.
+		case errors.Is(err, models.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"message": "movie deleted succesfully"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "movie deleted succesfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -373,13 +446,13 @@ func (app *application) ListFilmsHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	films, metadata, err := app.models.Films.GetAll(input.Title, input.Genres, input.Actors, input.Directors, input.Filters)
+	films, metadata, err := app.models.Films.GetAll(r.Context(), input.Title, input.Genres, input.Actors, input.Directors, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"films": films, "metadata": metadata}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, map[string]any{"films": films, "metadata": metadata}, "")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -413,12 +486,14 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	v := validator.New()
-	if models.ValidateUser(v, user); !v.Valid() {
+	models.ValidateUserDetails(v, user)
+	app.passwordPolicy.Check(v, "password", input.Password, user.Name, user.Email)
+	if !v.Valid() {
 		app.faliedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.models.Users.Insert(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrDuplicateEmail):
@@ -430,14 +505,20 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "films:read")
+	event, err := app.newAuditEvent(r, "permissions.grant", "user", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Permissions.WithAudit(event).AddForUser(r.Context(), user.ID, "films:read")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
 	// Generate activation token
-	activationToken, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeActivation)
+	activationToken, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -455,7 +536,7 @@ func (app *application) createUserHandler(w http.ResponseWriter, r *http.Request
 		},
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, response, nil)
+	err = app.writeJSON(w, r, http.StatusCreated, response, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -479,7 +560,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(models.ScopeActivation, input.TokenPlaintext)
+	user, err := app.models.Users.GetForToken(r.Context(), models.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -490,8 +571,14 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		}
 		return
 	}
+	event, err := app.newAuditEvent(r, "user.activate", "user", user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	user.Activated = true
-	err = app.models.Users.Update(user)
+	err = app.models.Users.WithAudit(event).Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrEditConflict):
@@ -502,17 +589,111 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(models.ScopeActivation, user.ID)
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), models.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+
+}
+
+// createActivationTokenHandler re-issues an activation token for an account
+// that hasn't been activated yet, replacing any issued before. The token is
+// only handed to someone who knows the account's password, and an unknown
+// email gets the same answer as a wrong password, so the endpoint can't be
+// used to take over or discover accounts. Failures count towards the login
+// lockout like any other password guess.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(len(input.Password) <= 72, "password", "must not be more than 72 bytes long")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	retryAfter, err := app.loginRetryAfter(r.Context(), input.Email, app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.failedLoginResponse(w, r, input.Email)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.failedLoginResponse(w, r, input.Email)
+		return
+	}
+
+	if user.Activated {
+		v.AddError("email", "user has already been activated")
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), models.ScopeActivation, user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"user": user}, nil)
+	activationToken, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	response := map[string]any{
+		"activation_token": struct {
+			Token  string    `json:"token"`
+			Expiry time.Time `json:"expiry"`
+		}{
+			Token:  activationToken.Plaintext,
+			Expiry: activationToken.Expiry,
+		},
 	}
 
+	err = app.writeJSON(w, r, http.StatusCreated, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -529,17 +710,31 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	v := validator.New()
 	models.ValidateEmail(v, input.Email)
-	models.ValidatePasswordPlaintext(v, input.Password)
+	// The password policy can change after a password is set, so only the
+	// limits bcrypt itself imposes are checked here.
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(len(input.Password) <= 72, "password", "must not be more than 72 bytes long")
 	if !v.Valid() {
 		app.faliedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	retryAfter, err := app.loginRetryAfter(r.Context(), input.Email, app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, input.Email)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -553,19 +748,85 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, input.Email)
+		return
+	}
+
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	if app.requireSecondFactor(w, r, user) {
+		return
+	}
+
+	app.issueAuthenticationToken(w, r, user)
+}
+
+// requireSecondFactor answers a login for a user who enrolled an
+// authenticator with a short-lived token that can only be exchanged for an
+// authentication token together with a code. It reports whether it sent a
+// response; if not, the user has no authenticator and the login completes.
+func (app *application) requireSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User) bool {
+	twoFactor, err := app.models.TOTP.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, models.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	if twoFactor == nil || !twoFactor.Enabled() {
+		return false
+	}
+
+	pendingToken, err := app.models.Tokens.New(r.Context(), user.ID, 5*time.Minute, models.ScopeTwoFactorPending)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return true
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, map[string]any{"2fa_pending_token": pendingToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+	return true
+}
+
+// issueAuthenticationToken completes a password login by creating a new
+// authentication token for user and sending it in the response.
+func (app *application) issueAuthenticationToken(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// Only a complete login clears the account's failed attempts; the IP
+	// address keeps its count so one valid account can't launder guesses.
+	err := app.models.Logins.Reset(r.Context(), models.LoginScopeAccount, strings.ToLower(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, models.ScopeAuthentication)
+	event, err := app.newAuditEvent(r, "permissions.grant", "user", nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	app.models.Permissions.AddForUser(user.ID, "films:read")
-	app.models.Permissions.AddForUser(user.ID, "films:write")
-	err = app.writeJSON(w, http.StatusCreated, map[string]any{"authentication_token": token}, nil)
+	err = app.models.Permissions.WithAudit(event).AddForUser(r.Context(), user.ID, "films:read", "films:write")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -589,7 +850,7 @@ func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Req
 	user := app.contextGetUser(r)
 
 	// Check if film exists
-	_, err = app.models.Films.Get(input.FilmID)
+	_, err = app.models.Films.Get(r.Context(), input.FilmID)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -620,7 +881,7 @@ func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.Watchlist.Insert(entry)
+	err = app.models.Watchlist.Insert(r.Context(), entry)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrDuplicateWatchlistEntry):
@@ -633,7 +894,7 @@ func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	// Get the full entry with film details
-	fullEntry, err := app.models.Watchlist.Get(user.ID, entry.ID)
+	fullEntry, err := app.models.Watchlist.Get(r.Context(), user.ID, entry.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -641,8 +902,9 @@ func (app *application) addToWatchlistHandler(w http.ResponseWriter, r *http.Req
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/watchlist/%d", entry.ID))
+	headers.Set("ETag", watchlistETag(fullEntry))
 
-	err = app.writeJSON(w, http.StatusCreated, map[string]any{"watchlist_entry": fullEntry}, headers)
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"watchlist_entry": fullEntry}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -686,13 +948,13 @@ func (app *application) getWatchlistHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	entries, metadata, err := app.models.Watchlist.GetAll(user.ID, input.Watched, input.Priority, input.Filters)
+	entries, metadata, err := app.models.Watchlist.GetAll(r.Context(), user.ID, input.Watched, input.Priority, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"watchlist": entries, "metadata": metadata}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, map[string]any{"watchlist": entries, "metadata": metadata}, "")
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -708,7 +970,7 @@ func (app *application) getWatchlistEntryHandler(w http.ResponseWriter, r *http.
 
 	user := app.contextGetUser(r)
 
-	entry, err := app.models.Watchlist.Get(user.ID, id)
+	entry, err := app.models.Watchlist.Get(r.Context(), user.ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -719,7 +981,7 @@ func (app *application) getWatchlistEntryHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"watchlist_entry": entry}, nil)
+	err = app.writeJSONWithETag(w, r, http.StatusOK, map[string]any{"watchlist_entry": entry}, watchlistETag(entry))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -736,7 +998,7 @@ func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *ht
 	user := app.contextGetUser(r)
 
 	// Get the current entry
-	entry, err := app.models.Watchlist.Get(user.ID, id)
+	entry, err := app.models.Watchlist.Get(r.Context(), user.ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -747,6 +1009,10 @@ func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if !app.checkIfMatch(w, r, watchlistETag(entry)) {
+		return
+	}
+
 	var input struct {
 		Notes    *string `json:"notes"`
 		Priority *int    `json:"priority"`
@@ -795,9 +1061,11 @@ func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = app.models.Watchlist.Update(entry)
+	err = app.models.Watchlist.Update(r.Context(), entry)
 	if err != nil {
 		switch {
+		case errors.Is(err, models.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
 		case errors.Is(err, models.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -807,13 +1075,16 @@ func (app *application) updateWatchlistEntryHandler(w http.ResponseWriter, r *ht
 	}
 
 	// Get the updated entry with film details
-	fullEntry, err := app.models.Watchlist.Get(user.ID, entry.ID)
+	fullEntry, err := app.models.Watchlist.Get(r.Context(), user.ID, entry.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"watchlist_entry": fullEntry}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", watchlistETag(fullEntry))
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"watchlist_entry": fullEntry}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -829,7 +1100,124 @@ func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *htt
 
 	user := app.contextGetUser(r)
 
-	err = app.models.Watchlist.Delete(user.ID, id)
+	entry, err := app.models.Watchlist.Get(r.Context(), user.ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkIfMatch(w, r, watchlistETag(entry)) {
+		return
+	}
+
+	err = app.models.Watchlist.Delete(r.Context(), entry)
+	if err != nil {
+		switch {
+		// The entry changed or was removed after If-Match was checked.
+		case errors.Is(err, models.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "watchlist entry removed successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// API key handlers
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	keys, err := app.models.APIKeys.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"api_keys": keys}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string     `json:"name"`
+		Permissions []string   `json:"permissions"`
+		Expiry      *time.Time `json:"expiry"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	granted, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	key := &models.APIKey{
+		Name:        input.Name,
+		Permissions: input.Permissions,
+		Expiry:      input.Expiry,
+	}
+
+	v := validator.New()
+	if models.ValidateAPIKey(v, key, granted); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key, err = app.models.APIKeys.New(r.Context(), user.ID, key.Name, key.Permissions, key.Expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrDuplicateAPIKeyName):
+			v.AddError("name", "you already have an api key with this name")
+			app.faliedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/me/api-keys/%d", key.ID))
+
+	// The plaintext key is only ever returned here; afterwards just its hash is kept.
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"api_key": key}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.APIKeys.Delete(r.Context(), user.ID, id)
 	if err != nil {
 		switch {
 		case errors.Is(err, models.ErrRecordNotFound):
@@ -840,7 +1228,7 @@ func (app *application) removeFromWatchlistHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, map[string]any{"message": "watchlist entry removed successfully"}, nil)
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "api key revoked successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
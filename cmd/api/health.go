@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// readinessCheck tests one dependency the API needs to serve requests. It
+// returns details worth reporting alongside the result.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (map[string]any, error)
+}
+
+// pinger is the part of models.SchemaModel the database check needs.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// schemaVersioner is the part of models.SchemaModel the migration check
+// needs.
+type schemaVersioner interface {
+	Version(ctx context.Context) (int64, bool, error)
+}
+
+// databaseCheck pings the database.
+func databaseCheck(db pinger) readinessCheck {
+	return readinessCheck{
+		name: "database",
+		check: func(ctx context.Context) (map[string]any, error) {
+			return nil, db.Ping(ctx)
+		},
+	}
+}
+
+// migrationCheck checks that the schema has the migrations the code expects
+// and that the last migration didn't fail part way through. A newer schema
+// passes, as the server accepts one on startup so a deploy can be rolled back.
+func migrationCheck(schema schemaVersioner, expected int64) readinessCheck {
+	return readinessCheck{
+		name: "migrations",
+		check: func(ctx context.Context) (map[string]any, error) {
+			details := map[string]any{"expected_version": expected}
+
+			version, dirty, err := schema.Version(ctx)
+			if err != nil {
+				if errors.Is(err, models.ErrRecordNotFound) {
+					return details, errors.New("no migrations have been applied")
+				}
+				return details, err
+			}
+
+			details["version"] = version
+			details["dirty"] = dirty
+
+			switch {
+			case dirty:
+				return details, fmt.Errorf("migration %d failed part way through", version)
+			case version < expected:
+				return details, fmt.Errorf("schema is at version %d, expected %d", version, expected)
+			}
+
+			return details, nil
+		},
+	}
+}
+
+// systemInfo describes the running build.
+func (app *application) systemInfo() map[string]string {
+	return map[string]string{
+		"environment": app.config.env,
+		"version":     version,
+		"build_time":  buildTime,
+	}
+}
+
+// healthzHandler reports that the process is up and serving. It checks no
+// dependencies, so an orchestrator doesn't restart the API for a database
+// outage a restart can't fix.
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	env := map[string]any{
+		"status":      "available",
+		"system_info": app.systemInfo(),
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readyzHandler runs every readiness check at once, each with
+// config.readiness.timeout to finish, and responds 503 if any fails so load
+// balancers stop sending traffic until it recovers.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	results := make(map[string]any, len(app.readiness))
+	ready := true
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, rc := range app.readiness {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), app.config.readiness.timeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := rc.check(ctx)
+			latency := time.Since(start)
+
+			result := map[string]any{
+				"status":     "up",
+				"latency_ms": float64(latency.Microseconds()) / 1000,
+			}
+			for key, value := range details {
+				result[key] = value
+			}
+
+			if err != nil {
+				if errors.Is(err, context.DeadlineExceeded) {
+					err = fmt.Errorf("no response within %s", app.config.readiness.timeout)
+				}
+
+				app.requestLogger(r).Error(err, jsonlog.String("check", rc.name))
+
+				result["status"] = "down"
+				result["error"] = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			results[rc.name] = result
+			if err != nil {
+				ready = false
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	env := map[string]any{
+		"status":      status,
+		"checks":      results,
+		"system_info": app.systemInfo(),
+	}
+
+	err := app.writeJSON(w, r, code, env, http.Header{"Cache-Control": []string{"no-store"}})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+type fakePinger func(ctx context.Context) error
+
+func (f fakePinger) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+type fakeSchema struct {
+	version int64
+	dirty   bool
+	err     error
+}
+
+func (f fakeSchema) Version(ctx context.Context) (int64, bool, error) {
+	return f.version, f.dirty, f.err
+}
+
+// TestHealthzHandler tests that liveness reports the build being run
+func TestHealthzHandler(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+	rr := httptest.NewRecorder()
+	app.healthzHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/healthz", nil))
+
+	var response struct {
+		Status     string            `json:"status"`
+		SystemInfo map[string]string `json:"system_info"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK || response.Status != "available" {
+		t.Errorf("got status %d %q, want 200 available", rr.Code, response.Status)
+	}
+	if response.SystemInfo["version"] != version {
+		t.Errorf("got version %q, want %q", response.SystemInfo["version"], version)
+	}
+	if _, ok := response.SystemInfo["build_time"]; !ok {
+		t.Error("system_info missing build_time")
+	}
+}
+
+// TestReadyzHandler tests that readiness fails when the database is down,
+// slow or at the wrong schema version
+func TestReadyzHandler(t *testing.T) {
+	up := fakePinger(func(ctx context.Context) error { return nil })
+	hanging := fakePinger(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	current := fakeSchema{version: 17}
+
+	tests := []struct {
+		name       string
+		db         pinger
+		schema     fakeSchema
+		wantStatus int
+		wantDown   []string
+	}{
+		{name: "Ready", db: up, schema: current, wantStatus: http.StatusOK},
+		{name: "Database down", db: fakePinger(func(ctx context.Context) error { return errors.New("connection refused") }), schema: current, wantStatus: http.StatusServiceUnavailable, wantDown: []string{"database"}},
+		{name: "Database slow", db: hanging, schema: current, wantStatus: http.StatusServiceUnavailable, wantDown: []string{"database"}},
+		{name: "Newer schema", db: up, schema: fakeSchema{version: 18}, wantStatus: http.StatusOK},
+		{name: "Outdated schema", db: up, schema: fakeSchema{version: 16}, wantStatus: http.StatusServiceUnavailable, wantDown: []string{"migrations"}},
+		{name: "Dirty schema", db: up, schema: fakeSchema{version: 17, dirty: true}, wantStatus: http.StatusServiceUnavailable, wantDown: []string{"migrations"}},
+		{name: "No migrations", db: up, schema: fakeSchema{err: models.ErrRecordNotFound}, wantStatus: http.StatusServiceUnavailable, wantDown: []string{"migrations"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger:    jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+				readiness: []readinessCheck{databaseCheck(tt.db), migrationCheck(tt.schema, 17)},
+			}
+			app.config.readiness.timeout = 10 * time.Millisecond
+
+			rr := httptest.NewRecorder()
+			app.readyzHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/readyz", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatus)
+			}
+
+			var response struct {
+				Checks map[string]struct {
+					Status  string   `json:"status"`
+					Latency *float64 `json:"latency_ms"`
+					Error   string   `json:"error"`
+				} `json:"checks"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			down := map[string]bool{}
+			for _, name := range tt.wantDown {
+				down[name] = true
+			}
+
+			for _, name := range []string{"database", "migrations"} {
+				check, ok := response.Checks[name]
+				if !ok {
+					t.Fatalf("checks missing %s", name)
+				}
+
+				want := "up"
+				if down[name] {
+					want = "down"
+				}
+				if check.Status != want {
+					t.Errorf("%s is %q, want %q", name, check.Status, want)
+				}
+				if down[name] && check.Error == "" {
+					t.Errorf("%s is down with no error", name)
+				}
+				if check.Latency == nil {
+					t.Errorf("%s missing latency", name)
+				}
+			}
+		})
+	}
+}
@@ -5,49 +5,89 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"filmapi.zeyadtarek.net/internals/codec"
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
 	"filmapi.zeyadtarek.net/internals/validator"
 )
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
-	js, err := json.Marshal(data)
+// encoders are the response formats clients can choose with Accept. JSON
+// comes first, so it's used when a client doesn't ask for anything else.
+var encoders = codec.NewRegistry(codec.JSON, codec.MessagePack, codec.CBOR)
+
+// writeJSON writes data as the response body, encoded as JSON or, if the
+// request's Accept header prefers it, MessagePack or CBOR. An ETag in headers
+// is extended to the representation sent.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header) error {
+	contentType, body, err := encodeResponse(r, data)
 	if err != nil {
 		return err
 	}
 
-	js = append(js, '\n')
-
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if etag := headers.Get("ETag"); etag != "" {
+		w.Header().Set("ETag", app.representationETag(r, etag, contentType, len(body)))
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept")
 	w.WriteHeader(status)
-	w.Write(js)
+	w.Write(body)
 
 	return nil
 }
 
+// encodeResponse encodes data in the format negotiated for r.
+func encodeResponse(r *http.Request, data any) (string, []byte, error) {
+	encoder := encoders.Negotiate(r.Header.Get("Accept"))
+
+	body, err := encoder.Encode(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return encoder.ContentType(), body, nil
+}
+
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := map[string]any{
 		"error": message,
 	}
-	err := app.writeJSON(w, status, env, nil)
+
+	// The request ID lets a client quote a failure back to us.
+	if id := app.contextGetRequestID(r); id != "" {
+		env["request_id"] = id
+	}
+
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
-		app.logger.PrintError(err, nil)
+		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// serverErrorMessage is all a client is told about an unexpected failure;
+// the details go to the log.
+const serverErrorMessage = "The server encountred a problem and could not process your request"
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.PrintError(err, nil)
-	message := "The server encountred a problem and could not process your request"
+	if errors.Is(err, models.ErrCanceled) {
+		app.queryCanceledResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
 
 }
 
@@ -151,9 +191,74 @@ func (app *application) readInt(queryString url.Values, key string, defaultValue
 	return integer
 }
 
+// readTime parses an optional RFC 3339 timestamp, returning nil when the
+// parameter is absent.
+func (app *application) readTime(queryString url.Values, key string, v *validator.Validator) *time.Time {
+	str := queryString.Get(key)
+	if str == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+
+	return &t
+}
+
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
-		"request_method": r.Method,
-		"request_url":    r.URL.String(),
-	})
+	app.requestLogger(r).Error(err,
+		jsonlog.String("request_method", r.Method),
+		jsonlog.String("request_url", r.URL.String()),
+	)
+}
+
+// requestLogger returns a logger that adds the request's ID, and its trace
+// ID if it's traced, to every line, so everything written while serving a
+// request can be tied back to it.
+func (app *application) requestLogger(r *http.Request) *jsonlog.Logger {
+	var fields []jsonlog.Field
+	if id := app.contextGetRequestID(r); id != "" {
+		fields = append(fields, jsonlog.String("request_id", id))
+	}
+	if id := traceID(r); id != "" {
+		fields = append(fields, jsonlog.String("trace_id", id))
+	}
+
+	if len(fields) == 0 {
+		return app.logger
+	}
+
+	return app.logger.With(fields...)
+}
+
+// clientIP returns the address of the client that sent the request, without
+// the port.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// background runs fn in a goroutine that the server waits for when shutting
+// down. A panic in fn is logged rather than crashing the process.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Errorf("%s", err), jsonlog.Stack())
+			}
+		}()
+
+		fn()
+	}()
 }
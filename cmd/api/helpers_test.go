@@ -2,14 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 
+	"filmapi.zeyadtarek.net/internals/codec"
 	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
 )
 
 // TestWriteJSON tests the writeJSON function
@@ -31,7 +35,8 @@ func TestWriteJSON(t *testing.T) {
 	}
 
 	// Call the writeJSON function
-	err := app.writeJSON(rr, http.StatusOK, data, headers)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	err := app.writeJSON(rr, req, http.StatusOK, data, headers)
 	if err != nil {
 		t.Fatalf("writeJSON() returned error: %v", err)
 	}
@@ -289,3 +294,79 @@ func TestServerErrorResponse(t *testing.T) {
 		t.Errorf("serverErrorResponse() did not log the error")
 	}
 }
+
+// TestServerErrorResponseCanceled tests that canceled queries get 503 if
+// they timed out and 499 if the client went away, rather than 500
+func TestServerErrorResponseCanceled(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+	t.Run("timed out", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v1/films", nil)
+
+		app.serverErrorResponse(rr, req, fmt.Errorf("%w: %w", models.ErrCanceled, context.DeadlineExceeded))
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("got status %d, want %d", rr.Code, http.StatusServiceUnavailable)
+		}
+		if rr.Header().Get("Retry-After") == "" {
+			t.Error("got no Retry-After header")
+		}
+	})
+
+	t.Run("client canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/v1/films", nil).WithContext(ctx)
+
+		app.serverErrorResponse(rr, req, fmt.Errorf("%w: %w", models.ErrCanceled, context.Canceled))
+
+		if rr.Code != statusClientClosedRequest {
+			t.Errorf("got status %d, want %d", rr.Code, statusClientClosedRequest)
+		}
+	})
+}
+
+// TestWriteJSONNegotiation tests that the Accept header picks the response
+// format
+func TestWriteJSONNegotiation(t *testing.T) {
+	app := &application{}
+	data := map[string]any{"film": map[string]any{"id": 1, "title": "Heat"}}
+
+	tests := []struct {
+		accept  string
+		encoder codec.Encoder
+	}{
+		{accept: "", encoder: codec.JSON},
+		{accept: "application/msgpack", encoder: codec.MessagePack},
+		{accept: "application/cbor, application/json;q=0.5", encoder: codec.CBOR},
+		{accept: "text/csv", encoder: codec.JSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/v1/films/1", nil)
+			req.Header.Set("Accept", tt.accept)
+
+			err := app.writeJSON(rr, req, http.StatusOK, data, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := rr.Header().Get("Content-Type"); got != tt.encoder.ContentType() {
+				t.Errorf("got Content-Type %q, want %q", got, tt.encoder.ContentType())
+			}
+			if got := rr.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("got Vary %q, want Accept", got)
+			}
+
+			want, _ := tt.encoder.Encode(data)
+			if !bytes.Equal(rr.Body.Bytes(), want) {
+				t.Errorf("got body %x, want %x", rr.Body.Bytes(), want)
+			}
+		})
+	}
+}
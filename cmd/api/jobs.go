@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/scheduler"
+)
+
+// registerJobs adds the API's recurring jobs to the scheduler.
+func (app *application) registerJobs() error {
+	if app.config.sweeper.interval > 0 {
+		err := app.scheduler.Add(scheduler.Job{
+			Name:     "sweep-stale-accounts",
+			Schedule: scheduler.Every(app.config.sweeper.interval),
+			Run:      app.sweepJob,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.config.webhooks.relayInterval > 0 {
+		err := app.scheduler.Add(scheduler.Job{
+			Name:     "relay-webhook-events",
+			Schedule: scheduler.Every(app.config.webhooks.relayInterval),
+			Run:      app.relayWebhookEventsJob,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.events != nil && app.config.events.retention > 0 {
+		err := app.scheduler.Add(scheduler.Job{
+			Name:     "prune-change-events",
+			Schedule: scheduler.Every(time.Hour),
+			Run:      app.pruneChangeEventsJob,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.config.jobs.loginAttemptsCron != "" {
+		schedule, err := scheduler.ParseCron(app.config.jobs.loginAttemptsCron)
+		if err != nil {
+			return err
+		}
+
+		err = app.scheduler.Add(scheduler.Job{
+			Name:     "purge-login-attempts",
+			Schedule: schedule,
+			Run:      app.purgeLoginAttemptsJob,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sweepJob deletes expired tokens and, if a grace period is configured,
+// accounts that were never activated within it.
+func (app *application) sweepJob(ctx context.Context) error {
+	tokens, err := app.models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return err
+	}
+
+	var users int64
+	if app.config.sweeper.unactivatedGrace > 0 {
+		users, err = app.models.Users.DeleteUnactivated(ctx, time.Now().Add(-app.config.sweeper.unactivatedGrace))
+		if err != nil {
+			return err
+		}
+	}
+
+	if tokens > 0 || users > 0 {
+		app.logger.PrintInfo("sweeper removed stale records", map[string]string{
+			"expired_tokens":       strconv.FormatInt(tokens, 10),
+			"unactivated_accounts": strconv.FormatInt(users, 10),
+		})
+	}
+
+	return nil
+}
+
+// purgeLoginAttemptsJob forgets failed logins that have aged out of the
+// failure window.
+func (app *application) purgeLoginAttemptsJob(ctx context.Context) error {
+	purged, err := app.models.Logins.DeleteStale(ctx, time.Now().Add(-app.config.login.failureWindow))
+	if err != nil {
+		return err
+	}
+
+	if purged > 0 {
+		app.logger.PrintInfo("purged stale login attempts", map[string]string{
+			"login_attempts": strconv.FormatInt(purged, 10),
+		})
+	}
+
+	return nil
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs := []scheduler.Status{}
+	if app.scheduler != nil {
+		jobs = app.scheduler.Statuses()
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"jobs": jobs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/events"
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/scheduler"
+)
+
+// TestBackground tests that panics in background work are logged and that
+// the wait group tracks the goroutine
+func TestBackground(t *testing.T) {
+	var logBuffer bytes.Buffer
+	app := &application{
+		logger: jsonlog.New(&logBuffer, jsonlog.LevelInfo),
+	}
+
+	app.background(func() {
+		panic("sweeper exploded")
+	})
+	app.wg.Wait()
+
+	if !strings.Contains(logBuffer.String(), "sweeper exploded") {
+		t.Errorf("panic was not logged, got %q", logBuffer.String())
+	}
+}
+
+// TestCreateActivationTokenHandlerValidation tests requests rejected before
+// the account is looked up
+func TestCreateActivationTokenHandlerValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "Missing email", body: `{"password": "pa55word1234"}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "Malformed email", body: `{"email": "not-an-email", "password": "pa55word1234"}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "Missing password", body: `{"email": "a@b.com"}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "Unknown field", body: `{"email": "a@b.com", "name": "x"}`, wantStatusCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/tokens/activation", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			app.createActivationTokenHandler(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+// TestRegisterJobs tests that the configured jobs are added and that an
+// invalid cron schedule is rejected
+func TestRegisterJobs(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		cron     string
+		relay    time.Duration
+		prune    time.Duration
+		wantJobs []string
+		wantErr  bool
+	}{
+		{name: "All jobs", interval: time.Hour, cron: "*/15 * * * *", relay: time.Second, prune: time.Hour, wantJobs: []string{"prune-change-events", "purge-login-attempts", "relay-webhook-events", "sweep-stale-accounts"}},
+		{name: "Sweeper disabled", cron: "@daily", wantJobs: []string{"purge-login-attempts"}},
+		{name: "Purge disabled", interval: time.Minute, wantJobs: []string{"sweep-stale-accounts"}},
+		{name: "Invalid cron", interval: time.Hour, cron: "61 * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)
+			app := &application{
+				logger:    logger,
+				scheduler: scheduler.New(nil, logger),
+			}
+			app.config.sweeper.interval = tt.interval
+			app.config.jobs.loginAttemptsCron = tt.cron
+			app.config.webhooks.relayInterval = tt.relay
+			app.config.events.retention = tt.prune
+			app.events = events.NewBroker(nil, logger, events.Config{})
+
+			err := app.registerJobs()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("registerJobs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			var names []string
+			for _, status := range app.scheduler.Statuses() {
+				names = append(names, status.Name)
+			}
+
+			if strings.Join(names, ",") != strings.Join(tt.wantJobs, ",") {
+				t.Errorf("got jobs %v, want %v", names, tt.wantJobs)
+			}
+		})
+	}
+}
+
+// TestListJobsHandler tests that job statuses are listed and that an empty
+// list is returned when no scheduler is running
+func TestListJobsHandler(t *testing.T) {
+	logger := jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)
+
+	withJobs := scheduler.New(nil, logger)
+	err := withJobs.Add(scheduler.Job{
+		Name:     "noop",
+		Schedule: scheduler.Every(time.Hour),
+		Run:      func(ctx context.Context) error { return nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		scheduler *scheduler.Scheduler
+		wantBody  string
+	}{
+		{name: "No scheduler", wantBody: `"jobs":[]`},
+		{name: "With jobs", scheduler: withJobs, wantBody: `"name":"noop"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: logger, scheduler: tt.scheduler}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/jobs", nil)
+			rr := httptest.NewRecorder()
+			app.listJobsHandler(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
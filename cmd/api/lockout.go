@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+func (app *application) lockoutPolicy() models.LockoutPolicy {
+	return models.LockoutPolicy{
+		Threshold: app.config.login.lockoutThreshold,
+		BaseDelay: app.config.login.backoffBase,
+		Lockout:   app.config.login.lockoutDuration,
+		Window:    app.config.login.failureWindow,
+	}
+}
+
+// loginKeys returns the keys failed logins are tracked under: the account
+// (by email, whether or not it exists, so lockouts don't reveal which
+// addresses are registered) and the client's IP address.
+func loginKeys(email, ip string) [][2]string {
+	return [][2]string{
+		{models.LoginScopeAccount, strings.ToLower(email)},
+		{models.LoginScopeIP, ip},
+	}
+}
+
+// loginRetryAfter returns how long the email or IP address has to wait before
+// it may try to log in again.
+func (app *application) loginRetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	now := time.Now()
+
+	for _, key := range loginKeys(email, ip) {
+		attempt, err := app.models.Logins.Get(ctx, key[0], key[1])
+		if err != nil {
+			if errors.Is(err, models.ErrRecordNotFound) {
+				continue
+			}
+			return 0, err
+		}
+
+		retryAfter = max(retryAfter, attempt.RetryAfter(now))
+	}
+
+	return retryAfter, nil
+}
+
+// recordLoginFailure counts a failed login against the email and the
+// request's IP address and logs a security event whenever one of them gets
+// locked out.
+func (app *application) recordLoginFailure(r *http.Request, email string) error {
+	policy := app.lockoutPolicy()
+
+	for _, key := range loginKeys(email, app.clientIP(r)) {
+		attempt, err := app.models.Logins.RecordFailure(r.Context(), key[0], key[1], policy)
+		if err != nil {
+			return err
+		}
+
+		if policy.LockedOut(attempt.Failures) {
+			app.requestLogger(r).Warn("login locked out after repeated failures",
+				jsonlog.String("event", "security.login_lockout"),
+				jsonlog.String("scope", attempt.Scope),
+				jsonlog.String("key", attempt.Key),
+				jsonlog.Int("failures", attempt.Failures),
+				jsonlog.Time("locked_until", *attempt.LockedUntil),
+			)
+		}
+	}
+
+	return nil
+}
+
+// failedLoginResponse records a failed login and rejects the credentials.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email string) {
+	err := app.recordLoginFailure(r, email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+func (app *application) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Logins.Reset(r.Context(), models.LoginScopeAccount, strings.ToLower(user.Email))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "user.unlock", "user", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Audit.Insert(r.Context(), event, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "account unlocked successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
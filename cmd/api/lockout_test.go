@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestLoginKeys tests that failures are tracked per account and per IP
+func TestLoginKeys(t *testing.T) {
+	keys := loginKeys("Alice@Example.com", "203.0.113.7")
+
+	if len(keys) != 2 {
+		t.Fatalf("loginKeys() returned %d keys, want 2", len(keys))
+	}
+
+	// Emails are case-insensitive in the users table, so the key must be too.
+	if keys[0] != [2]string{models.LoginScopeAccount, "alice@example.com"} {
+		t.Errorf("account key = %v", keys[0])
+	}
+	if keys[1] != [2]string{models.LoginScopeIP, "203.0.113.7"} {
+		t.Errorf("ip key = %v", keys[1])
+	}
+}
+
+// TestClientIP tests that the port is stripped from the remote address
+func TestClientIP(t *testing.T) {
+	app := &application{}
+
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{remoteAddr: "203.0.113.7:51234", want: "203.0.113.7"},
+		{remoteAddr: "[2001:db8::1]:443", want: "2001:db8::1"},
+		{remoteAddr: "203.0.113.7", want: "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+
+		if got := app.clientIP(req); got != tt.want {
+			t.Errorf("clientIP(%q) = %q, want %q", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+// TestRecordLoginFailureLogsLockout tests that a lockout is logged as a
+// warning with typed properties
+func TestRecordLoginFailureLogsLockout(t *testing.T) {
+	app, _, _ := newMemoryApp(t)
+	app.config.login.lockoutThreshold = 1
+	app.config.login.lockoutDuration = 15 * time.Minute
+	app.config.login.failureWindow = time.Hour
+
+	var logs bytes.Buffer
+	app.logger = jsonlog.New(&logs, jsonlog.LevelInfo)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/tokens/authentication", nil)
+	if err := app.recordLoginFailure(req, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var entry struct {
+		Level      string         `json:"level"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.NewDecoder(&logs).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Level != "WARN" || entry.Properties["event"] != "security.login_lockout" || entry.Properties["failures"] != float64(1) {
+		t.Errorf("got log entry %+v, want a lockout warning with numeric failures", entry)
+	}
+}
@@ -5,14 +5,23 @@ import (
 	"database/sql"
 	"flag"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"encoding/json"
-
+	"filmapi.zeyadtarek.net/internals/cache"
+	"filmapi.zeyadtarek.net/internals/events"
 	"filmapi.zeyadtarek.net/internals/jsonlog"
 	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/oidc"
+	"filmapi.zeyadtarek.net/internals/queue"
+	"filmapi.zeyadtarek.net/internals/scheduler"
+	"filmapi.zeyadtarek.net/internals/validator"
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
 )
 
@@ -24,6 +33,9 @@ type config struct {
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		// migrateOnStart applies pending migrations before serving.
+		migrateOnStart bool
 	}
 
 	limiter struct {
@@ -36,12 +48,114 @@ type config struct {
 		trustedOrigins []string
 		enabled        bool
 	}
+
+	login struct {
+		lockoutThreshold int
+		backoffBase      time.Duration
+		lockoutDuration  time.Duration
+		failureWindow    time.Duration
+	}
+
+	totp struct {
+		issuer string
+	}
+
+	password struct {
+		minLength    int
+		minEntropy   float64
+		breachedList string
+	}
+
+	sweeper struct {
+		interval         time.Duration
+		unactivatedGrace time.Duration
+	}
+
+	jobs struct {
+		loginAttemptsCron string
+	}
+
+	webhooks struct {
+		timeout       time.Duration
+		relayInterval time.Duration
+	}
+
+	compression struct {
+		enabled bool
+		minSize int
+	}
+
+	cache struct {
+		enabled bool
+		size    int
+		ttl     time.Duration
+	}
+
+	events struct {
+		retention    time.Duration
+		pollInterval time.Duration
+	}
+
+	metrics struct {
+		enabled bool
+		addr    string
+	}
+
+	readiness struct {
+		timeout time.Duration
+	}
+
+	accessLog struct {
+		enabled          bool
+		sampleFirst      int
+		sampleThereafter int
+	}
+
+	log struct {
+		level string
+	}
+
+	tracing struct {
+		exporter    string
+		file        string
+		sampleRatio float64
+	}
+
+	tasks struct {
+		workers           int
+		pollInterval      time.Duration
+		visibilityTimeout time.Duration
+		maxAttempts       int
+		backoffBase       time.Duration
+		backoffMax        time.Duration
+	}
+
+	oidc struct {
+		issuer        string
+		clientID      string
+		clientSecret  string
+		redirectURL   string
+		autoProvision bool
+	}
 }
 
 type application struct {
 	logger *jsonlog.Logger
 	config config
 	models models.Models
+	// filmCache is the cache the film model reads through, or nil if
+	// caching is disabled.
+	filmCache      cache.Cache
+	oidc           *oidc.Provider
+	oidcStates     *oidc.StateStore
+	passwordPolicy validator.PasswordPolicy
+	scheduler      *scheduler.Scheduler
+	tasks          *queue.Queue
+	events         *events.Broker
+	metrics        *appMetrics
+	readiness      []readinessCheck
+	webhookClient  *http.Client
+	wg             sync.WaitGroup
 }
 
 const version = "1.0.0"
@@ -56,6 +170,8 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", models.DefaultTimeout, "How long a single database call may take before it's canceled")
+	flag.BoolVar(&cfg.db.migrateOnStart, "migrate-on-start", false, "Apply pending migrations before serving; replicas starting together take turns")
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum requests per second")
 	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enabled rate limiter")
@@ -66,8 +182,70 @@ func main() {
 		return nil
 	})
 
+	flag.IntVar(&cfg.login.lockoutThreshold, "login-lockout-threshold", 10, "Failed logins before an account or IP is locked out")
+	flag.DurationVar(&cfg.login.backoffBase, "login-backoff-base", time.Second, "Delay after the first failed login, doubled for every further failure")
+	flag.DurationVar(&cfg.login.lockoutDuration, "login-lockout-duration", 15*time.Minute, "How long a lockout lasts")
+	flag.DurationVar(&cfg.login.failureWindow, "login-failure-window", 15*time.Minute, "How long failed logins are remembered")
+
+	flag.StringVar(&cfg.totp.issuer, "totp-issuer", "Film API", "Issuer name shown in authenticator apps")
+
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum length of new passwords in bytes")
+	flag.Float64Var(&cfg.password.minEntropy, "password-min-entropy", 40, "Minimum estimated strength of new passwords in bits")
+	flag.StringVar(&cfg.password.breachedList, "password-breached-list", "", "File of SHA-1 hashes of breached passwords to reject (empty disables the check)")
+
+	flag.DurationVar(&cfg.sweeper.interval, "sweeper-interval", time.Hour, "How often expired tokens and stale accounts are cleaned up (0 disables)")
+	flag.DurationVar(&cfg.sweeper.unactivatedGrace, "unactivated-account-grace", 7*24*time.Hour, "How long a never-activated account is kept (0 keeps them forever)")
+
+	flag.StringVar(&cfg.jobs.loginAttemptsCron, "jobs-login-attempts-cron", "*/15 * * * *", "Cron schedule for purging old failed logins (empty disables)")
+
+	flag.IntVar(&cfg.tasks.workers, "tasks-workers", 4, "Number of tasks processed concurrently")
+	flag.DurationVar(&cfg.tasks.pollInterval, "tasks-poll-interval", time.Second, "How often idle task workers check for new tasks")
+	flag.DurationVar(&cfg.tasks.visibilityTimeout, "tasks-visibility-timeout", 5*time.Minute, "How long a task may run before it's handed to another worker")
+	flag.IntVar(&cfg.tasks.maxAttempts, "tasks-max-attempts", 5, "How many times a task is tried before it's dead-lettered")
+	flag.DurationVar(&cfg.tasks.backoffBase, "tasks-backoff", 10*time.Second, "Delay before a failed task's first retry, doubled for each later one")
+	flag.DurationVar(&cfg.tasks.backoffMax, "tasks-backoff-max", time.Hour, "Longest delay between retries of a failed task")
+
+	flag.DurationVar(&cfg.webhooks.timeout, "webhooks-timeout", 10*time.Second, "How long to wait for a webhook receiver to respond")
+	flag.DurationVar(&cfg.webhooks.relayInterval, "webhooks-relay-interval", time.Second, "How often new events are turned into webhook deliveries (0 disables)")
+
+	flag.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses with gzip or Brotli when the client accepts it")
+	flag.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response in bytes that is compressed")
+
+	flag.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache film reads in memory")
+	flag.IntVar(&cfg.cache.size, "cache-size", 1000, "Most films and film listings kept in the cache")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", time.Minute, "How long a film or listing is cached, which bounds how stale it can get")
+
+	flag.DurationVar(&cfg.events.retention, "events-retention", 24*time.Hour, "How long change events are kept for clients resuming a stream (0 keeps them forever)")
+	flag.DurationVar(&cfg.events.pollInterval, "events-poll-interval", 5*time.Second, "How often the change events table is checked if no notification arrives")
+
+	flag.BoolVar(&cfg.metrics.enabled, "metrics-enabled", true, "Expose Prometheus metrics on /metrics")
+	flag.StringVar(&cfg.metrics.addr, "metrics-addr", "", "Separate address to serve /metrics on without authentication, such as :9090 (empty serves it on the API port to users:admin)")
+
+	flag.BoolVar(&cfg.accessLog.enabled, "access-log", true, "Log every request with its status, size, duration, user and client IP")
+	flag.IntVar(&cfg.accessLog.sampleFirst, "access-log-sample-first", 0, "Access log lines written each second before sampling starts (0 disables sampling)")
+	flag.IntVar(&cfg.accessLog.sampleThereafter, "access-log-sample-thereafter", 100, "Once sampling, write only every this many access log lines each second")
+
+	flag.StringVar(&cfg.log.level, "log-level", "info", "Lowest level logged (debug|info|warn|error), which admins can change while running")
+
+	flag.StringVar(&cfg.tracing.exporter, "tracing-exporter", "none", "Where to send OpenTelemetry traces (none|otlp|stdout); otlp is configured with the standard OTEL_EXPORTER_OTLP_* variables")
+	flag.StringVar(&cfg.tracing.file, "tracing-file", "", "File the stdout exporter appends traces to instead of standard output")
+	flag.Float64Var(&cfg.tracing.sampleRatio, "tracing-sample-ratio", 1, "Fraction of requests traced when the caller hasn't already decided")
+
+	flag.DurationVar(&cfg.readiness.timeout, "readiness-timeout", 2*time.Second, "How long each readiness check may take before it counts as failed")
+
+	flag.StringVar(&cfg.oidc.issuer, "oidc-issuer", "", "OpenID Connect issuer URL (empty disables single sign-on)")
+	flag.StringVar(&cfg.oidc.clientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&cfg.oidc.clientSecret, "oidc-client-secret", os.Getenv("FILMAPI_OIDC_CLIENT_SECRET"), "OpenID Connect client secret")
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/auth/oidc/callback", "OpenID Connect redirect URL")
+	flag.BoolVar(&cfg.oidc.autoProvision, "oidc-auto-provision", false, "Create accounts for unknown users who sign in through OpenID Connect")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n\nflags:\n", commandUsage)
+		flag.PrintDefaults()
+	}
+
 	flag.Parse()
 
 	if *displayVersion {
@@ -76,13 +254,60 @@ func main() {
 		os.Exit(0)
 	}
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	logLevel, err := jsonlog.ParseLevel(cfg.log.level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	serve := len(args) == 0 || args[0] == "serve"
+
+	// Other commands keep standard output for their JSON result.
+	logOutput := os.Stdout
+	if !serve {
+		logOutput = os.Stderr
+	}
+
+	logger := jsonlog.New(logOutput, logLevel)
+
+	// Libraries that log with log/slog, or the standard log package, go
+	// through the same logger.
+	slog.SetDefault(slog.New(logger.Handler()))
+
+	if !serve {
+		os.Exit(runCommand(cfg, logger, args))
+	}
+
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, commandUsage)
+		os.Exit(2)
+	}
 
 	app := &application{
 		config: cfg,
 		logger: logger,
 	}
 
+	err = app.loadPasswordPolicy()
+	if err != nil {
+		app.logger.PrintFatal(err, nil)
+	}
+
+	shutdownTracing, err := setupTracing(cfg)
+	if err != nil {
+		app.logger.PrintFatal(err, nil)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		err := shutdownTracing(ctx)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}()
+
 	db, err := openDB(cfg)
 	if err != nil {
 		app.logger.PrintFatal(err, nil)
@@ -90,13 +315,62 @@ func main() {
 	app.logger.PrintInfo("database connection established", nil)
 	defer db.Close()
 
-	app.models = models.New(db)
+	err = app.prepareSchema(db)
+	if err != nil {
+		app.logger.PrintFatal(err, nil)
+	}
+
+	app.models = models.New(db, cfg.db.queryTimeout)
+
+	app.readiness = []readinessCheck{
+		databaseCheck(app.models.Schema),
+		migrationCheck(app.models.Schema, models.SchemaVersion),
+	}
 
-	// Check if the database has less than 9999 films
-	if err := populateFilmsIfNeeded(app); err != nil {
+	if cfg.cache.enabled {
+		app.filmCache = cache.NewLRU(cfg.cache.size, cfg.cache.ttl)
+		app.models.Films = models.FilmModel{DB: db, Timeout: cfg.db.queryTimeout, Cache: app.filmCache}
+	}
+
+	if cfg.metrics.enabled {
+		app.metrics = newAppMetrics(db, app.filmCache)
+	}
+
+	app.webhookClient = newWebhookClient(cfg.webhooks.timeout)
+
+	app.tasks = queue.New(queue.NewPostgresStore(db), logger, queue.Config{
+		Workers:           cfg.tasks.workers,
+		PollInterval:      cfg.tasks.pollInterval,
+		VisibilityTimeout: cfg.tasks.visibilityTimeout,
+		MaxAttempts:       cfg.tasks.maxAttempts,
+		BackoffBase:       cfg.tasks.backoffBase,
+		BackoffMax:        cfg.tasks.backoffMax,
+	})
+	err = app.registerTaskHandlers()
+	if err != nil {
 		app.logger.PrintFatal(err, nil)
 	}
 
+	app.events = events.NewBroker(events.NewPostgresSource(db), logger, events.Config{
+		PollInterval: cfg.events.pollInterval,
+	})
+
+	app.scheduler = scheduler.New(scheduler.NewPostgresLocker(db), logger)
+	err = app.registerJobs()
+	if err != nil {
+		app.logger.PrintFatal(err, nil)
+	}
+
+	if cfg.oidc.issuer != "" {
+		err = app.setupOIDC()
+		if err != nil {
+			app.logger.PrintFatal(err, nil)
+		}
+		app.logger.PrintInfo("openid connect provider configured", map[string]string{
+			"issuer": app.oidc.Issuer(),
+		})
+	}
+
 	err = app.serve()
 	if err != nil {
 		app.logger.PrintFatal(err, nil)
@@ -104,7 +378,14 @@ func main() {
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	var db *sql.DB
+	var err error
+
+	if cfg.tracing.exporter != "none" {
+		db, err = otelsql.Open("postgres", cfg.db.dsn, sqlTraceOptions()...)
+	} else {
+		db, err = sql.Open("postgres", cfg.db.dsn)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -130,81 +411,23 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
-// populateFilmsIfNeeded checks the film count and populates the database if needed
-func populateFilmsIfNeeded(app *application) error {
-
-	count, err := app.models.Films.Count()
-	if err != nil {
-		return err
-	}
-
-	if count >= 9999 {
-		app.logger.PrintInfo("Database already populated with 9999 films. No insertion needed.", nil)
-		return nil
+// loadPasswordPolicy sets up the policy new passwords are checked against,
+// loading the breached password list if one is configured.
+func (app *application) loadPasswordPolicy() error {
+	app.passwordPolicy = validator.PasswordPolicy{
+		MinLength:  app.config.password.minLength,
+		MinEntropy: app.config.password.minEntropy,
 	}
 
-	type FilmInput struct {
-		ID          int64          `json:"id"`
-		Title       string         `json:"title"`
-		Year        int32          `json:"year"`
-		Runtime     models.Runtime `json:"runtime"` // Will automatically handle "142 mins" format
-		Rating      float32        `json:"rating"`
-		Description string         `json:"description"`
-		Img         string         `json:"image"`
-		Version     int32          `json:"version"`
-		Genres      []string       `json:"genres"`
-		Directors   []string       `json:"directors"`
-		Actors      []string       `json:"actors"`
-	}
-	// Read the JSON file
-	data, err := os.ReadFile("./static/json/films.json")
-	if err != nil {
-		return err
-	}
-
-	// Parse the JSON data into the input struct
-	var filmInputs []FilmInput
-	if err := json.Unmarshal(data, &filmInputs); err != nil {
-		return err
-	}
-
-	// Insert each film into the database
-	for _, input := range filmInputs {
-		film := &models.Film{
-			Title:       input.Title,
-			Year:        input.Year,
-			Runtime:     input.Runtime, // Runtime will already be in the correct format
-			Rating:      input.Rating,
-			Description: input.Description,
-			Img:         input.Img,
-			Version:     1,
-		}
-
-		// Convert genres to models.Genre
-		film.Genres = make([]models.Genre, len(input.Genres))
-		for i, genreName := range input.Genres {
-			film.Genres[i] = models.Genre{Name: genreName}
+	if app.config.password.breachedList != "" {
+		breached, err := validator.LoadBreachedList(app.config.password.breachedList)
+		if err != nil {
+			return err
 		}
-
-		// Convert directors to models.Director
-		film.Directors = make([]models.Director, len(input.Directors))
-		for i, directorName := range input.Directors {
-			film.Directors[i] = models.Director{Name: directorName}
-		}
-
-		// Convert actors to models.Actor
-		film.Actors = make([]models.Actor, len(input.Actors))
-		for i, actorName := range input.Actors {
-			film.Actors[i] = models.Actor{Name: actorName}
-		}
-
-		// Insert the film into the database
-		if err := app.models.Films.Insert(film); err != nil {
-			app.logger.PrintError(fmt.Errorf("error inserting film %s: %v", input.Title, err), nil)
-			continue
-		}
-
-		app.logger.PrintInfo(fmt.Sprintf("Inserted film: %s", film.Title), nil)
+		app.passwordPolicy.Breached = breached
+		app.logger.PrintInfo("breached password list loaded", map[string]string{
+			"hashes": strconv.Itoa(breached.Len()),
+		})
 	}
 
 	return nil
@@ -9,9 +9,3 @@ func TestOpenDB(t *testing.T) {
 	// Skip this test in normal test runs since it requires a real database
 	t.Skip("Skipping test that requires a real database")
 }
-
-// TestPopulateFilmsIfNeeded tests the populateFilmsIfNeeded function
-func TestPopulateFilmsIfNeeded(t *testing.T) {
-	// Skip this test in normal test runs since it's complex to mock
-	t.Skip("Skipping test that requires complex mocking")
-}
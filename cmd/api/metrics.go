@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/cache"
+	"filmapi.zeyadtarek.net/internals/metrics"
+)
+
+// appMetrics are the metrics the API exposes on /metrics.
+type appMetrics struct {
+	registry    *metrics.Registry
+	requests    *metrics.CounterVec
+	duration    *metrics.HistogramVec
+	inFlight    *metrics.GaugeVec
+	rateLimited *metrics.Counter
+}
+
+// newAppMetrics registers the HTTP, rate limiter, connection pool, cache,
+// runtime and build metrics. db and filmCache may be nil.
+func newAppMetrics(db *sql.DB, filmCache cache.Cache) *appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: registry,
+		requests: registry.Counter("filmapi_http_requests_total",
+			"HTTP requests served, by route and status code.", "method", "route", "code"),
+		duration: registry.Histogram("filmapi_http_request_duration_seconds",
+			"How long HTTP requests took to serve, by route.", metrics.DefaultBuckets, "method", "route"),
+		inFlight: registry.Gauge("filmapi_http_requests_in_flight",
+			"HTTP requests being served, by route.", "method", "route"),
+		rateLimited: registry.Counter("filmapi_rate_limit_rejections_total",
+			"Requests refused by the rate limiter.").With(),
+	}
+
+	if db != nil {
+		registerDBMetrics(registry, db)
+	}
+
+	if filmCache != nil {
+		registry.CounterFunc("filmapi_cache_hits_total", "Film cache lookups that found an entry.",
+			func() float64 { return float64(filmCache.Stats().Hits) })
+		registry.CounterFunc("filmapi_cache_misses_total", "Film cache lookups that found nothing.",
+			func() float64 { return float64(filmCache.Stats().Misses) })
+		registry.CounterFunc("filmapi_cache_evictions_total", "Film cache entries evicted to make room.",
+			func() float64 { return float64(filmCache.Stats().Evictions) })
+		registry.GaugeFunc("filmapi_cache_entries", "Entries in the film cache.",
+			func() float64 { return float64(filmCache.Stats().Entries) })
+	}
+
+	registerRuntimeMetrics(registry)
+
+	registry.Gauge("filmapi_build_info", "Always 1, labelled with the version being run.",
+		"version", "build_time", "go_version").With(version, buildTime, runtime.Version()).Set(1)
+
+	return m
+}
+
+// registerDBMetrics exposes the connection pool statistics from db.Stats().
+func registerDBMetrics(registry *metrics.Registry, db *sql.DB) {
+	gauge := func(name, help string, fn func(sql.DBStats) float64) {
+		registry.GaugeFunc(name, help, func() float64 { return fn(db.Stats()) })
+	}
+	counter := func(name, help string, fn func(sql.DBStats) float64) {
+		registry.CounterFunc(name, help, func() float64 { return fn(db.Stats()) })
+	}
+
+	gauge("filmapi_db_max_open_connections", "Most connections the pool will open.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("filmapi_db_open_connections", "Connections open, in use or idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("filmapi_db_in_use_connections", "Connections in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("filmapi_db_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("filmapi_db_wait_count_total", "Times a query waited for a free connection.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("filmapi_db_wait_duration_seconds_total", "Time spent waiting for a free connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("filmapi_db_max_idle_closed_total", "Connections closed because the idle pool was full.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("filmapi_db_max_idle_time_closed_total", "Connections closed for being idle too long.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("filmapi_db_max_lifetime_closed_total", "Connections closed for reaching their maximum lifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+// registerRuntimeMetrics exposes goroutine, memory and garbage collector
+// statistics. The memory statistics are read once per scrape, since reading
+// them briefly stops the world.
+func registerRuntimeMetrics(registry *metrics.Registry) {
+	var (
+		mu    sync.Mutex
+		stats runtime.MemStats
+	)
+
+	registry.OnCollect(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		runtime.ReadMemStats(&stats)
+	})
+
+	memStat := func(fn func(*runtime.MemStats) float64) func() float64 {
+		return func() float64 {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(&stats)
+		}
+	}
+
+	start := float64(time.Now().Unix())
+	registry.GaugeFunc("process_start_time_seconds", "When the process started, in seconds since the Unix epoch.",
+		func() float64 { return start })
+	registry.GaugeFunc("go_goroutines", "Goroutines that currently exist.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+	registry.GaugeFunc("go_memstats_alloc_bytes", "Bytes allocated to heap objects still in use.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.Alloc) }))
+	registry.CounterFunc("go_memstats_alloc_bytes_total", "Bytes allocated to heap objects, even if freed.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.TotalAlloc) }))
+	registry.GaugeFunc("go_memstats_heap_objects", "Heap objects allocated.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.HeapObjects) }))
+	registry.GaugeFunc("go_memstats_sys_bytes", "Bytes of memory obtained from the operating system.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.Sys) }))
+	registry.GaugeFunc("go_memstats_next_gc_bytes", "Heap size at which the next garbage collection starts.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.NextGC) }))
+	registry.CounterFunc("go_gc_cycles_total", "Completed garbage collection cycles.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.NumGC) }))
+	registry.CounterFunc("go_gc_pause_seconds_total", "Time the world was stopped for garbage collection.",
+		memStat(func(s *runtime.MemStats) float64 { return float64(s.PauseTotalNs) / 1e9 }))
+}
+
+// instrument returns middleware that records the count, latency and
+// concurrency of requests, labelled by the router pattern they match rather
+// than their path so IDs don't each get their own series.
+func (app *application) instrument(router *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, route := requestLabels(router, r)
+
+			inFlight := app.metrics.inFlight.With(method, route)
+			inFlight.Inc()
+			defer inFlight.Dec()
+
+			sw := &statusWriter{ResponseWriter: w}
+			start := time.Now()
+
+			defer func() {
+				if sw.status == 0 {
+					sw.status = http.StatusOK
+				}
+				app.metrics.duration.With(method, route).Observe(time.Since(start).Seconds())
+				app.metrics.requests.With(method, route, strconv.Itoa(sw.status)).Inc()
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// requestLabels returns the method and route labels for r. Methods the API
+// doesn't use and requests that match no route are grouped together.
+func requestLabels(router *http.ServeMux, r *http.Request) (string, string) {
+	method := r.Method
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+
+	_, pattern := router.Handler(r)
+	if pattern == "" {
+		return method, "unmatched"
+	}
+
+	// Patterns start with their method, which is already a label.
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = path
+	}
+
+	return method, pattern
+}
+
+// metricsServer returns the server for the separate metrics listener, which
+// serves nothing but /metrics and has no authentication, so it should only
+// be reachable from the monitoring network.
+func (app *application) metricsServer() *http.Server {
+	router := http.NewServeMux()
+	router.Handle("GET /metrics", app.metrics.registry.Handler())
+
+	return &http.Server{
+		Addr:         app.config.metrics.addr,
+		Handler:      router,
+		ErrorLog:     log.New(app.logger, "", 0),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  time.Minute,
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/cache"
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+// TestInstrument tests that requests are counted by route pattern and status
+// and that streamed responses can still be flushed
+func TestInstrument(t *testing.T) {
+	app := &application{
+		logger:  jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+		metrics: newAppMetrics(nil, cache.NewLRU(10, 0)),
+	}
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /v1/films/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	router.HandleFunc("GET /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: 1\n\n"))
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush() = %v", err)
+		}
+	})
+	handler := app.instrument(router)(router)
+
+	for _, target := range []string{"/v1/films/1", "/v1/films/2", "/v1/events", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/v1/films/1", nil))
+
+	rr := httptest.NewRecorder()
+	app.metrics.registry.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+
+	for _, want := range []string{
+		`filmapi_http_requests_total{method="GET",route="/v1/films/{id}",code="418"} 2`,
+		`filmapi_http_requests_total{method="GET",route="/v1/events",code="200"} 1`,
+		`filmapi_http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`filmapi_http_requests_total{method="OTHER",route="unmatched",code="405"} 1`,
+		`filmapi_http_request_duration_seconds_count{method="GET",route="/v1/films/{id}"} 2`,
+		`filmapi_http_requests_in_flight{method="GET",route="/v1/films/{id}"} 0`,
+		`filmapi_cache_entries 0`,
+		`filmapi_build_info{version="` + version + `"`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+
+	if got := rr.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("got Content-Type %q, want the text exposition format", got)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"net"
@@ -9,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"filmapi.zeyadtarek.net/internals/jsonlog"
 	"filmapi.zeyadtarek.net/internals/models"
 	"filmapi.zeyadtarek.net/internals/validator"
 	"golang.org/x/time/rate"
@@ -34,7 +36,15 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				w.Header().Set("Connection", "close")
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+
+				// A panic is the one failure whose stack trace is worth
+				// logging.
+				app.requestLogger(r).Error(fmt.Errorf("%s", err),
+					jsonlog.String("request_method", r.Method),
+					jsonlog.String("request_url", r.URL.String()),
+					jsonlog.Stack(),
+				)
+				app.errorResponse(w, r, http.StatusInternalServerError, serverErrorMessage)
 			}
 		}()
 
@@ -45,6 +55,13 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
+
+		if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+			app.authenticateAPIKey(w, r, apiKey, next)
+			return
+		}
+
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, models.AnonymousUser)
@@ -67,7 +84,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(models.ScopeAuthentication, token)
+		user, err := app.models.Users.GetForToken(r.Context(), models.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, models.ErrRecordNotFound):
@@ -78,11 +95,45 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if user.Suspended {
+			app.accountSuspendedResponse(w, r)
+			return
+		}
+
 		r = app.contextSetUser(r, user)
 		next.ServeHTTP(w, r)
 	})
 }
 
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, keyPlaintext string, next http.Handler) {
+	v := validator.New()
+
+	if models.ValidateAPIKeyPlaintext(v, keyPlaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return
+	}
+
+	user, key, err := app.models.APIKeys.GetForKey(r.Context(), keyPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetAPIKey(r, key)
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) rateLimit(next http.Handler) http.Handler {
 
 	if !app.config.limiter.enabled {
@@ -126,6 +177,9 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 		if !clients[ip].limiter.Allow() {
 			mu.Unlock()
+			if app.metrics != nil {
+				app.metrics.rateLimited.Inc()
+			}
 			app.rateLimitExceededResponse(w, r)
 			return
 		}
@@ -161,6 +215,22 @@ func (app *application) requireActivatedUser(next http.Handler) http.Handler {
 	return app.requireAuthenticatedUser(fn)
 }
 
+// requireTokenAuthentication rejects requests made with an API key. It guards
+// account management endpoints so a restricted key can't be used to mint new
+// keys or otherwise widen its own access.
+func (app *application) requireTokenAuthentication(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireAuthenticatedUser(fn)
+}
+
 func (app *application) ensureTrailingSlash(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !strings.HasSuffix(r.URL.Path, "/") {
@@ -189,11 +259,12 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for _, trustedOrigin := range app.config.cors.trustedOrigins {
 				if trustedOrigin == "*" {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 					// Handle preflight requests
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, X-Request-ID")
 						w.Header().Set("Access-Control-Max-Age", "3600")
 						w.WriteHeader(http.StatusOK)
 						return
@@ -203,11 +274,12 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					return
 				} else if origin == trustedOrigin {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 
 					// Handle preflight requests
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, X-Request-ID")
 						w.Header().Set("Access-Control-Max-Age", "3600")
 						w.WriteHeader(http.StatusOK)
 						return
@@ -229,7 +301,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 func (app *application) requirePermission(code string, next http.Handler) http.Handler {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.models.Permissions.GetAllForUser(r.Context(), user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -240,8 +312,141 @@ func (app *application) requirePermission(code string, next http.Handler) http.H
 			return
 		}
 
+		// Requests made with an API key are further limited to the
+		// permissions the key was created with.
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 
 	return app.requireActivatedUser(fn)
 }
+
+// requestID gives every request an ID, taken from the client's X-Request-ID
+// header when it's a sensible one so a request can be followed across
+// services, and echoes it back in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		r = app.contextSetRequestInfo(r, &requestInfo{id: id})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is short and
+// made of characters that are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_.:/+=", c):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// logRequest writes an access log line for every request once it has been
+// served. It must run inside requestID. Busy servers can sample the lines
+// with config.accessLog.sampleFirst and sampleThereafter.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	logger := app.logger
+	if app.config.accessLog.sampleFirst > 0 {
+		logger = logger.WithSampling(app.config.accessLog.sampleFirst, app.config.accessLog.sampleThereafter, time.Second)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+
+		defer func() {
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+
+			fields := []jsonlog.Field{
+				jsonlog.String("request_id", app.contextGetRequestID(r)),
+				jsonlog.String("request_method", r.Method),
+				jsonlog.String("request_url", r.URL.String()),
+				jsonlog.Int("status", sw.status),
+				jsonlog.Int("bytes", sw.bytes),
+				jsonlog.Duration("duration", time.Since(start)),
+				jsonlog.String("client_ip", app.clientIP(r)),
+			}
+
+			if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.user != nil && !info.user.IsAnonyomous() {
+				fields = append(fields, jsonlog.Int64("user_id", info.user.ID))
+			}
+			if id := traceID(r); id != "" {
+				fields = append(fields, jsonlog.String("trace_id", id))
+			}
+
+			logger.Info("request completed", fields...)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status code a handler sends and how many bytes
+// of body it writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += n
+	return n, err
+}
+
+// Flush lets streamed responses through straight away.
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
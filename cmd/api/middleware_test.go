@@ -2,8 +2,10 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"filmapi.zeyadtarek.net/internals/jsonlog"
@@ -178,8 +180,41 @@ func TestRequireActivatedUser(t *testing.T) {
 
 // TestRequirePermission tests the requirePermission middleware
 func TestRequirePermission(t *testing.T) {
-	// Skip this test for now as it requires more complex mocking
-	t.Skip("Skipping test that requires complex mocking of models.PermissionModel")
+	app, _, writerToken := newMemoryApp(t, "films:read", "films:write")
+	readerToken := addMemoryUser(t, app, "bob@example.com", true, "films:read")
+	inactiveToken := addMemoryUser(t, app, "carol@example.com", false, "films:write")
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := app.authenticate(app.requirePermission("films:write", next))
+
+	tests := []struct {
+		name           string
+		token          string
+		wantStatusCode int
+	}{
+		{name: "Permitted", token: writerToken, wantStatusCode: http.StatusOK},
+		{name: "Missing permission", token: readerToken, wantStatusCode: http.StatusForbidden},
+		{name: "Inactive user", token: inactiveToken, wantStatusCode: http.StatusForbidden},
+		{name: "Anonymous", wantStatusCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("got status %d, want %d: %s", rr.Code, tt.wantStatusCode, rr.Body.String())
+			}
+		})
+	}
 }
 
 // TestContextSetUser and TestContextGetUser test the context functions
@@ -271,3 +306,169 @@ func TestChainMiddleware(t *testing.T) {
 		t.Errorf("middleware2 header not set")
 	}
 }
+
+// TestAuthenticateMalformedAPIKey tests that a badly-formed API key is
+// rejected before the database is consulted
+func TestAuthenticateMalformedAPIKey(t *testing.T) {
+	app := &application{
+		logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+	}
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", "not-a-key")
+
+	rr := httptest.NewRecorder()
+	app.authenticate(nextHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+// TestRequireTokenAuthentication tests that API keys can't reach token-only routes
+func TestRequireTokenAuthentication(t *testing.T) {
+	user := &models.User{ID: 1, Name: "Test User", Email: "test@example.com", Activated: true}
+
+	tests := []struct {
+		name           string
+		apiKey         *models.APIKey
+		wantStatusCode int
+	}{
+		{
+			name:           "Bearer token",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "API key",
+			apiKey:         &models.APIKey{ID: 1, Permissions: models.Permissions{"films:read"}},
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req, err := http.NewRequest("GET", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req = app.contextSetUser(req, user)
+			if tt.apiKey != nil {
+				req = app.contextSetAPIKey(req, tt.apiKey)
+			}
+
+			rr := httptest.NewRecorder()
+			app.requireTokenAuthentication(nextHandler).ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+// TestRequestID tests that a sensible client request ID is kept, others are
+// replaced, and the ID reaches the handler, the response and error bodies
+func TestRequestID(t *testing.T) {
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+
+	tests := []struct {
+		name     string
+		header   string
+		wantKept bool
+	}{
+		{name: "Missing"},
+		{name: "Valid", header: "abc-123.def_456", wantKept: true},
+		{name: "Unsafe characters", header: "abc\"<script>"},
+		{name: "Too long", header: strings.Repeat("a", 129)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			handler := app.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = app.contextGetRequestID(r)
+				app.notFoundResponse(w, r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-Request-ID", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			id := rr.Header().Get("X-Request-ID")
+			if tt.wantKept && id != tt.header {
+				t.Errorf("got request ID %q, want %q", id, tt.header)
+			}
+			if !tt.wantKept && (id == tt.header || !validRequestID(id)) {
+				t.Errorf("got request ID %q, want a new one", id)
+			}
+			if seen != id {
+				t.Errorf("handler saw request ID %q, response has %q", seen, id)
+			}
+			if !strings.Contains(rr.Body.String(), `"request_id":"`+id+`"`) {
+				t.Errorf("error response missing request ID: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestLogRequest tests that an access log line records the request's outcome
+// and the user it turned out to be from
+func TestLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+	app := &application{logger: jsonlog.New(&buf, jsonlog.LevelInfo)}
+
+	handler := app.requestID(app.logRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = app.contextSetUser(r, &models.User{ID: 42})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/films", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line struct {
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{
+		"request_id":     "req-1",
+		"request_method": "POST",
+		"request_url":    "/v1/films",
+		"status":         float64(201),
+		"bytes":          float64(5),
+		"user_id":        float64(42),
+		"client_ip":      "192.0.2.1",
+	}
+	for key, value := range want {
+		if line.Properties[key] != value {
+			t.Errorf("got %s %#v, want %#v", key, line.Properties[key], value)
+		}
+	}
+	if line.Properties["duration"] == nil {
+		t.Error("access log missing duration")
+	}
+}
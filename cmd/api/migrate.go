@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/migrate"
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/migrations"
+)
+
+// newMigrator returns a migrator for the migrations built into the binary.
+func newMigrator(db *sql.DB) (*migrate.Migrator, error) {
+	all, err := migrate.Load(migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(migrate.NewPostgresStore(db), all), nil
+}
+
+// migrateCommand parses `api migrate`. Applied and reverted migrations are
+// logged as they're reported as well as listed in the result.
+func migrateCommand(args []string) (commandFunc, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			migrator, err := newMigrator(db)
+			if err != nil {
+				return nil, err
+			}
+
+			applied, err := migrator.Up(ctx)
+			logMigrations(app.logger, "applied migration", applied)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{"applied": nonNil(applied), "version": migrator.Latest()}, nil
+		}, nil
+
+	case "down":
+		steps := 1
+		switch len(args) {
+		case 1:
+		case 2:
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return nil, errUsage
+			}
+			steps = n
+		default:
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			migrator, err := newMigrator(db)
+			if err != nil {
+				return nil, err
+			}
+
+			reverted, err := migrator.Down(ctx, steps)
+			logMigrations(app.logger, "reverted migration", reverted)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{"reverted": nonNil(reverted)}, nil
+		}, nil
+
+	case "status":
+		if len(args) != 1 {
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			migrator, err := newMigrator(db)
+			if err != nil {
+				return nil, err
+			}
+
+			status, err := migrator.Status(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{"status": status}, nil
+		}, nil
+
+	case "force":
+		if len(args) != 2 {
+			return nil, errUsage
+		}
+
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return nil, errUsage
+		}
+
+		return func(ctx context.Context, app *application, db *sql.DB) (map[string]any, error) {
+			migrator, err := newMigrator(db)
+			if err != nil {
+				return nil, err
+			}
+
+			err = migrator.Force(ctx, version)
+			if err != nil {
+				return nil, err
+			}
+
+			app.logger.Info("schema version forced", jsonlog.Int64("version", version))
+			return map[string]any{"version": version}, nil
+		}, nil
+
+	default:
+		return nil, errUsage
+	}
+}
+
+func logMigrations(logger *jsonlog.Logger, message string, done []migrate.Migration) {
+	for _, migration := range done {
+		logger.Info(message, jsonlog.Int64("version", migration.Version), jsonlog.String("name", migration.Name))
+	}
+}
+
+// checkSchema returns an error if the server can't run against a schema in
+// this state: a migration failed part way through, or migrations this build
+// relies on haven't been applied. A newer schema is allowed, so an older
+// build can still run while a deploy is rolled back.
+func checkSchema(status migrate.Status) error {
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("migration %d failed part way through; repair the schema, then run `api migrate force <version>`", status.Version)
+	case status.Version < models.SchemaVersion:
+		return fmt.Errorf("schema is at version %d, expected %d; run `api migrate up` or start with -migrate-on-start", status.Version, models.SchemaVersion)
+	}
+
+	return nil
+}
+
+// prepareSchema applies pending migrations if -migrate-on-start is set, then
+// checks that the schema is one the server can run against.
+func (app *application) prepareSchema(db *sql.DB) error {
+	migrator, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if app.config.db.migrateOnStart {
+		applied, err := migrator.Up(ctx)
+		logMigrations(app.logger, "applied migration", applied)
+		if err != nil {
+			return err
+		}
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = checkSchema(status)
+	if err != nil {
+		return err
+	}
+
+	if status.Version > models.SchemaVersion {
+		app.logger.Warn("schema is newer than this build expects",
+			jsonlog.Int64("version", status.Version),
+			jsonlog.Int64("expected", models.SchemaVersion),
+		)
+	}
+
+	return nil
+}
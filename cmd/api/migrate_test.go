@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/migrate"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestCheckSchema tests which schema states the server refuses to start on
+func TestCheckSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  migrate.Status
+		wantErr bool
+	}{
+		{name: "Current", status: migrate.Status{Version: models.SchemaVersion}},
+		{name: "Newer", status: migrate.Status{Version: models.SchemaVersion + 1}},
+		{name: "Outdated", status: migrate.Status{Version: models.SchemaVersion - 1}, wantErr: true},
+		{name: "Never migrated", status: migrate.Status{}, wantErr: true},
+		{name: "Dirty", status: migrate.Status{Version: models.SchemaVersion, Dirty: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchema(tt.status)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestMigrateCommandUsage tests that malformed migrate commands are rejected before touching the database
+func TestMigrateCommandUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"sideways"},
+		{"up", "3"},
+		{"down", "0"},
+		{"down", "one"},
+		{"status", "now"},
+		{"force"},
+		{"force", "-1"},
+	} {
+		_, err := migrateCommand(args)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("args %q: got %v, want %v", args, err, errUsage)
+		}
+	}
+}
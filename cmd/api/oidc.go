@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/oidc"
+)
+
+var errNoLinkedAccount = errors.New("no account linked to identity")
+
+func (app *application) setupOIDC() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		IssuerURL:    app.config.oidc.issuer,
+		ClientID:     app.config.oidc.clientID,
+		ClientSecret: app.config.oidc.clientSecret,
+		RedirectURL:  app.config.oidc.redirectURL,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	app.oidc = provider
+	app.oidcStates = oidc.NewStateStore(10 * time.Minute)
+
+	return nil
+}
+
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, login, err := app.oidcStates.Begin()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authURL := app.oidc.AuthCodeURL(state, login.Nonce, oidc.S256Challenge(login.Verifier))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	queryString := r.URL.Query()
+
+	if providerError := queryString.Get("error"); providerError != "" {
+		app.badRequestResponse(w, r, errors.New("identity provider returned an error: "+providerError))
+		return
+	}
+
+	login, ok := app.oidcStates.Take(queryString.Get("state"))
+	if !ok {
+		app.invalidLoginStateResponse(w, r)
+		return
+	}
+
+	rawIDToken, err := app.oidc.Exchange(r.Context(), queryString.Get("code"), login.Verifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchange):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Verify(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrUnknownKey):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !claims.EmailVerified || claims.Email == "" {
+		app.unverifiedEmailResponse(w, r)
+		return
+	}
+
+	user, err := app.userForOIDCClaims(r, claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, errNoLinkedAccount):
+			app.noLinkedAccountResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.accountSuspendedResponse(w, r)
+		return
+	}
+
+	// The provider stands in for the password, not for the authenticator.
+	if app.requireSecondFactor(w, r, user) {
+		return
+	}
+
+	token, err := app.models.Tokens.New(r.Context(), user.ID, 24*time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// userForOIDCClaims finds the local user for a verified provider identity. An
+// identity that was seen before maps straight to its user; otherwise it is
+// linked to the account with the same (provider-verified) email address, or,
+// if auto-provisioning is enabled, to a newly created account.
+func (app *application) userForOIDCClaims(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+	issuer := app.oidc.Issuer()
+
+	user, err := app.models.Identities.GetUser(r.Context(), issuer, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	user, err = app.models.Users.GetByEmail(r.Context(), claims.Email)
+	switch {
+	case err == nil:
+		// The provider vouches for the address, which is all activation proves.
+		if !user.Activated {
+			event, err := app.newAuditEvent(r, "user.activate", "user", user)
+			if err != nil {
+				return nil, err
+			}
+
+			user.Activated = true
+			err = app.models.Users.WithAudit(event).Update(r.Context(), user)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case errors.Is(err, models.ErrRecordNotFound):
+		if !app.config.oidc.autoProvision {
+			return nil, errNoLinkedAccount
+		}
+
+		user, err = app.provisionOIDCUser(r, claims)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, err
+	}
+
+	identity := &models.Identity{
+		UserID:  user.ID,
+		Issuer:  issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+
+	err = app.models.Identities.Insert(r.Context(), identity)
+	if err != nil {
+		return nil, err
+	}
+
+	app.requestLogger(r).PrintInfo("linked openid connect identity", map[string]string{
+		"user_id": strconv.FormatInt(user.ID, 10),
+		"issuer":  issuer,
+	})
+
+	return user, nil
+}
+
+func (app *application) provisionOIDCUser(r *http.Request, claims *oidc.Claims) (*models.User, error) {
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := &models.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	err := user.Password.SetUnusable()
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Users.Insert(r.Context(), user)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := app.newAuditEvent(r, "permissions.grant", "user", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.Permissions.WithAudit(event).AddForUser(r.Context(), user.ID, "films:read")
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
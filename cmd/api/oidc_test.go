@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/oidc"
+	"filmapi.zeyadtarek.net/internals/oidc/oidctest"
+)
+
+func newOIDCTestApp(t *testing.T) (*application, *oidctest.Server) {
+	t.Helper()
+
+	idp := oidctest.NewServer("film-api", "s3cret")
+	t.Cleanup(idp.Close)
+
+	app := &application{
+		logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+	}
+	app.config.oidc.issuer = idp.Issuer()
+	app.config.oidc.clientID = "film-api"
+	app.config.oidc.clientSecret = "s3cret"
+	app.config.oidc.redirectURL = "http://localhost:4000/v1/auth/oidc/callback"
+
+	if err := app.setupOIDC(); err != nil {
+		t.Fatalf("setupOIDC() error = %v", err)
+	}
+
+	return app, idp
+}
+
+// TestOIDCLoginHandler tests that login redirects to the provider with PKCE
+func TestOIDCLoginHandler(t *testing.T) {
+	app, idp := newOIDCTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/auth/oidc/login", nil)
+	rr := httptest.NewRecorder()
+	app.oidcLoginHandler(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := location.Scheme + "://" + location.Host + location.Path; got != idp.URL+"/authorize" {
+		t.Errorf("redirected to %q, want the provider's authorization endpoint", got)
+	}
+
+	query := location.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Errorf("redirect is missing the PKCE challenge: %v", query)
+	}
+
+	login, ok := app.oidcStates.Take(query.Get("state"))
+	if !ok {
+		t.Fatal("state in the redirect was not stored")
+	}
+	if query.Get("code_challenge") != oidc.S256Challenge(login.Verifier) || query.Get("nonce") != login.Nonce {
+		t.Error("redirect doesn't match the stored login state")
+	}
+}
+
+// TestOIDCCallbackHandlerUnknownState tests that callbacks must match a login
+func TestOIDCCallbackHandlerUnknownState(t *testing.T) {
+	app, _ := newOIDCTestApp(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/auth/oidc/callback?code=abc&state=forged", nil)
+	rr := httptest.NewRecorder()
+	app.oidcCallbackHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestOIDCCallbackHandlerTwoFactor tests that logging in through the provider
+// still asks users with an authenticator for a code
+func TestOIDCCallbackHandlerTwoFactor(t *testing.T) {
+	tests := []struct {
+		name           string
+		enrolled       bool
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "Without authenticator", wantStatusCode: http.StatusCreated, wantBody: "authentication_token"},
+		{name: "With authenticator", enrolled: true, wantStatusCode: http.StatusAccepted, wantBody: "2fa_pending_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			app, _, _ := newMemoryApp(t)
+
+			idp := oidctest.NewServer("film-api", "s3cret")
+			t.Cleanup(idp.Close)
+
+			app.config.oidc.issuer = idp.Issuer()
+			app.config.oidc.clientID = "film-api"
+			app.config.oidc.clientSecret = "s3cret"
+			app.config.oidc.redirectURL = "http://localhost:4000/v1/auth/oidc/callback"
+
+			if err := app.setupOIDC(); err != nil {
+				t.Fatalf("setupOIDC() error = %v", err)
+			}
+
+			if tt.enrolled {
+				user, err := app.models.Users.GetByEmail(ctx, "alice@example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := app.models.TOTP.Begin(ctx, user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := app.models.TOTP.Confirm(ctx, user.ID, 1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rr := httptest.NewRecorder()
+			app.oidcLoginHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/auth/oidc/login", nil))
+
+			client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+
+			res, err := client.Get(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			callback, err := url.Parse(res.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr = httptest.NewRecorder()
+			app.oidcCallbackHandler(rr, httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil))
+
+			if rr.Code != tt.wantStatusCode || !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("got status %d and body %s, want %d with %q", rr.Code, rr.Body, tt.wantStatusCode, tt.wantBody)
+			}
+		})
+	}
+}
@@ -12,11 +12,38 @@ func (app *application) routes() http.Handler {
 
 	// Healthcheck
 	router.Handle("GET /v1/healthcheck", http.HandlerFunc(app.healthCheckHandler))
+	router.Handle("GET /v1/healthz", http.HandlerFunc(app.healthzHandler))
+	router.Handle("GET /v1/readyz", http.HandlerFunc(app.readyzHandler))
 
 	// User routes
 	router.Handle("POST /v1/users", http.HandlerFunc(app.createUserHandler))
 	router.Handle("PUT /v1/users/activate", http.HandlerFunc(app.activateUserHandler))
+	router.Handle("POST /v1/tokens/activation", http.HandlerFunc(app.createActivationTokenHandler))
 	router.Handle("POST /v1/tokens/authentication", http.HandlerFunc(app.createAuthenticationTokenHandler))
+	router.Handle("POST /v1/tokens/2fa", http.HandlerFunc(app.createTwoFactorAuthenticationTokenHandler))
+
+	// Single sign-on routes, only when an OpenID Connect provider is configured
+	if app.oidc != nil {
+		router.Handle("GET /v1/auth/oidc/login", http.HandlerFunc(app.oidcLoginHandler))
+		router.Handle("GET /v1/auth/oidc/callback", http.HandlerFunc(app.oidcCallbackHandler))
+	}
+
+	// Account routes; these stay reachable while a changed email address is
+	// waiting to be re-verified
+	router.Handle("GET /v1/users/me", app.requireTokenAuthentication(http.HandlerFunc(app.showCurrentUserHandler)))
+	router.Handle("PATCH /v1/users/me", app.requireTokenAuthentication(http.HandlerFunc(app.updateCurrentUserHandler)))
+	router.Handle("DELETE /v1/users/me", app.requireTokenAuthentication(http.HandlerFunc(app.deleteCurrentUserHandler)))
+	router.Handle("PUT /v1/users/me/password", app.requireTokenAuthentication(http.HandlerFunc(app.changePasswordHandler)))
+
+	// Two-factor authentication routes
+	router.Handle("POST /v1/users/me/totp", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.enrolTOTPHandler))))
+	router.Handle("POST /v1/users/me/totp/confirm", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.confirmTOTPHandler))))
+	router.Handle("DELETE /v1/users/me/totp", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.disableTOTPHandler))))
+
+	// API key routes (require a bearer token, not another API key)
+	router.Handle("GET /v1/users/me/api-keys", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.listAPIKeysHandler))))
+	router.Handle("POST /v1/users/me/api-keys", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.createAPIKeyHandler))))
+	router.Handle("DELETE /v1/users/me/api-keys/{id}", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.deleteAPIKeyHandler))))
 
 	// Films routes
 	router.Handle("GET /v1/films", app.requirePermission("films:read", http.HandlerFunc(app.ListFilmsHandler)))
@@ -25,13 +52,60 @@ func (app *application) routes() http.Handler {
 	router.Handle("PATCH /v1/films/{id}", app.requirePermission("films:write", http.HandlerFunc(app.updateFilmHandler)))
 	router.Handle("DELETE /v1/films/{id}", app.requirePermission("films:write", http.HandlerFunc(app.deleteFilmHandler)))
 
+	// Change stream
+	router.Handle("GET /v1/events", app.requirePermission("films:read", http.HandlerFunc(app.streamEventsHandler)))
+
 	// Watchlist routes (require authentication)
-	router.Handle("GET /v1/watchlist", app.requireActivatedUser(http.HandlerFunc(app.getWatchlistHandler)))
-	router.Handle("POST /v1/watchlist", app.requireActivatedUser(http.HandlerFunc(app.addToWatchlistHandler)))
-	router.Handle("GET /v1/watchlist/{id}", app.requireActivatedUser(http.HandlerFunc(app.getWatchlistEntryHandler)))
-	router.Handle("PATCH /v1/watchlist/{id}", app.requireActivatedUser(http.HandlerFunc(app.updateWatchlistEntryHandler)))
-	router.Handle("DELETE /v1/watchlist/{id}", app.requireActivatedUser(http.HandlerFunc(app.removeFromWatchlistHandler)))
+	router.Handle("GET /v1/watchlist", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.getWatchlistHandler))))
+	router.Handle("POST /v1/watchlist", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.addToWatchlistHandler))))
+	router.Handle("GET /v1/watchlist/{id}", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.getWatchlistEntryHandler))))
+	router.Handle("PATCH /v1/watchlist/{id}", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.updateWatchlistEntryHandler))))
+	router.Handle("DELETE /v1/watchlist/{id}", app.requireActivatedUser(app.requireTokenAuthentication(http.HandlerFunc(app.removeFromWatchlistHandler))))
+
+	// Webhook routes
+	router.Handle("GET /v1/webhooks", app.requirePermission("webhooks:manage", http.HandlerFunc(app.listWebhooksHandler)))
+	router.Handle("POST /v1/webhooks", app.requirePermission("webhooks:manage", http.HandlerFunc(app.createWebhookHandler)))
+	router.Handle("GET /v1/webhooks/{id}", app.requirePermission("webhooks:manage", http.HandlerFunc(app.showWebhookHandler)))
+	router.Handle("PATCH /v1/webhooks/{id}", app.requirePermission("webhooks:manage", http.HandlerFunc(app.updateWebhookHandler)))
+	router.Handle("DELETE /v1/webhooks/{id}", app.requirePermission("webhooks:manage", http.HandlerFunc(app.deleteWebhookHandler)))
+	router.Handle("GET /v1/webhooks/{id}/deliveries", app.requirePermission("webhooks:manage", http.HandlerFunc(app.listWebhookDeliveriesHandler)))
+	router.Handle("POST /v1/webhooks/{id}/deliveries/{delivery_id}/redeliver", app.requirePermission("webhooks:manage", http.HandlerFunc(app.redeliverWebhookHandler)))
+
+	// Admin routes
+	router.Handle("GET /v1/admin/users", app.requirePermission("users:admin", http.HandlerFunc(app.listUsersHandler)))
+	router.Handle("GET /v1/admin/users/{id}", app.requirePermission("users:admin", http.HandlerFunc(app.showUserHandler)))
+	router.Handle("PATCH /v1/admin/users/{id}", app.requirePermission("users:admin", http.HandlerFunc(app.updateUserStatusHandler)))
+	router.Handle("POST /v1/admin/users/{id}/logout", app.requirePermission("users:admin", http.HandlerFunc(app.logoutUserHandler)))
+	router.Handle("GET /v1/admin/users/{id}/permissions", app.requirePermission("users:admin", http.HandlerFunc(app.listUserPermissionsHandler)))
+	router.Handle("GET /v1/admin/cache", app.requirePermission("users:admin", http.HandlerFunc(app.showCacheStatsHandler)))
+	router.Handle("GET /v1/admin/log-level", app.requirePermission("users:admin", http.HandlerFunc(app.showLogLevelHandler)))
+	router.Handle("PUT /v1/admin/log-level", app.requirePermission("users:admin", http.HandlerFunc(app.updateLogLevelHandler)))
+	router.Handle("GET /v1/admin/jobs", app.requirePermission("users:admin", http.HandlerFunc(app.listJobsHandler)))
+	router.Handle("GET /v1/admin/tasks/dead", app.requirePermission("users:admin", http.HandlerFunc(app.listDeadTasksHandler)))
+	router.Handle("POST /v1/admin/tasks/{id}/retry", app.requirePermission("users:admin", http.HandlerFunc(app.retryTaskHandler)))
+	router.Handle("GET /v1/admin/audit", app.requirePermission("users:admin", http.HandlerFunc(app.listAuditEventsHandler)))
+	router.Handle("DELETE /v1/admin/users/{id}/lockout", app.requirePermission("users:admin", http.HandlerFunc(app.unlockUserHandler)))
+
+	// Metrics, unless they have a listener of their own
+	if app.metrics != nil && app.config.metrics.addr == "" {
+		router.Handle("GET /metrics", app.requirePermission("users:admin", app.metrics.registry.Handler()))
+	}
 
 	// Chain middleware
-	return app.chainMiddleware(router, app.recoverPanic, app.rateLimit, app.authenticate, app.enableCORS)
+	middleware := []func(http.Handler) http.Handler{app.recoverPanic, app.rateLimit, app.authenticate, app.enableCORS}
+	if app.config.compression.enabled {
+		middleware = append([]func(http.Handler) http.Handler{app.compress}, middleware...)
+	}
+	if app.metrics != nil {
+		middleware = append([]func(http.Handler) http.Handler{app.instrument(router)}, middleware...)
+	}
+	if app.config.accessLog.enabled {
+		middleware = append([]func(http.Handler) http.Handler{app.logRequest}, middleware...)
+	}
+	if app.config.tracing.exporter != "none" {
+		middleware = append([]func(http.Handler) http.Handler{app.traceRequest(router)}, middleware...)
+	}
+	middleware = append([]func(http.Handler) http.Handler{app.requestID}, middleware...)
+
+	return app.chainMiddleware(router, middleware...)
 }
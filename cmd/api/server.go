@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"syscall"
 	"time"
+
+	"filmapi.zeyadtarek.net/internals/events"
 )
 
 func (app *application) serve() error {
@@ -49,6 +51,52 @@ func (app *application) serve() error {
 		IdleTimeout:  time.Minute,
 	}
 
+	if app.scheduler != nil {
+		app.scheduler.Start(context.Background())
+	}
+
+	if app.tasks != nil {
+		app.tasks.Start(context.Background())
+	}
+
+	if app.events != nil {
+		wake, unlisten, err := events.Listen(app.config.db.dsn, app.logger)
+		if err != nil {
+			return err
+		}
+		defer unlisten()
+
+		// Streams never finish on their own, so stopping the broker when
+		// shutdown starts is what lets their handlers return.
+		ctx, cancel := context.WithCancel(context.Background())
+		srv.RegisterOnShutdown(cancel)
+
+		app.background(func() {
+			err := app.events.Run(ctx, wake)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	var metricsSrv *http.Server
+	if app.metrics != nil && app.config.metrics.addr != "" {
+		metricsSrv = app.metricsServer()
+
+		app.logger.PrintInfo("starting metrics server", map[string]string{
+			"addr": metricsSrv.Addr,
+		})
+
+		go func() {
+			err := metricsSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.PrintError(err, map[string]string{
+					"addr": metricsSrv.Addr,
+				})
+			}
+		}()
+	}
+
 	shutdownError := make(chan error)
 
 	go func() {
@@ -62,7 +110,29 @@ func (app *application) serve() error {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
+		// Keep serving metrics until the API has drained, so the last
+		// requests are still scraped.
+		err := srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			metricsSrv.Shutdown(ctx)
+		}
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.PrintInfo("completing background tasks", map[string]string{
+			"addr": srv.Addr,
+		})
+
+		if app.scheduler != nil {
+			app.scheduler.Stop()
+		}
+		if app.tasks != nil {
+			app.tasks.Stop()
+		}
+		app.wg.Wait()
+		shutdownError <- nil
 	}()
 
 	app.logger.PrintInfo("starting server", map[string]string{
@@ -82,5 +152,9 @@ func (app *application) serve() error {
 		return err
 	}
 
+	app.logger.PrintInfo("stopped server", map[string]string{
+		"addr": srv.Addr,
+	})
+
 	return nil
 }
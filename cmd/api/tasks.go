@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"filmapi.zeyadtarek.net/internals/queue"
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+// registerTaskHandlers tells the queue how to run each kind of task.
+func (app *application) registerTaskHandlers() error {
+	return app.tasks.Handle(taskDeliverWebhook, app.deliverWebhookTask)
+}
+
+func (app *application) listDeadTasksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 50, v)
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 500, "limit", "must be a maximum of 500")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	tasks := []*queue.Task{}
+	if app.tasks != nil {
+		var err error
+		tasks, err = app.tasks.Dead(r.Context(), limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"tasks": tasks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// retryTaskHandler puts a dead-lettered task back on the queue with a fresh
+// set of attempts.
+func (app *application) retryTaskHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 || app.tasks == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.tasks.Requeue(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, queue.ErrTaskNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	event, err := app.newAuditEvent(r, "task.retry", "task", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Audit.Insert(r.Context(), event, id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, map[string]any{"message": "task queued for retry"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+// TestListDeadTasksHandler tests limit validation and the empty list returned
+// when there is no queue
+func TestListDeadTasksHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "Default limit", query: "", wantStatusCode: http.StatusOK, wantBody: `"tasks":[]`},
+		{name: "Zero limit", query: "?limit=0", wantStatusCode: http.StatusUnprocessableEntity, wantBody: "greater than zero"},
+		{name: "Limit too large", query: "?limit=501", wantStatusCode: http.StatusUnprocessableEntity, wantBody: "maximum of 500"},
+		{name: "Non-numeric limit", query: "?limit=all", wantStatusCode: http.StatusUnprocessableEntity, wantBody: "integer"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/tasks/dead"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			app.listDeadTasksHandler(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+// TestRetryTaskHandlerNotFound tests that malformed IDs are rejected before
+// the queue is consulted
+func TestRetryTaskHandlerNotFound(t *testing.T) {
+	for _, id := range []string{"abc", "0", "-3"} {
+		t.Run(id, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/admin/tasks/"+id+"/retry", nil)
+			req.SetPathValue("id", id)
+			rr := httptest.NewRecorder()
+			app.retryTaskHandler(rr, req)
+
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/totp"
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+// totpSkew is how many 30 second steps of clock drift are tolerated either way.
+const totpSkew = 1
+
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTP.Begin(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTOTPAlreadyEnabled):
+			v := validator.New()
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.faliedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response := map[string]any{
+		"totp": map[string]string{
+			"secret":           secret,
+			"provisioning_uri": totp.ProvisioningURI(secret, app.config.totp.issuer, user.Email),
+		},
+	}
+
+	err = app.writeJSON(w, r, http.StatusCreated, response, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if models.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	twoFactor, err := app.models.TOTP.Get(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			v.AddError("totp", "enrolment has not been started")
+			app.faliedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if twoFactor.Enabled() {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now(), totpSkew)
+	if !ok {
+		v.AddError("code", "is incorrect, check your authenticator's clock and try again")
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	recoveryCodes, err := app.models.TOTP.Confirm(r.Context(), user.ID, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrTOTPAlreadyEnabled):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Recovery codes are stored hashed, so this is the only time they're shown.
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+		RecoveryCode    string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// This also stops anyone locked out of logging in from guessing codes.
+	if !app.confirmPassword(w, r, user, input.CurrentPassword) {
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidSecondFactorResponse(w, r)
+		return
+	}
+
+	err = app.models.TOTP.Delete(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "two-factor authentication disabled successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createTwoFactorAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	models.ValidateTokenPlaintext(v, input.TokenPlaintext)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "a code or a recovery_code must be provided")
+	if !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), models.ScopeTwoFactorPending, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords.
+	retryAfter, err := app.loginRetryAfter(r.Context(), user.Email, app.clientIP(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if retryAfter > 0 {
+		app.tooManyLoginAttemptsResponse(w, r, retryAfter)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(r.Context(), user, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.invalidSecondFactorResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.invalidSecondFactorResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(r.Context(), models.ScopeTwoFactorPending, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.issueAuthenticationToken(w, r, user)
+}
+
+// verifySecondFactor checks an authenticator code or, failing that, burns a
+// recovery code. It returns ErrRecordNotFound when the user has no confirmed
+// enrolment.
+func (app *application) verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	twoFactor, err := app.models.TOTP.Get(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if !twoFactor.Enabled() {
+		return false, models.ErrRecordNotFound
+	}
+
+	if code != "" {
+		step, ok := totp.Validate(twoFactor.Secret, code, time.Now(), totpSkew)
+		if !ok {
+			return false, nil
+		}
+
+		return app.models.TOTP.UseStep(ctx, user.ID, step)
+	}
+
+	if recoveryCode != "" {
+		return app.models.TOTP.UseRecoveryCode(ctx, user.ID, recoveryCode)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/totp"
+)
+
+// TestCreateTwoFactorAuthenticationTokenHandlerValidation tests that malformed
+// exchanges are rejected before any lookups happen
+func TestCreateTwoFactorAuthenticationTokenHandlerValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{
+			name:           "Missing code",
+			body:           `{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Malformed token",
+			body:           `{"token": "short", "code": "123456"}`,
+			wantStatusCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Unknown field",
+			body:           `{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "password": "x"}`,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/tokens/2fa", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			app.createTwoFactorAuthenticationTokenHandler(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+		})
+	}
+}
+
+// TestDisableTOTPHandler tests that turning two-factor authentication off
+// takes the password and a code, and that wrong codes lead to a lockout
+func TestDisableTOTPHandler(t *testing.T) {
+	ctx := context.Background()
+	app, _, token := newMemoryApp(t)
+	app.config.login.lockoutThreshold = 2
+	app.config.login.lockoutDuration = 15 * time.Minute
+	app.config.login.failureWindow = time.Hour
+
+	user, err := app.models.Users.GetForToken(ctx, models.ScopeAuthentication, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "JBSWY3DPEHPK3PXP"
+	if err := app.models.TOTP.Begin(ctx, user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.models.TOTP.Confirm(ctx, user.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "Missing password", body: `{"code": "` + code + `"}`, wantStatusCode: http.StatusUnprocessableEntity},
+		{name: "Wrong code", body: `{"current_password": "pa55word1234", "code": "` + wrongCode + `"}`, wantStatusCode: http.StatusUnauthorized},
+		{name: "Wrong code again", body: `{"current_password": "pa55word1234", "code": "` + wrongCode + `"}`, wantStatusCode: http.StatusUnauthorized},
+		{name: "Locked out", body: `{"current_password": "pa55word1234", "code": "` + code + `"}`, wantStatusCode: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := serve(t, app, http.MethodDelete, "/v1/users/me/totp", token, tt.body, nil)
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("got status %d, want %d", rr.Code, tt.wantStatusCode)
+			}
+		})
+	}
+
+	if _, err := app.models.TOTP.Get(ctx, user.ID); err != nil {
+		t.Errorf("getting the enrolment after the failed attempts: got %v, want it kept", err)
+	}
+
+	if err := app.models.Logins.Reset(ctx, models.LoginScopeAccount, user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.models.Logins.Reset(ctx, models.LoginScopeIP, app.clientIP(httptest.NewRequest(http.MethodDelete, "/", nil))); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := serve(t, app, http.MethodDelete, "/v1/users/me/totp", token, `{"current_password": "pa55word1234", "code": "`+code+`"}`, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("disabling with the password and a code: got status %d and body %s, want %d", rr.Code, rr.Body, http.StatusOK)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "filmapi.zeyadtarek.net/cmd/api"
+
+// setupTracing installs the global tracer provider for the configured
+// exporter. The returned function flushes spans that haven't been exported
+// yet and must be called before the program exits.
+//
+// The otlp exporter sends spans over HTTP to the endpoint in the standard
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)
+// variable, localhost:4318 by default. The stdout exporter writes them as
+// JSON to standard output, or to config.tracing.file if it's set, so traces
+// can be read without running a collector.
+func setupTracing(cfg config) (func(context.Context) error, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+
+	switch cfg.tracing.exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+	case "stdout":
+		var out io.Writer = os.Stdout
+		if cfg.tracing.file != "" {
+			file, err := os.OpenFile(cfg.tracing.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			out, closer = file, file
+		}
+
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.tracing.exporter)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", "filmapi"),
+			attribute.String("service.version", version),
+			attribute.String("deployment.environment.name", cfg.env),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.tracing.sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// sqlOperation returns the SQL command query starts with, such as "SELECT".
+// Common table expressions report "WITH" rather than the statement they
+// lead into.
+func sqlOperation(query string) string {
+	query = strings.TrimSpace(query)
+	if end := strings.IndexFunc(query, unicode.IsSpace); end >= 0 {
+		query = query[:end]
+	}
+	return strings.ToUpper(query)
+}
+
+// sqlTraceOptions make otelsql record a span for every statement, with the
+// SQL operation and query text as attributes. Statements run outside a
+// sampled trace, such as the task workers' polling, aren't recorded, so they
+// don't flood the exporter with traces of their own.
+func sqlTraceOptions() []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(attribute.String("db.system", "postgresql")),
+		otelsql.WithAttributesGetter(func(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{attribute.String("db.operation.name", sqlOperation(query))}
+		}),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+			SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+				return trace.SpanContextFromContext(ctx).IsSampled()
+			},
+		}),
+	}
+}
+
+// traceRequest starts a server span for every request, continuing the trace
+// in the W3C traceparent header if the caller sent one. Spans are named after
+// the route that matched, such as "GET /v1/films/{id}", rather than the path,
+// so requests for different films group together.
+func (app *application) traceRequest(router *http.ServeMux) func(http.Handler) http.Handler {
+	tracer := otel.Tracer(tracerName)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			method, route := requestLabels(router, r)
+
+			name := method
+			attrs := []attribute.KeyValue{
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", app.clientIP(r)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			}
+			if route != "unmatched" {
+				name += " " + route
+				attrs = append(attrs, attribute.String("http.route", route))
+			}
+			if id := app.contextGetRequestID(r); id != "" {
+				attrs = append(attrs, attribute.String("http.request.id", id))
+			}
+
+			ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+			defer span.End()
+
+			sw := &statusWriter{ResponseWriter: w}
+			r = r.WithContext(ctx)
+
+			defer func() {
+				if sw.status == 0 {
+					sw.status = http.StatusOK
+				}
+
+				span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+				if sw.status >= http.StatusInternalServerError {
+					span.SetStatus(codes.Error, http.StatusText(sw.status))
+				}
+
+				if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok && info.user != nil && !info.user.IsAnonyomous() {
+					span.SetAttributes(attribute.Int64("enduser.id", info.user.ID))
+				}
+			}()
+
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// traceID returns the ID of the trace r is part of, or "" if it isn't
+// sampled, since only sampled traces can be looked up.
+func traceID(r *http.Request) string {
+	spanContext := trace.SpanContextFromContext(r.Context())
+	if !spanContext.IsSampled() {
+		return ""
+	}
+
+	return spanContext.TraceID().String()
+}
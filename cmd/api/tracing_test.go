@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider that keeps every span for the
+// length of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return recorder
+}
+
+// TestTraceRequest tests that requests get a server span named after their
+// route which continues the caller's trace and records the status
+func TestTraceRequest(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var logs bytes.Buffer
+	app := &application{logger: jsonlog.New(&logs, jsonlog.LevelInfo)}
+	app.config.accessLog.enabled = true
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /v1/films/{id}", func(w http.ResponseWriter, r *http.Request) {
+		app.serverErrorResponse(w, r, http.ErrAbortHandler)
+	})
+	handler := app.requestID(app.traceRequest(router)(app.logRequest(router)))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/films/7", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name() != "GET /v1/films/{id}" {
+		t.Errorf("got span name %q, want %q", span.Name(), "GET /v1/films/{id}")
+	}
+	if got := span.SpanContext().TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("got trace ID %s, want the caller's", got)
+	}
+	if got := span.Parent().SpanID().String(); got != "b7ad6b7169203331" {
+		t.Errorf("got parent span %s, want the caller's", got)
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("got span status %v, want Error", span.Status().Code)
+	}
+
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	if got := attrs["http.route"].AsString(); got != "/v1/films/{id}" {
+		t.Errorf("got http.route %q, want %q", got, "/v1/films/{id}")
+	}
+	if got := attrs["http.response.status_code"].AsInt64(); got != http.StatusInternalServerError {
+		t.Errorf("got http.response.status_code %d, want %d", got, http.StatusInternalServerError)
+	}
+	if attrs["http.request.id"].AsString() == "" {
+		t.Error("span has no http.request.id")
+	}
+
+	// Every line written for the request carries the trace ID.
+	lines := bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want the error and the access log", len(lines))
+	}
+	for _, line := range lines {
+		var entry struct {
+			Properties map[string]any `json:"properties"`
+		}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if got := entry.Properties["trace_id"]; got != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("got trace_id %v in %s, want the caller's", got, line)
+		}
+	}
+}
+
+// TestTraceRequestUnmatched tests that requests matching no route are named
+// after their method alone
+func TestTraceRequestUnmatched(t *testing.T) {
+	recorder := recordSpans(t)
+
+	app := &application{logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo)}
+	router := http.NewServeMux()
+	app.traceRequest(router)(router).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Name() != "GET" {
+		t.Errorf("got span name %q, want %q", spans[0].Name(), "GET")
+	}
+	if spans[0].SpanContext().IsValid() && spans[0].Parent().IsValid() {
+		t.Error("span has a parent, want a new trace")
+	}
+}
+
+// TestSQLOperation tests that the SQL command is taken from the start of a
+// query
+func TestSQLOperation(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                            "SELECT",
+		"\n\t\tinsert INTO films\n\t\tVALUES": "INSERT",
+		"\n\t\tWITH granted AS (":             "WITH",
+		"":                                    "",
+	}
+
+	for query, want := range tests {
+		if got := sqlOperation(query); got != want {
+			t.Errorf("sqlOperation(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/queue"
+	"filmapi.zeyadtarek.net/internals/validator"
+	"filmapi.zeyadtarek.net/internals/webhook"
+)
+
+// taskDeliverWebhook is the queue task that sends one webhook delivery.
+const taskDeliverWebhook = "webhook.deliver"
+
+// webhookRelayBatch is how many outbox events are relayed per transaction.
+const webhookRelayBatch = 100
+
+// maxWebhookResponseBody caps how much of a receiver's response is logged.
+const maxWebhookResponseBody = 1024
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	webhooks, err := app.models.Webhooks.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	hook := &models.Webhook{
+		UserID: user.ID,
+		URL:    input.URL,
+		Events: input.Events,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, hook); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hook.Secret, err = webhook.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", hook.ID))
+
+	// Like an API key, the secret is only shown once.
+	err = app.writeJSON(w, r, http.StatusCreated, map[string]any{"webhook": hook, "secret": hook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, r, http.StatusOK, map[string]any{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	v := validator.New()
+	if models.ValidateWebhook(v, hook); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(r.Context(), hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Webhooks.Delete(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status  string
+		Filters models.Filters
+	}
+
+	v := validator.New()
+	queryString := r.URL.Query()
+	input.Status = app.readString(queryString, "status", "")
+	input.Filters.Page = app.readInt(queryString, "page", 1, v)
+	input.Filters.PageSize = app.readInt(queryString, "page_size", 20, v)
+	input.Filters.SortValues = app.readCSV(queryString, "sort", []string{"-id"})
+	input.Filters.SortSafelist = []string{"id", "-id"}
+
+	if input.Status != "" {
+		v.Check(validator.In(input.Status, models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed), "status", "must be pending, succeeded or failed")
+	}
+
+	if models.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.faliedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetDeliveries(r.Context(), hook.ID, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, map[string]any{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// redeliverWebhookHandler sends an earlier delivery's event again as a new
+// delivery.
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery_id"), 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := app.models.Webhooks.Redeliver(r.Context(), hook.ID, deliveryID, app.enqueueWebhookDelivery)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, map[string]any{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// webhookFromPath loads the caller's webhook named by the {id} path value.
+// When it returns false the error response has already been sent.
+func (app *application) webhookFromPath(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+
+	hook, err := app.models.Webhooks.Get(r.Context(), id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return hook, true
+}
+
+// enqueueWebhookDelivery schedules a delivery as part of the transaction that
+// created it.
+func (app *application) enqueueWebhookDelivery(ctx context.Context, tx *sql.Tx, deliveryID int64) error {
+	_, err := app.tasks.EnqueueTx(ctx, tx, taskDeliverWebhook, map[string]int64{"delivery_id": deliveryID})
+	return err
+}
+
+// relayWebhookEventsJob drains the outbox into deliveries.
+func (app *application) relayWebhookEventsJob(ctx context.Context) error {
+	for ctx.Err() == nil {
+		relayed, err := app.models.Webhooks.Relay(ctx, webhookRelayBatch, app.enqueueWebhookDelivery)
+		if err != nil {
+			return err
+		}
+
+		if relayed < webhookRelayBatch {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// deliverWebhookTask sends a delivery and records the attempt. Failed
+// attempts are retried by the queue; once it runs out of attempts the
+// delivery is marked failed.
+func (app *application) deliverWebhookTask(ctx context.Context, task *queue.Task) error {
+	var payload struct {
+		DeliveryID int64 `json:"delivery_id"`
+	}
+
+	err := json.Unmarshal(task.Payload, &payload)
+	if err != nil {
+		return queue.Permanent(err)
+	}
+
+	delivery, hook, err := app.models.Webhooks.GetDelivery(ctx, payload.DeliveryID)
+	if err != nil {
+		// The webhook, and its deliveries with it, has been deleted.
+		if errors.Is(err, models.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if delivery.Status != models.DeliveryPending {
+		return nil
+	}
+
+	if !hook.Active {
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = "webhook is inactive"
+		return app.models.Webhooks.RecordAttempt(ctx, delivery)
+	}
+
+	sendErr := app.sendWebhook(ctx, hook, delivery)
+	if sendErr != nil && task.Attempts >= task.MaxAttempts {
+		delivery.Status = models.DeliveryFailed
+	}
+
+	err = app.models.Webhooks.RecordAttempt(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+// errWebhookAddressBlocked is returned when a webhook URL resolves to an
+// address that isn't on the public internet.
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, which IsPrivate doesn't
+// cover.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// newWebhookClient returns the client webhooks are delivered with. Webhook
+// URLs are chosen by users, so it only connects to public addresses: the
+// check runs on the address being dialled, after DNS resolution, so a name
+// pointing at the loopback interface, the private network or the cloud
+// metadata service (169.254.169.254) is refused too. Redirects aren't
+// followed: the receiver must answer the POST itself, and a 3xx is recorded
+// as a failed delivery. Proxies are ignored, as the check would only see the
+// proxy's address.
+func newWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !publicAddress(addr) {
+				return fmt.Errorf("dial %s: %w", address, errWebhookAddressBlocked)
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// publicAddress reports whether addr is a unicast address on the public
+// internet.
+func publicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// sendWebhook makes one signed POST of delivery to the webhook's URL and
+// fills in the outcome on delivery. A 2xx response marks it succeeded; any
+// other outcome is returned as an error.
+func (app *application) sendWebhook(ctx context.Context, hook *models.Webhook, delivery *models.WebhookDelivery) error {
+	body, err := json.Marshal(map[string]any{
+		"id":          delivery.EventID,
+		"event":       delivery.Event,
+		"occurred_at": delivery.OccurredAt,
+		"data":        delivery.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = nil
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "filmapi-webhooks/"+version)
+	req.Header.Set(webhook.EventHeader, delivery.Event)
+	req.Header.Set(webhook.DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(hook.Secret, now, body))
+
+	res, err := app.webhookClient.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return err
+	}
+	defer res.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(res.Body, maxWebhookResponseBody))
+	delivery.ResponseStatus = &res.StatusCode
+	delivery.ResponseBody = string(responseBody)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		err = fmt.Errorf("webhook %d responded with status %d", hook.ID, res.StatusCode)
+		delivery.Error = err.Error()
+		return err
+	}
+
+	delivery.Status = models.DeliverySucceeded
+	return nil
+}
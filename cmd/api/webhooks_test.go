@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"filmapi.zeyadtarek.net/internals/models"
+	"filmapi.zeyadtarek.net/internals/webhook"
+)
+
+// TestSendWebhook tests the signed request sent to a receiver and how its
+// response is recorded on the delivery
+func TestSendWebhook(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		response     string
+		wantErr      bool
+		wantStatus   string
+		wantResponse string
+	}{
+		{name: "Accepted", status: http.StatusNoContent, wantStatus: models.DeliverySucceeded},
+		{name: "Server error", status: http.StatusBadGateway, response: "upstream down", wantErr: true, wantStatus: models.DeliveryPending, wantResponse: "upstream down"},
+		{name: "Long response", status: http.StatusBadRequest, response: strings.Repeat("x", 5000), wantErr: true, wantStatus: models.DeliveryPending, wantResponse: strings.Repeat("x", maxWebhookResponseBody)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &models.Webhook{ID: 3, Secret: "whsec_test"}
+
+			var received *http.Request
+			var receivedBody []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				receivedBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+			hook.URL = server.URL
+
+			app := &application{
+				logger:        jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+				webhookClient: server.Client(),
+			}
+
+			delivery := &models.WebhookDelivery{
+				ID:         11,
+				EventID:    42,
+				Event:      models.EventFilmUpdated,
+				Payload:    json.RawMessage(`{"id":7,"title":"Heat"}`),
+				OccurredAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+				Status:     models.DeliveryPending,
+			}
+
+			err := app.sendWebhook(context.Background(), hook, delivery)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sendWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if delivery.Status != tt.wantStatus {
+				t.Errorf("got delivery status %q, want %q", delivery.Status, tt.wantStatus)
+			}
+			if delivery.Attempts != 1 || delivery.LastAttemptAt == nil {
+				t.Errorf("attempt was not recorded: %+v", delivery)
+			}
+			if delivery.ResponseStatus == nil || *delivery.ResponseStatus != tt.status {
+				t.Errorf("got response status %v, want %d", delivery.ResponseStatus, tt.status)
+			}
+			if delivery.ResponseBody != tt.wantResponse {
+				t.Errorf("got response body of %d bytes, want %d", len(delivery.ResponseBody), len(tt.wantResponse))
+			}
+
+			if received.Header.Get(webhook.EventHeader) != models.EventFilmUpdated {
+				t.Errorf("got event header %q", received.Header.Get(webhook.EventHeader))
+			}
+			if received.Header.Get(webhook.DeliveryHeader) != "11" {
+				t.Errorf("got delivery header %q", received.Header.Get(webhook.DeliveryHeader))
+			}
+
+			err = webhook.Verify(hook.Secret, received.Header.Get(webhook.SignatureHeader), receivedBody, time.Now(), time.Minute)
+			if err != nil {
+				t.Errorf("signature did not verify: %v", err)
+			}
+
+			var envelope struct {
+				ID    int64           `json:"id"`
+				Event string          `json:"event"`
+				Data  json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(receivedBody, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.ID != 42 || envelope.Event != models.EventFilmUpdated || string(envelope.Data) != `{"id":7,"title":"Heat"}` {
+				t.Errorf("unexpected body %s", receivedBody)
+			}
+		})
+	}
+}
+
+// TestSendWebhookUnreachable tests that connection errors are recorded
+func TestSendWebhookUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	app := &application{webhookClient: &http.Client{Timeout: time.Second}}
+	delivery := &models.WebhookDelivery{Status: models.DeliveryPending}
+
+	err := app.sendWebhook(context.Background(), &models.Webhook{URL: url, Secret: "s"}, delivery)
+	if err == nil {
+		t.Fatal("sendWebhook() succeeded against a closed server")
+	}
+
+	if delivery.Error == "" || delivery.ResponseStatus != nil || delivery.Status != models.DeliveryPending {
+		t.Errorf("unexpected delivery after connection error: %+v", delivery)
+	}
+}
+
+// TestNewWebhookClient tests that deliveries only reach public addresses and
+// don't follow redirects
+func TestNewWebhookClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	t.Run("Loopback", func(t *testing.T) {
+		app := &application{webhookClient: newWebhookClient(time.Second)}
+		delivery := &models.WebhookDelivery{Status: models.DeliveryPending}
+
+		err := app.sendWebhook(context.Background(), &models.Webhook{URL: server.URL, Secret: "s"}, delivery)
+		if !errors.Is(err, errWebhookAddressBlocked) {
+			t.Errorf("got error %v, want %v", err, errWebhookAddressBlocked)
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		client := newWebhookClient(time.Second)
+		client.Transport = server.Client().Transport
+
+		app := &application{webhookClient: client}
+		delivery := &models.WebhookDelivery{Status: models.DeliveryPending}
+
+		err := app.sendWebhook(context.Background(), &models.Webhook{URL: server.URL, Secret: "s"}, delivery)
+		if err == nil || delivery.ResponseStatus == nil || *delivery.ResponseStatus != http.StatusFound {
+			t.Errorf("got delivery %+v and error %v, want the redirect recorded as a failure", delivery, err)
+		}
+	})
+}
+
+// TestPublicAddress tests which addresses webhooks may be delivered to
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.216.34", want: true},
+		{addr: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{addr: "127.0.0.1"},
+		{addr: "::1"},
+		{addr: "10.1.2.3"},
+		{addr: "172.16.0.1"},
+		{addr: "192.168.1.1"},
+		{addr: "100.64.0.1"},
+		{addr: "169.254.169.254"},
+		{addr: "fe80::1"},
+		{addr: "fd00::1"},
+		{addr: "0.0.0.0"},
+		{addr: "224.0.0.1"},
+		{addr: "::ffff:127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := publicAddress(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("publicAddress(%s) = %t, want %t", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCreateWebhookHandlerValidation tests requests rejected before anything
+// is stored
+func TestCreateWebhookHandlerValidation(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "Missing URL", body: `{"events": ["film.created"]}`, wantStatusCode: http.StatusUnprocessableEntity, wantBody: "url"},
+		{name: "Unknown event", body: `{"url": "https://example.com", "events": ["film.rated"]}`, wantStatusCode: http.StatusUnprocessableEntity, wantBody: "unknown event"},
+		{name: "Unknown field", body: `{"url": "https://example.com", "secret": "mine"}`, wantStatusCode: http.StatusBadRequest, wantBody: "unknown key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger: jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo),
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/webhooks", strings.NewReader(tt.body))
+			req = app.contextSetUser(req, &models.User{ID: 1, Activated: true})
+			rr := httptest.NewRecorder()
+			app.createWebhookHandler(rr, req)
+
+			if rr.Code != tt.wantStatusCode {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.wantStatusCode)
+			}
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("body %q does not contain %q", rr.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package cache holds recently read values so repeated reads can skip the
+// database. Callers must treat cached values as read-only and copy them
+// before handing them out.
+package cache
+
+// Cache stores values by key until they expire, are evicted to make room, or
+// are deleted.
+type Cache interface {
+	// Get returns the value stored under key and whether there was one.
+	Get(key string) (any, bool)
+	// Set stores value under key, replacing any existing value.
+	Set(key string, value any)
+	// SetIfUnchanged stores value like Set, unless Delete or DeletePrefix
+	// has been called since Generation returned generation. A value loaded
+	// while it was being invalidated may be stale, so it's dropped. It
+	// reports whether value was stored.
+	SetIfUnchanged(key string, value any, generation uint64) bool
+	// Generation returns a number that every Delete and DeletePrefix
+	// increases. Read it before loading a value to cache.
+	Generation() uint64
+	// Delete removes key.
+	Delete(key string)
+	// DeletePrefix removes every key starting with prefix.
+	DeletePrefix(prefix string)
+	// Stats returns the counters since the cache was created.
+	Stats() Stats
+}
+
+// Stats counts how well a cache is doing.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRU is an in-process Cache holding up to a fixed number of entries, each
+// for at most a fixed time. When it's full the least recently used entry is
+// evicted.
+type LRU struct {
+	capacity int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	// generation counts calls to Delete and DeletePrefix.
+	generation uint64
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+}
+
+// NewLRU returns a cache of capacity entries that each expire after ttl.
+// A ttl of zero keeps entries until they are evicted or deleted.
+func NewLRU(capacity int, ttl time.Duration) *LRU {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &LRU{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (c *LRU) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.remove(element)
+		c.misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits++
+	return e.value, true
+}
+
+func (c *LRU) Set(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.set(key, value)
+}
+
+func (c *LRU) SetIfUnchanged(key string, value any, generation uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return false
+	}
+
+	c.set(key, value)
+	return true
+}
+
+func (c *LRU) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// set stores value under key. The caller must hold the lock.
+func (c *LRU) set(key string, value any) {
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.now().Add(c.ttl)
+	}
+
+	if element, ok := c.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.value = value
+		e.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *LRU) DeletePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.order.Len(),
+		Capacity:  c.capacity,
+	}
+}
+
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*entry).key)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLRUGetSet tests that stored values are returned and that hits and
+// misses are counted
+func TestLRUGetSet(t *testing.T) {
+	c := NewLRU(10, 0)
+
+	if _, ok := c.Get("film:1"); ok {
+		t.Fatal("got a value from an empty cache")
+	}
+
+	c.Set("film:1", "Heat")
+	c.Set("film:1", "Heat (1995)")
+
+	value, ok := c.Get("film:1")
+	if !ok || value != "Heat (1995)" {
+		t.Fatalf("got %v, %v; want Heat (1995), true", value, ok)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("got stats %+v, want 1 hit, 1 miss, 1 entry", stats)
+	}
+}
+
+// TestLRUEviction tests that the least recently used entry makes room for a
+// new one
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU(2, 0)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b wasn't evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("got stats %+v, want 1 eviction, 2 entries", stats)
+	}
+}
+
+// TestLRUExpiry tests that entries are dropped once their TTL has passed
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU(10, time.Minute)
+
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+
+	now = now.Add(59 * time.Second)
+	if _, ok := c.Get("a"); !ok {
+		t.Error("a expired early")
+	}
+
+	now = now.Add(time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a didn't expire")
+	}
+
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("got %d entries, want 0", stats.Entries)
+	}
+}
+
+// TestLRUDelete tests that single keys and whole prefixes can be removed
+func TestLRUDelete(t *testing.T) {
+	c := NewLRU(10, 0)
+
+	c.Set("film:1", 1)
+	c.Set("film:2", 2)
+	c.Set("films:page=1", 3)
+	c.Set("films:page=2", 4)
+
+	c.Delete("film:1")
+	c.DeletePrefix("films:")
+
+	for _, key := range []string{"film:1", "films:page=1", "films:page=2"} {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("%s wasn't deleted", key)
+		}
+	}
+
+	if _, ok := c.Get("film:2"); !ok {
+		t.Error("film:2 was deleted")
+	}
+}
+
+// TestLRUSetIfUnchanged tests that values loaded across a delete aren't stored
+func TestLRUSetIfUnchanged(t *testing.T) {
+	c := NewLRU(10, 0)
+
+	generation := c.Generation()
+	if !c.SetIfUnchanged("film:1", 1, generation) {
+		t.Error("value not stored with the current generation")
+	}
+
+	c.Delete("film:2")
+	if c.SetIfUnchanged("film:3", 3, generation) {
+		t.Error("value stored after a delete")
+	}
+
+	generation = c.Generation()
+	c.DeletePrefix("films:")
+	if c.SetIfUnchanged("films:page=1", 4, generation) {
+		t.Error("value stored after a prefix delete")
+	}
+
+	if _, ok := c.Get("film:3"); ok {
+		t.Error("film:3 was stored")
+	}
+}
@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Preference is one entry of an Accept or Accept-Encoding header.
+type Preference struct {
+	Value string
+	Q     float64
+}
+
+// ParseQuality parses a header like "application/cbor, application/json;q=0.5"
+// into its values and qualities, lowercased and without other parameters.
+// Entries with a malformed quality are skipped.
+func ParseQuality(header string) []Preference {
+	var preferences []Preference
+
+	for _, part := range strings.Split(header, ",") {
+		value, params, _ := strings.Cut(part, ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value == "" {
+			continue
+		}
+
+		q, ok := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			name, raw, found := strings.Cut(param, "=")
+			if !found || strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				ok = false
+				break
+			}
+			q = parsed
+		}
+
+		if ok {
+			preferences = append(preferences, Preference{Value: value, Q: q})
+		}
+	}
+
+	return preferences
+}
@@ -0,0 +1,126 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type cborEncoder struct{}
+
+// CBOR encodes values as CBOR (RFC 8949) using its deterministic encoding:
+// the shortest form of every length and integer, and map keys sorted by
+// their encoded bytes.
+var CBOR Encoder = cborEncoder{}
+
+func (cborEncoder) ContentType() string {
+	return "application/cbor"
+}
+
+func (cborEncoder) Encode(v any) ([]byte, error) {
+	value, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendCBOR(nil, value)
+}
+
+const (
+	cborUnsigned = 0
+	cborNegative = 1
+	cborText     = 3
+	cborArray    = 4
+	cborMap      = 5
+)
+
+func appendCBOR(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			if n >= 0 {
+				return appendCBORHead(b, cborUnsigned, uint64(n)), nil
+			}
+			return appendCBORHead(b, cborNegative, uint64(-1-n)), nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(f)), nil
+
+	case string:
+		b = appendCBORHead(b, cborText, uint64(len(v)))
+		return append(b, v...), nil
+
+	case []any:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			b, err = appendCBOR(b, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+
+	case map[string]any:
+		type pair struct {
+			key   []byte
+			value any
+		}
+
+		pairs := make([]pair, 0, len(v))
+		for key, value := range v {
+			encoded, _ := appendCBOR(nil, key)
+			pairs = append(pairs, pair{key: encoded, value: value})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+
+		b = appendCBORHead(b, cborMap, uint64(len(v)))
+		for _, p := range pairs {
+			b = append(b, p.key...)
+
+			var err error
+			b, err = appendCBOR(b, p.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported type %T", v)
+}
+
+// appendCBORHead writes the initial bytes of an item of the given major
+// type, with n as its value or length.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
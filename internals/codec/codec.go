@@ -0,0 +1,113 @@
+// Package codec encodes API responses in the formats clients can ask for
+// with the Accept header. Every format is produced from the value's JSON
+// encoding, so field names, omitted fields and custom marshalling such as a
+// film's "142 mins" runtime are the same whichever format is chosen.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Encoder turns a response value into bytes of one media type.
+type Encoder interface {
+	ContentType() string
+	Encode(v any) ([]byte, error)
+}
+
+// Registry holds the encoders a server offers. The first one is the default,
+// used when the client doesn't say or accepts nothing on offer.
+type Registry struct {
+	encoders []Encoder
+}
+
+func NewRegistry(encoders ...Encoder) *Registry {
+	return &Registry{encoders: encoders}
+}
+
+// Negotiate picks the encoder for an Accept header: the acceptable one with
+// the highest quality, preferring earlier registrations on a tie.
+func (registry *Registry) Negotiate(accept string) Encoder {
+	if accept == "" {
+		return registry.encoders[0]
+	}
+
+	preferences := ParseQuality(accept)
+
+	var best Encoder
+	var bestQ float64
+
+	for _, encoder := range registry.encoders {
+		q := mediaQuality(preferences, encoder.ContentType())
+		if q > bestQ {
+			best, bestQ = encoder, q
+		}
+	}
+
+	if best == nil {
+		return registry.encoders[0]
+	}
+
+	return best
+}
+
+// mediaQuality returns the quality the preferences give contentType. A more
+// specific range overrides a less specific one, so "*/*;q=0.1,
+// application/cbor" prefers CBOR.
+func mediaQuality(preferences []Preference, contentType string) float64 {
+	kind, _, _ := strings.Cut(contentType, "/")
+
+	q, specificity := 0.0, -1
+	for _, preference := range preferences {
+		s := -1
+		switch preference.Value {
+		case contentType:
+			s = 2
+		case kind + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+
+		if s > specificity {
+			q, specificity = preference.Q, s
+		}
+	}
+
+	return q
+}
+
+type jsonEncoder struct{}
+
+// JSON encodes values as JSON, followed by a newline.
+var JSON Encoder = jsonEncoder{}
+
+func (jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (jsonEncoder) Encode(v any) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(js, '\n'), nil
+}
+
+// generic returns v as the value json.Unmarshal would build from its JSON:
+// maps, slices, strings, bools, nil and json.Numbers.
+func generic(v any) (any, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+
+	var out any
+	err = decoder.Decode(&out)
+	return out, err
+}
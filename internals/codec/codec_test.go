@@ -0,0 +1,121 @@
+package codec
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// sample covers every kind of value the encoders handle.
+var sample = map[string]any{
+	"d": 1.5,
+	"a": 1,
+	"b": []any{true, nil, "x"},
+	"c": -1,
+}
+
+// TestEncode tests the bytes each encoder produces against hand-checked
+// encodings
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoder Encoder
+		value   any
+		want    string
+	}{
+		{name: "JSON", encoder: JSON, value: sample, want: hex.EncodeToString([]byte(`{"a":1,"b":[true,null,"x"],"c":-1,"d":1.5}` + "\n"))},
+		{name: "MessagePack map", encoder: MessagePack, value: sample, want: "84a16101a16293c3c0a178a163ffa164cb3ff8000000000000"},
+		{name: "MessagePack int16", encoder: MessagePack, value: 300, want: "d1012c"},
+		{name: "MessagePack int8", encoder: MessagePack, value: -33, want: "d0df"},
+		{name: "MessagePack str8", encoder: MessagePack, value: string(make([]byte, 40)), want: "d928" + hex.EncodeToString(make([]byte, 40))},
+		{name: "CBOR map", encoder: CBOR, value: sample, want: "a4616101616283f5f661786163206164fb3ff8000000000000"},
+		{name: "CBOR uint16", encoder: CBOR, value: 300, want: "19012c"},
+		{name: "CBOR uint8", encoder: CBOR, value: 24, want: "1818"},
+		{name: "CBOR negative", encoder: CBOR, value: -500, want: "3901f3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.encoder.Encode(tt.value)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			if hex.EncodeToString(got) != tt.want {
+				t.Errorf("Encode() = %x, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type runtime int
+
+func (r runtime) MarshalJSON() ([]byte, error) {
+	return []byte(`"142 mins"`), nil
+}
+
+// TestEncodeUsesJSONMarshalling tests that struct tags and custom marshalling
+// carry over to the binary formats
+func TestEncodeUsesJSONMarshalling(t *testing.T) {
+	value := struct {
+		Title   string  `json:"title"`
+		Runtime runtime `json:"runtime"`
+		Hidden  string  `json:"-"`
+	}{Title: "Heat", Runtime: 142, Hidden: "secret"}
+
+	got, err := MessagePack.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// {"runtime": "142 mins", "title": "Heat"}
+	want := "82a772756e74696d65a8313432206d696e73a57469746c65a448656174"
+	if hex.EncodeToString(got) != want {
+		t.Errorf("Encode() = %x, want %s", got, want)
+	}
+}
+
+// TestNegotiate tests that the Accept header picks the best encoder on offer
+// and that JSON is the fallback
+func TestNegotiate(t *testing.T) {
+	registry := NewRegistry(JSON, MessagePack, CBOR)
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: "application/json"},
+		{accept: "*/*", want: "application/json"},
+		{accept: "application/cbor", want: "application/cbor"},
+		{accept: "application/msgpack, application/json;q=0.9", want: "application/msgpack"},
+		{accept: "application/json;q=0.5, application/cbor", want: "application/cbor"},
+		{accept: "*/*;q=0.1, application/cbor", want: "application/cbor"},
+		{accept: "application/*;q=0.2, application/json;q=0", want: "application/msgpack"},
+		{accept: "text/html", want: "application/json"},
+		{accept: "text/html,application/xhtml+xml,*/*;q=0.8", want: "application/json"},
+		{accept: "APPLICATION/CBOR; charset=binary", want: "application/cbor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := registry.Negotiate(tt.accept).ContentType(); got != tt.want {
+				t.Errorf("Negotiate(%q) = %s, want %s", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseQuality tests that values and qualities are read and malformed
+// entries are skipped
+func TestParseQuality(t *testing.T) {
+	got := ParseQuality("br;q=1.0, gzip;q=0.8, deflate;q=bad, , identity; q=0")
+	want := []Preference{{"br", 1}, {"gzip", 0.8}, {"identity", 0}}
+
+	if len(got) != len(want) {
+		t.Fatalf("ParseQuality() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseQuality()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package codec
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+type msgpackEncoder struct{}
+
+// MessagePack encodes values as MessagePack. Map keys are sorted, so equal
+// values always encode to the same bytes.
+var MessagePack Encoder = msgpackEncoder{}
+
+func (msgpackEncoder) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackEncoder) Encode(v any) ([]byte, error) {
+	value, err := generic(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendMsgpack(nil, value)
+}
+
+func appendMsgpack(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, n), nil
+		}
+
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(f)), nil
+
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			b = append(b, 0xa0|byte(n))
+		case n <= math.MaxUint8:
+			b = append(b, 0xd9, byte(n))
+		case n <= math.MaxUint16:
+			b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+		default:
+			b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+		}
+		return append(b, v...), nil
+
+	case []any:
+		b = appendMsgpackLength(b, len(v), 0x90, 0xdc, 0xdd)
+		for _, item := range v {
+			var err error
+			b, err = appendMsgpack(b, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+
+	case map[string]any:
+		b = appendMsgpackLength(b, len(v), 0x80, 0xde, 0xdf)
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			var err error
+			b, err = appendMsgpack(b, key)
+			if err != nil {
+				return nil, err
+			}
+			b, err = appendMsgpack(b, v[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+// appendMsgpackLength writes an array or map header: the fix form for up to
+// 15 entries, then the 16 and 32 bit forms.
+func appendMsgpackLength(b []byte, n int, fix, len16, len32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, len16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(b, len32), uint32(n))
+	}
+}
+
+// appendMsgpackInt writes n in the smallest form that holds it.
+func appendMsgpackInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0 && n < 128:
+		return append(b, byte(n))
+	case n < 0 && n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+	}
+}
@@ -0,0 +1,235 @@
+// Package events streams changes recorded by the database triggers to
+// connected clients. A single Broker per process follows the change_events
+// table and fans each event out to its subscribers, so the database is read
+// once however many clients are listening.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+// Config tunes the broker. Zero fields take the defaults noted below.
+type Config struct {
+	// PollInterval is how often the table is checked if no notification
+	// arrives, as a safety net (default 5s).
+	PollInterval time.Duration
+	// GapTimeout is how long a missing ID is waited for before it's assumed
+	// to belong to a rolled back transaction (default 2s). IDs are handed out
+	// before commit, so a later event can become visible first.
+	GapTimeout time.Duration
+	// Buffer is how many events may queue up for a subscriber before it is
+	// dropped as too slow (default 64).
+	Buffer int
+	// BatchSize caps how many events are read at once (default 500).
+	BatchSize int
+}
+
+// Subscription receives events until it's closed by the subscriber, dropped
+// for falling behind, or the broker stops. C is closed in every case.
+type Subscription struct {
+	C <-chan Event
+	// From is the ID of the last event published before the subscription
+	// started; everything after it arrives on C.
+	From int64
+
+	c      chan Event
+	broker *Broker
+	once   sync.Once
+}
+
+// Close unsubscribes. It's safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.remove(s)
+}
+
+type Broker struct {
+	source Source
+	logger *jsonlog.Logger
+	config Config
+
+	mu      sync.Mutex
+	subs    map[*Subscription]struct{}
+	lastID  int64
+	stopped bool
+
+	gapSince time.Time
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func NewBroker(source Source, logger *jsonlog.Logger, config Config) *Broker {
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+	if config.GapTimeout <= 0 {
+		config.GapTimeout = 2 * time.Second
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 64
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+
+	return &Broker{
+		source: source,
+		logger: logger,
+		config: config,
+		subs:   make(map[*Subscription]struct{}),
+		now:    time.Now,
+	}
+}
+
+// Source returns the broker's event history, for catching up on events from
+// before a subscription's From.
+func (b *Broker) Source() Source {
+	return b.source
+}
+
+// Subscribe starts receiving events. After the broker has stopped it returns
+// a subscription whose channel is already closed.
+func (b *Broker) Subscribe() *Subscription {
+	c := make(chan Event, b.config.Buffer)
+	s := &Subscription{C: c, c: c, broker: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s.From = b.lastID
+
+	if b.stopped {
+		s.once.Do(func() { close(c) })
+		return s
+	}
+
+	b.subs[s] = struct{}{}
+	return s
+}
+
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, s)
+	s.once.Do(func() { close(s.c) })
+}
+
+// Run follows the event table until ctx is cancelled, checking it whenever
+// wake fires or PollInterval passes. On return every subscription is closed.
+func (b *Broker) Run(ctx context.Context, wake <-chan struct{}) error {
+	defer b.stop()
+
+	latest, err := b.source.Latest(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.lastID = latest
+	b.mu.Unlock()
+
+	ticker := time.NewTicker(b.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// While waiting on a gap, look again once it may be skipped rather
+		// than at the next poll.
+		var retry <-chan time.Time
+		if !b.gapSince.IsZero() {
+			retry = time.After(b.config.GapTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-wake:
+			if !ok {
+				// The listener has gone; fall back to polling.
+				wake = nil
+			}
+		case <-ticker.C:
+		case <-retry:
+		}
+
+		err := b.poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			b.logger.PrintError(err, nil)
+		}
+	}
+}
+
+// poll publishes every event after lastID, stopping at a gap in the IDs
+// until either it fills or GapTimeout passes.
+func (b *Broker) poll(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		lastID := b.lastID
+		b.mu.Unlock()
+
+		events, err := b.source.After(ctx, lastID, b.config.BatchSize)
+		if err != nil {
+			return err
+		}
+
+		published := 0
+		for _, event := range events {
+			if event.ID != lastID+1 && !b.skipGap() {
+				break
+			}
+
+			b.gapSince = time.Time{}
+			b.publish(event)
+			lastID = event.ID
+			published++
+		}
+
+		if published < b.config.BatchSize {
+			return nil
+		}
+	}
+}
+
+// skipGap reports whether a gap has been waited on long enough.
+func (b *Broker) skipGap() bool {
+	now := b.now()
+	if b.gapSince.IsZero() {
+		b.gapSince = now
+	}
+
+	return now.Sub(b.gapSince) >= b.config.GapTimeout
+}
+
+func (b *Broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID = event.ID
+
+	for s := range b.subs {
+		select {
+		case s.c <- event:
+		default:
+			// Too slow to keep up; the client can reconnect and resume
+			// from the last event it received.
+			delete(b.subs, s)
+			s.once.Do(func() { close(s.c) })
+		}
+	}
+}
+
+func (b *Broker) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopped = true
+
+	for s := range b.subs {
+		delete(b.subs, s)
+		s.once.Do(func() { close(s.c) })
+	}
+}
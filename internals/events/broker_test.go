@@ -0,0 +1,205 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+func newTestBroker(source Source, config Config) (*Broker, *time.Time) {
+	broker := NewBroker(source, jsonlog.New(bytes.NewBuffer(nil), jsonlog.LevelInfo), config)
+
+	now := time.Now()
+	broker.now = func() time.Time { return now }
+	return broker, &now
+}
+
+// receive reads the IDs of the events waiting on sub without blocking.
+func receive(sub *Subscription) []int64 {
+	var ids []int64
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return ids
+			}
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func equalIDs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestBrokerPoll tests that new events reach every subscriber in order and
+// that a subscription knows where it started
+func TestBrokerPoll(t *testing.T) {
+	source := NewMemorySource()
+	source.Append("film.created", nil, `{"id":1}`)
+
+	broker, _ := newTestBroker(source, Config{BatchSize: 2})
+	broker.lastID = 1
+
+	first := broker.Subscribe()
+	second := broker.Subscribe()
+
+	if first.From != 1 {
+		t.Errorf("got From %d, want 1", first.From)
+	}
+
+	for range 3 {
+		source.Append("film.updated", nil, `{"id":1}`)
+	}
+
+	err := broker.poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sub := range []*Subscription{first, second} {
+		if got := receive(sub); !equalIDs(got, []int64{2, 3, 4}) {
+			t.Errorf("got events %v, want [2 3 4]", got)
+		}
+	}
+
+	if later := broker.Subscribe(); later.From != 4 {
+		t.Errorf("got From %d, want 4", later.From)
+	}
+}
+
+// TestBrokerGap tests that events after a missing ID are held back until the
+// gap fills or has been waited on for GapTimeout
+func TestBrokerGap(t *testing.T) {
+	source := NewMemorySource()
+	broker, now := newTestBroker(source, Config{GapTimeout: 2 * time.Second})
+	sub := broker.Subscribe()
+
+	source.Append("film.created", nil, `{}`)
+	source.Insert(3, "film.created", nil, `{}`)
+
+	broker.poll(context.Background())
+	if got := receive(sub); !equalIDs(got, []int64{1}) {
+		t.Fatalf("got events %v, want [1]", got)
+	}
+
+	*now = now.Add(time.Second)
+	broker.poll(context.Background())
+	if got := receive(sub); len(got) != 0 {
+		t.Fatalf("got events %v before the gap timed out", got)
+	}
+
+	// The transaction holding ID 2 commits.
+	source.Insert(2, "film.created", nil, `{}`)
+	broker.poll(context.Background())
+	if got := receive(sub); !equalIDs(got, []int64{2, 3}) {
+		t.Fatalf("got events %v, want [2 3]", got)
+	}
+
+	// ID 4 is never committed.
+	source.Insert(5, "film.created", nil, `{}`)
+	broker.poll(context.Background())
+	if got := receive(sub); len(got) != 0 {
+		t.Fatalf("got events %v before the gap timed out", got)
+	}
+
+	*now = now.Add(2 * time.Second)
+	broker.poll(context.Background())
+	if got := receive(sub); !equalIDs(got, []int64{5}) {
+		t.Fatalf("got events %v, want [5]", got)
+	}
+}
+
+// TestBrokerSlowSubscriber tests that a subscriber whose buffer is full is
+// dropped rather than holding up the others
+func TestBrokerSlowSubscriber(t *testing.T) {
+	source := NewMemorySource()
+	broker, _ := newTestBroker(source, Config{Buffer: 1})
+
+	slow := broker.Subscribe()
+	source.Append("film.created", nil, `{}`)
+	source.Append("film.created", nil, `{}`)
+
+	broker.poll(context.Background())
+
+	if got := receive(slow); !equalIDs(got, []int64{1}) {
+		t.Errorf("got events %v, want [1]", got)
+	}
+
+	if _, ok := <-slow.C; ok {
+		t.Error("slow subscription is still open")
+	}
+
+	slow.Close()
+}
+
+// TestBrokerRun tests that Run starts after the newest stored event, wakes
+// on notification and closes subscriptions when it stops
+func TestBrokerRun(t *testing.T) {
+	source := NewMemorySource()
+	source.Append("film.created", nil, `{}`)
+
+	broker, _ := newTestBroker(source, Config{PollInterval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wake := make(chan struct{})
+	done := make(chan error)
+
+	sub := broker.Subscribe()
+	go func() { done <- broker.Run(ctx, wake) }()
+
+	// Once Run takes a wake-up it has read where to start from.
+	wake <- struct{}{}
+	source.Append("film.updated", nil, `{}`)
+	wake <- struct{}{}
+
+	select {
+	case event := <-sub.C:
+		if event.ID != 2 {
+			t.Errorf("got event %d, want 2", event.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event after wake")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := <-sub.C; ok {
+		t.Error("subscription is still open after Run returned")
+	}
+
+	if _, ok := <-broker.Subscribe().C; ok {
+		t.Error("subscription after stop is open")
+	}
+}
+
+// TestVisibleTo tests that only an event's owner sees private events
+func TestVisibleTo(t *testing.T) {
+	owner := int64(7)
+
+	if !(Event{}).VisibleTo(1) {
+		t.Error("public event is hidden")
+	}
+	if !(Event{UserID: &owner}).VisibleTo(7) {
+		t.Error("private event is hidden from its owner")
+	}
+	if (Event{UserID: &owner}).VisibleTo(8) {
+		t.Error("private event is visible to another user")
+	}
+}
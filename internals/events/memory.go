@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemorySource is a Source kept in memory, for tests and for running without
+// the change triggers.
+type MemorySource struct {
+	mu     sync.Mutex
+	nextID int64
+	events []Event
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{nextID: 1}
+}
+
+// Append stores an event with the next ID and returns it.
+func (source *MemorySource) Append(name string, userID *int64, data string) Event {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	event := source.add(source.nextID, name, userID, data)
+	source.nextID++
+	return event
+}
+
+// Insert stores an event with a chosen ID, which may leave a gap or fill one.
+// IDs after it are handed out from there.
+func (source *MemorySource) Insert(id int64, name string, userID *int64, data string) Event {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	event := source.add(id, name, userID, data)
+	if id >= source.nextID {
+		source.nextID = id + 1
+	}
+	return event
+}
+
+func (source *MemorySource) add(id int64, name string, userID *int64, data string) Event {
+	event := Event{ID: id, CreatedAt: time.Now(), Name: name, UserID: userID, Data: []byte(data)}
+
+	source.events = append(source.events, event)
+	sort.Slice(source.events, func(i, j int) bool { return source.events[i].ID < source.events[j].ID })
+	return event
+}
+
+func (source *MemorySource) After(ctx context.Context, id int64, limit int) ([]Event, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	var events []Event
+	for _, event := range source.events {
+		if event.ID > id && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (source *MemorySource) Oldest(ctx context.Context) (int64, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	if len(source.events) == 0 {
+		return source.nextID, nil
+	}
+
+	return source.events[0].ID, nil
+}
+
+func (source *MemorySource) Latest(ctx context.Context) (int64, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	if len(source.events) == 0 {
+		return 0, nil
+	}
+
+	return source.events[len(source.events)-1].ID, nil
+}
+
+func (source *MemorySource) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+
+	kept := source.events[:0]
+	for _, event := range source.events {
+		if !event.CreatedAt.Before(cutoff) {
+			kept = append(kept, event)
+		}
+	}
+
+	pruned := int64(len(source.events) - len(kept))
+	source.events = kept
+	return pruned, nil
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres notification channel the change triggers use.
+const Channel = "change_events"
+
+// Event is one change to the catalogue or a watchlist.
+type Event struct {
+	ID        int64
+	CreatedAt time.Time
+	Name      string
+	// UserID is set on events only their owner may see.
+	UserID *int64
+	Data   json.RawMessage
+}
+
+// VisibleTo reports whether the user with userID may receive the event.
+func (e Event) VisibleTo(userID int64) bool {
+	return e.UserID == nil || *e.UserID == userID
+}
+
+// Source reads the stored event history.
+type Source interface {
+	// After returns up to limit events with IDs greater than id, oldest first.
+	After(ctx context.Context, id int64, limit int) ([]Event, error)
+	// Oldest returns the ID of the oldest event still stored, or the ID the
+	// next event will get if there are none.
+	Oldest(ctx context.Context) (int64, error)
+	// Latest returns the ID of the newest stored event, or 0.
+	Latest(ctx context.Context) (int64, error)
+	// Prune deletes events created before cutoff and returns how many went.
+	Prune(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// PostgresSource reads the change_events table filled by the film and
+// watchlist triggers.
+type PostgresSource struct {
+	DB *sql.DB
+}
+
+func NewPostgresSource(db *sql.DB) *PostgresSource {
+	return &PostgresSource{DB: db}
+}
+
+func (source *PostgresSource) After(ctx context.Context, id int64, limit int) ([]Event, error) {
+	query := `
+		SELECT id, created_at, event, user_id, payload
+		FROM change_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`
+
+	rows, err := source.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+
+	for rows.Next() {
+		var event Event
+		var data []byte
+
+		err := rows.Scan(&event.ID, &event.CreatedAt, &event.Name, &event.UserID, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		event.Data = data
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (source *PostgresSource) Oldest(ctx context.Context) (int64, error) {
+	query := `
+		SELECT COALESCE(
+			(SELECT MIN(id) FROM change_events),
+			(SELECT CASE WHEN is_called THEN last_value + 1 ELSE last_value END FROM change_events_id_seq)
+		)
+	`
+
+	var id int64
+	err := source.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, err
+}
+
+func (source *PostgresSource) Latest(ctx context.Context) (int64, error) {
+	var id int64
+	err := source.DB.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM change_events`).Scan(&id)
+	return id, err
+}
+
+func (source *PostgresSource) Prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := source.DB.ExecContext(ctx, `DELETE FROM change_events WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Listen subscribes to Channel on a dedicated connection and signals on the
+// returned channel whenever there may be new events: on every notification
+// and after the connection is re-established, since notifications sent while
+// it was down are lost. Call the returned function to stop listening.
+func Listen(dsn string, logger *jsonlog.Logger) (<-chan struct{}, func() error, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.PrintError(err, map[string]string{"channel": Channel})
+		}
+	})
+
+	err := listener.Listen(Channel)
+	if err != nil {
+		listener.Close()
+		return nil, nil, err
+	}
+
+	wake := make(chan struct{}, 1)
+
+	go func() {
+		defer close(wake)
+
+		for range listener.Notify {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return wake, listener.Close, nil
+}
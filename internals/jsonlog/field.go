@@ -0,0 +1,56 @@
+package jsonlog
+
+import (
+	"time"
+)
+
+// Field is a typed property of a log line.
+type Field struct {
+	Key   string
+	Value any
+	stack bool
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Float64(key string, value float64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Duration logs value in its usual form, such as "1.5s".
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: value.String()}
+}
+
+func Time(key string, value time.Time) Field {
+	return Field{Key: key, Value: value.Format(time.RFC3339Nano)}
+}
+
+// Err logs err's message under "error".
+func Err(err error) Field {
+	return Field{Key: "error", Value: err.Error()}
+}
+
+// Any logs value as encoding/json would marshal it.
+func Any(key string, value any) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Stack adds the calling goroutine's stack trace to the line.
+func Stack() Field {
+	return Field{stack: true}
+}
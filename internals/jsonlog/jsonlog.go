@@ -2,17 +2,22 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Level int8
 
 const (
-	LevelInfo Level = iota
+	LevelDebug Level = iota - 1
+	LevelInfo
+	LevelWarn
 	LevelError
 	LevelFatal
 	LevelOff
@@ -20,67 +25,177 @@ const (
 
 func (l Level) String() string {
 	switch l {
+	case LevelDebug:
+		return "DEBUG"
+
 	case LevelInfo:
 		return "INFO"
 
+	case LevelWarn:
+		return "WARN"
+
 	case LevelError:
 		return "ERROR"
 
 	case LevelFatal:
 		return "FATAL"
 
+	case LevelOff:
+		return "OFF"
+
 	default:
 		return ""
 	}
 
 }
 
+// ParseLevel returns the level with the given name, ignoring case.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToUpper(name) {
+	case "DEBUG":
+		return LevelDebug, nil
+	case "INFO":
+		return LevelInfo, nil
+	case "WARN", "WARNING":
+		return LevelWarn, nil
+	case "ERROR":
+		return LevelError, nil
+	case "FATAL":
+		return LevelFatal, nil
+	case "OFF":
+		return LevelOff, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+}
+
+// Logger writes one JSON object per line. Loggers made from another with
+// With or WithSampling share its output and level, so changing the level of
+// any of them changes it for all.
 type Logger struct {
+	core    *core
+	fields  []Field
+	sampler *sampler
+}
+
+type core struct {
 	out      io.Writer
-	minLevel Level
+	minLevel atomic.Int32
 	mu       sync.Mutex
 }
 
 func New(out io.Writer, minLevel Level) *Logger {
-	return &Logger{
-		out:      out,
-		minLevel: minLevel,
-	}
+	logger := &Logger{core: &core{out: out}}
+	logger.SetLevel(minLevel)
+	return logger
+}
+
+// Level returns the lowest level that is written.
+func (logger *Logger) Level() Level {
+	return Level(logger.core.minLevel.Load())
+}
+
+// SetLevel changes the lowest level that is written, while the program runs.
+func (logger *Logger) SetLevel(level Level) {
+	logger.core.minLevel.Store(int32(level))
+}
+
+// Enabled reports whether lines at level are written.
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.Level()
+}
+
+// With returns a logger that adds fields to every line it writes.
+func (logger *Logger) With(fields ...Field) *Logger {
+	child := *logger
+	child.fields = append(append([]Field(nil), logger.fields...), fields...)
+	return &child
+}
+
+// WithSampling returns a logger for high-volume messages. Within each tick
+// it writes the first lines with a given level and message, then only every
+// thereafter-th one (none if thereafter is 0). Errors are never sampled.
+func (logger *Logger) WithSampling(first, thereafter int, tick time.Duration) *Logger {
+	child := *logger
+	child.sampler = newSampler(first, thereafter, tick)
+	return &child
+}
+
+func (logger *Logger) Debug(message string, fields ...Field) {
+	logger.print(LevelDebug, message, fields)
+}
+
+func (logger *Logger) Info(message string, fields ...Field) {
+	logger.print(LevelInfo, message, fields)
+}
+
+func (logger *Logger) Warn(message string, fields ...Field) {
+	logger.print(LevelWarn, message, fields)
+}
+
+func (logger *Logger) Error(err error, fields ...Field) {
+	logger.print(LevelError, err.Error(), fields)
+}
+
+func (logger *Logger) Fatal(err error, fields ...Field) {
+	logger.print(LevelFatal, err.Error(), fields)
+	os.Exit(1)
 }
 
 func (logger *Logger) PrintInfo(message string, properties map[string]string) {
-	logger.print(LevelInfo, message, properties)
+	logger.print(LevelInfo, message, propertyFields(properties))
 }
 
 func (logger *Logger) PrintError(err error, properties map[string]string) {
-	logger.print(LevelError, err.Error(), properties)
+	logger.print(LevelError, err.Error(), propertyFields(properties))
 }
 
 func (logger *Logger) PrintFatal(err error, properties map[string]string) {
-	logger.print(LevelFatal, err.Error(), properties)
+	logger.print(LevelFatal, err.Error(), propertyFields(properties))
 	os.Exit(1)
 }
 
-func (logger *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < logger.minLevel {
+func propertyFields(properties map[string]string) []Field {
+	fields := make([]Field, 0, len(properties))
+	for key, value := range properties {
+		fields = append(fields, String(key, value))
+	}
+	return fields
+}
+
+func (logger *Logger) print(level Level, message string, fields []Field) (int, error) {
+	if !logger.Enabled(level) {
+		return 0, nil
+	}
+
+	if logger.sampler != nil && level < LevelError && !logger.sampler.allow(level, message) {
 		return 0, nil
 	}
 
 	aux := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
+		Level      string         `json:"level"`
+		Time       string         `json:"time"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties,omitempty"`
+		Trace      string         `json:"trace,omitempty"`
 	}{
-		Level:      level.String(),
-		Time:       time.Now().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+		Level:   level.String(),
+		Time:    time.Now().Format(time.RFC3339),
+		Message: message,
 	}
 
-	if level >= LevelError {
-		aux.Trace = string(debug.Stack())
+	for _, list := range [][]Field{logger.fields, fields} {
+		for _, field := range list {
+			if field.stack {
+				aux.Trace = string(debug.Stack())
+				continue
+			}
+
+			if aux.Properties == nil {
+				aux.Properties = make(map[string]any)
+			}
+			aux.Properties[field.Key] = field.Value
+		}
 	}
 
 	var line []byte
@@ -89,13 +204,15 @@ func (logger *Logger) print(level Level, message string, properties map[string]s
 		line = []byte(LevelError.String() + ": unable to marshal log message:" + err.Error())
 	}
 
-	logger.mu.Lock()
-	defer logger.mu.Unlock()
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
 
-	return logger.out.Write(append(line, '\n'))
+	return logger.core.out.Write(append(line, '\n'))
 
 }
 
+// Write logs message as an error, so a Logger can back a log.Logger such as
+// http.Server's ErrorLog.
 func (logger *Logger) Write(message []byte) (n int, err error) {
-	return logger.print(LevelError, string(message), nil)
+	return logger.print(LevelError, strings.TrimSuffix(string(message), "\n"), nil)
 }
@@ -15,11 +15,21 @@ func TestLevelString(t *testing.T) {
 		level Level
 		want  string
 	}{
+		{
+			name:  "Debug level",
+			level: LevelDebug,
+			want:  "DEBUG",
+		},
 		{
 			name:  "Info level",
 			level: LevelInfo,
 			want:  "INFO",
 		},
+		{
+			name:  "Warn level",
+			level: LevelWarn,
+			want:  "WARN",
+		},
 		{
 			name:  "Error level",
 			level: LevelError,
@@ -55,11 +65,11 @@ func TestNew(t *testing.T) {
 	logger := New(buffer, LevelInfo)
 
 	// Check that the logger was created with the correct properties
-	if logger.out != buffer {
-		t.Errorf("New() logger.out = %v, want %v", logger.out, buffer)
+	if logger.core.out != buffer {
+		t.Errorf("New() logger.core.out = %v, want %v", logger.core.out, buffer)
 	}
-	if logger.minLevel != LevelInfo {
-		t.Errorf("New() logger.minLevel = %v, want %v", logger.minLevel, LevelInfo)
+	if logger.Level() != LevelInfo {
+		t.Errorf("New() logger.Level() = %v, want %v", logger.Level(), LevelInfo)
 	}
 }
 
@@ -139,9 +149,9 @@ func TestPrintError(t *testing.T) {
 		t.Errorf("PrintError() log property = %v, want %v", value, "value")
 	}
 
-	// Check that there's a trace
-	if trace, ok := log["trace"]; !ok || trace == "" {
-		t.Errorf("PrintError() log should have a trace")
+	// Check that there's no trace, since none was asked for
+	if _, ok := log["trace"]; ok {
+		t.Errorf("PrintError() log should not have a trace")
 	}
 }
 
@@ -180,9 +190,9 @@ func TestWrite(t *testing.T) {
 		t.Errorf("Write() log message = %v, want %v", message, "test message")
 	}
 
-	// Check that there's a trace
-	if trace, ok := log["trace"]; !ok || trace == "" {
-		t.Errorf("Write() log should have a trace")
+	// Check that there's no trace
+	if _, ok := log["trace"]; ok {
+		t.Errorf("Write() log should not have a trace")
 	}
 }
 
@@ -213,3 +223,77 @@ func TestLogLevelFiltering(t *testing.T) {
 		t.Errorf("PrintError() log should contain ERROR level")
 	}
 }
+
+// TestParseLevel tests that level names parse regardless of case
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError, LevelFatal, LevelOff} {
+		got, err := ParseLevel(strings.ToLower(level.String()))
+		if err != nil || got != level {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", strings.ToLower(level.String()), got, err, level)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(\"verbose\") returned no error")
+	}
+}
+
+// TestFields tests that typed fields keep their JSON types and that child
+// loggers add their bound fields to every line
+func TestFields(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	logger := New(buffer, LevelInfo).With(String("request_id", "abc"))
+
+	logger.Info("request completed", Int("status", 200), Bool("cached", true), Float64("ratio", 0.5))
+
+	var log struct {
+		Properties map[string]any `json:"properties"`
+		Trace      string         `json:"trace"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{"request_id": "abc", "status": float64(200), "cached": true, "ratio": 0.5}
+	for key, value := range want {
+		if log.Properties[key] != value {
+			t.Errorf("property %s = %#v, want %#v", key, log.Properties[key], value)
+		}
+	}
+	if log.Trace != "" {
+		t.Error("line has a trace that wasn't asked for")
+	}
+
+	buffer.Reset()
+	logger.Error(errors.New("boom"), Stack())
+	if !strings.Contains(buffer.String(), `"trace":"goroutine`) {
+		t.Errorf("Stack() didn't add a trace: %s", buffer.String())
+	}
+}
+
+// TestSetLevel tests that the level can be changed at runtime and is shared
+// with child loggers
+func TestSetLevel(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	logger := New(buffer, LevelInfo)
+	child := logger.With(String("component", "test"))
+
+	child.Debug("hidden")
+	if buffer.Len() != 0 {
+		t.Fatalf("debug line written at INFO: %s", buffer.String())
+	}
+
+	logger.SetLevel(LevelDebug)
+	child.Debug("shown")
+	if !strings.Contains(buffer.String(), `"level":"DEBUG"`) {
+		t.Errorf("debug line not written after SetLevel: %q", buffer.String())
+	}
+
+	buffer.Reset()
+	child.SetLevel(LevelWarn)
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if strings.Contains(buffer.String(), "hidden") || !strings.Contains(buffer.String(), `"level":"WARN"`) {
+		t.Errorf("got %q, want only the warning", buffer.String())
+	}
+}
@@ -0,0 +1,65 @@
+package jsonlog
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSampledMessages bounds how many distinct messages a sampler tracks.
+// Messages are meant to be constant, so this is only reached if one isn't.
+const maxSampledMessages = 1024
+
+type sampler struct {
+	first      int
+	thereafter int
+	tick       time.Duration
+	now        func() time.Time
+
+	mu     sync.Mutex
+	counts map[sampleKey]*sampleCount
+}
+
+type sampleKey struct {
+	level   Level
+	message string
+}
+
+type sampleCount struct {
+	resetAt time.Time
+	n       int
+}
+
+func newSampler(first, thereafter int, tick time.Duration) *sampler {
+	return &sampler{
+		first:      first,
+		thereafter: thereafter,
+		tick:       tick,
+		now:        time.Now,
+		counts:     make(map[sampleKey]*sampleCount),
+	}
+}
+
+// allow reports whether a line with level and message should be written.
+func (s *sampler) allow(level Level, message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	key := sampleKey{level: level, message: message}
+
+	count, ok := s.counts[key]
+	if !ok || !now.Before(count.resetAt) {
+		if !ok && len(s.counts) >= maxSampledMessages {
+			clear(s.counts)
+		}
+		count = &sampleCount{resetAt: now.Add(s.tick)}
+		s.counts[key] = count
+	}
+
+	count.n++
+	if count.n <= s.first {
+		return true
+	}
+
+	return s.thereafter > 0 && (count.n-s.first)%s.thereafter == 0
+}
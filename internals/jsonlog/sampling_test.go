@@ -0,0 +1,56 @@
+package jsonlog
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSampler tests that a message is written the first few times in a tick,
+// then only every so often, and starts again with the next tick
+func TestSampler(t *testing.T) {
+	s := newSampler(2, 3, time.Second)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	var got []bool
+	for i := 0; i < 8; i++ {
+		got = append(got, s.allow(LevelInfo, "request completed"))
+	}
+
+	want := []bool{true, true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("allow() calls = %v, want %v", got, want)
+		}
+	}
+
+	if !s.allow(LevelInfo, "something else") {
+		t.Error("a different message was sampled against the first")
+	}
+
+	now = now.Add(time.Second)
+	if !s.allow(LevelInfo, "request completed") {
+		t.Error("message still sampled in the next tick")
+	}
+}
+
+// TestWithSampling tests that errors get through a sampled logger
+func TestWithSampling(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	logger := New(buffer, LevelInfo).WithSampling(1, 0, time.Hour)
+
+	logger.Info("busy")
+	logger.Info("busy")
+	logger.Error(errors.New("failed"))
+	logger.Error(errors.New("failed"))
+
+	if got := strings.Count(buffer.String(), "busy"); got != 1 {
+		t.Errorf("got %d sampled lines, want 1", got)
+	}
+	if got := strings.Count(buffer.String(), "failed"); got != 2 {
+		t.Errorf("got %d error lines, want 2", got)
+	}
+}
@@ -0,0 +1,97 @@
+package jsonlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns an slog.Handler that writes through logger, so libraries
+// that log with log/slog share its output, format and level.
+func (logger *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: logger}
+}
+
+type slogHandler struct {
+	logger *Logger
+	prefix string
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.Enabled(fromSlogLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make([]Field, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		fields = appendAttr(fields, h.prefix, attr)
+		return true
+	})
+
+	h.logger.print(fromSlogLevel(record.Level), record.Message, fields)
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var fields []Field
+	for _, attr := range attrs {
+		fields = appendAttr(fields, h.prefix, attr)
+	}
+
+	return &slogHandler{logger: h.logger.With(fields...), prefix: h.prefix}
+}
+
+// WithGroup qualifies later attributes' keys with name, as in "name.key".
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return &slogHandler{logger: h.logger, prefix: h.prefix + name + "."}
+}
+
+func appendAttr(fields []Field, prefix string, attr slog.Attr) []Field {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return fields
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindGroup:
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			fields = appendAttr(fields, groupPrefix, member)
+		}
+		return fields
+
+	case slog.KindDuration:
+		return append(fields, Duration(prefix+attr.Key, attr.Value.Duration()))
+
+	case slog.KindTime:
+		return append(fields, Time(prefix+attr.Key, attr.Value.Time()))
+	}
+
+	value := attr.Value.Any()
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	return append(fields, Any(prefix+attr.Key, value))
+}
+
+// fromSlogLevel maps an slog level to the jsonlog level at or below it.
+// Nothing logged through slog is fatal.
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
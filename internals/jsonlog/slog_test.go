@@ -0,0 +1,55 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// TestHandler tests that slog records are written as jsonlog lines, with
+// groups flattened into dotted keys and the logger's level respected
+func TestHandler(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	logger := slog.New(New(buffer, LevelInfo).Handler())
+
+	logger.Debug("hidden")
+	if buffer.Len() != 0 {
+		t.Fatalf("debug record written at INFO: %s", buffer.String())
+	}
+
+	logger.With("component", "oidc").WithGroup("http").Warn("slow response",
+		"status", 200,
+		"elapsed", 1500*time.Millisecond,
+		slog.Group("request", "method", "GET"),
+		"err", errors.New("timeout"),
+	)
+
+	var log struct {
+		Level      string         `json:"level"`
+		Message    string         `json:"message"`
+		Properties map[string]any `json:"properties"`
+	}
+	if err := json.Unmarshal(buffer.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Level != "WARN" || log.Message != "slow response" {
+		t.Errorf("got %s %q, want WARN \"slow response\"", log.Level, log.Message)
+	}
+
+	want := map[string]any{
+		"component":           "oidc",
+		"http.status":         float64(200),
+		"http.elapsed":        "1.5s",
+		"http.request.method": "GET",
+		"http.err":            "timeout",
+	}
+	for key, value := range want {
+		if log.Properties[key] != value {
+			t.Errorf("property %s = %#v, want %#v", key, log.Properties[key], value)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds, suited to request
+// latencies from a millisecond to ten seconds.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu        sync.Mutex
+	metrics   []metric
+	callbacks []func()
+}
+
+type metric interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) add(m metric) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	for _, existing := range registry.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: " + m.name() + " registered twice")
+		}
+	}
+
+	registry.metrics = append(registry.metrics, m)
+}
+
+// OnCollect registers fn to run before each scrape, to refresh values that
+// are expensive to read once per metric, such as runtime.MemStats.
+func (registry *Registry) OnCollect(fn func()) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.callbacks = append(registry.callbacks, fn)
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (registry *Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.mu.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	callbacks := append([]func(){}, registry.callbacks...)
+	registry.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// Handler serves the registry to a Prometheus scraper.
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.WriteTo(w)
+	})
+}
+
+// Counter registers a counter with the given label names.
+func (registry *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{family: newFamily[*Counter](name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	registry.add(vec)
+	return vec
+}
+
+// Gauge registers a gauge with the given label names.
+func (registry *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	vec := &GaugeVec{family: newFamily[*Gauge](name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	registry.add(vec)
+	return vec
+}
+
+// Histogram registers a histogram with the given bucket upper bounds, which
+// must be sorted, and label names.
+func (registry *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	vec := &HistogramVec{family: newFamily[*Histogram](name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{upper: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	registry.add(vec)
+	return vec
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at each scrape.
+func (registry *Registry) GaugeFunc(name, help string, fn func() float64) {
+	registry.add(&funcMetric{metricName: name, help: help, kind: "gauge", fn: fn})
+}
+
+// CounterFunc registers a counter whose value is read from fn at each
+// scrape, for totals kept elsewhere.
+func (registry *Registry) CounterFunc(name, help string, fn func() float64) {
+	registry.add(&funcMetric{metricName: name, help: help, kind: "counter", fn: fn})
+}
+
+// family is a metric's series, one for each combination of label values.
+type family[S series] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	create     func() S
+
+	mu     sync.RWMutex
+	series map[string]S
+	values map[string][]string
+}
+
+type series interface {
+	write(w *bufio.Writer, name, labels string)
+}
+
+func newFamily[S series](name, help, kind string, labels []string, create func() S) family[S] {
+	return family[S]{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		create:     create,
+		series:     make(map[string]S),
+		values:     make(map[string][]string),
+	}
+}
+
+func (f *family[S]) name() string {
+	return f.metricName
+}
+
+func (f *family[S]) with(values []string) S {
+	if len(values) != len(f.labels) {
+		panic("metrics: " + f.metricName + " takes " + strconv.Itoa(len(f.labels)) + " label values")
+	}
+
+	key := strings.Join(values, "\xff")
+
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+
+	s = f.create()
+	f.series[key] = s
+	f.values[key] = append([]string(nil), values...)
+	return s
+}
+
+func (f *family[S]) write(w *bufio.Writer) {
+	writeHeader(w, f.metricName, f.help, f.kind)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		f.series[key].write(w, f.metricName, formatLabels(f.labels, f.values[key]))
+	}
+}
+
+type CounterVec struct {
+	family[*Counter]
+}
+
+// With returns the counter for the given label values, in the order the
+// label names were registered.
+func (vec *CounterVec) With(values ...string) *Counter {
+	return vec.with(values)
+}
+
+// Counter only goes up.
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	addFloat(&c.bits, delta)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+func (c *Counter) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+type GaugeVec struct {
+	family[*Gauge]
+}
+
+// With returns the gauge for the given label values.
+func (vec *GaugeVec) With(values ...string) *Gauge {
+	return vec.with(values)
+}
+
+// Gauge goes up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Inc() {
+	addFloat(&g.bits, 1)
+}
+
+func (g *Gauge) Dec() {
+	addFloat(&g.bits, -1)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) write(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+type HistogramVec struct {
+	family[*Histogram]
+}
+
+// With returns the histogram for the given label values.
+func (vec *HistogramVec) With(values ...string) *Histogram {
+	return vec.with(values)
+}
+
+// Histogram counts observations into buckets by value.
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.upper {
+		if v <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+
+	h.count++
+	h.sum += v
+}
+
+func (h *Histogram) write(w *bufio.Writer, name, labels string) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, upper := range h.upper {
+		cumulative += counts[i]
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(upper)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+type funcMetric struct {
+	metricName string
+	help       string
+	kind       string
+	fn         func() float64
+}
+
+func (m *funcMetric) name() string {
+	return m.metricName
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, m.metricName, m.help, m.kind)
+	writeSample(w, m.metricName, "", m.fn())
+}
+
+func addFloat(bits *atomic.Uint64, delta float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	w.WriteString("# HELP " + name + " " + helpEscaper.Replace(help) + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+	return labels + "," + extra
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestRegistryWriteTo tests the text exposition of each kind of metric
+func TestRegistryWriteTo(t *testing.T) {
+	registry := NewRegistry()
+
+	requests := registry.Counter("requests_total", "Requests served.", "route", "code")
+	requests.With("/b", "200").Inc()
+	requests.With("/a", "404").Add(2)
+	requests.With("/b", "200").Inc()
+
+	inFlight := registry.Gauge("in_flight", "Requests in progress.")
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+
+	duration := registry.Histogram("duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	duration.With("/a").Observe(0.05)
+	duration.With("/a").Observe(0.5)
+	duration.With("/a").Observe(5)
+
+	collected := 0
+	registry.OnCollect(func() { collected++ })
+	registry.GaugeFunc("collected", "Scrapes so far.", func() float64 { return float64(collected) })
+
+	registry.Gauge("info", "Build information.", "version").With(`1.0 "beta"`).Set(1)
+
+	var buf bytes.Buffer
+	n, err := registry.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",code="404"} 2
+requests_total{route="/b",code="200"} 2
+# HELP in_flight Requests in progress.
+# TYPE in_flight gauge
+in_flight 1
+# HELP duration_seconds Request latency.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 5.55
+duration_seconds_count{route="/a"} 3
+# HELP collected Scrapes so far.
+# TYPE collected gauge
+collected 1
+# HELP info Build information.
+# TYPE info gauge
+info{version="1.0 \"beta\""} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestRegistryDuplicate tests that a metric name can only be registered once
+func TestRegistryDuplicate(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("requests_total", "Requests served.")
+
+	defer func() {
+		if err := recover(); err == nil || !strings.Contains(err.(string), "requests_total") {
+			t.Errorf("got panic %v, want one naming the metric", err)
+		}
+	}()
+
+	registry.Gauge("requests_total", "Requests served.")
+}
@@ -0,0 +1,296 @@
+// Package migrate applies the SQL migrations embedded in the binary. It keeps
+// the schema_migrations table the golang-migrate tool uses, so databases
+// migrated with either can be handed over to the other. Every change is made
+// while holding a lock on the store, so replicas starting at the same time
+// don't run the same migration twice.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+var (
+	ErrDirty          = errors.New("migrate: schema is dirty")
+	ErrUnknownVersion = errors.New("migrate: no migration with this version")
+	ErrNoDown         = errors.New("migrate: migration can't be reverted")
+)
+
+// Migration is one numbered change to the schema.
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"`
+	Down    string `json:"-"`
+}
+
+// Status describes how far the schema is migrated.
+type Status struct {
+	// Version is the last migration applied, or 0 if there is none.
+	Version int64 `json:"version"`
+	// Dirty means the migration at Version failed part way through, leaving
+	// the schema in an unknown state until it's repaired and forced.
+	Dirty bool `json:"dirty"`
+	// Latest is the newest migration known to this build.
+	Latest  int64       `json:"latest"`
+	Pending []Migration `json:"pending"`
+}
+
+// Store keeps the schema version and runs migrations (normally Postgres).
+type Store interface {
+	// Version returns the recorded version, 0 if nothing was ever applied.
+	Version(ctx context.Context) (version int64, dirty bool, err error)
+	// Lock waits until no other process is migrating and returns a session
+	// that holds the lock until it's unlocked.
+	Lock(ctx context.Context) (Session, error)
+}
+
+// Session makes changes while holding the store's lock.
+type Session interface {
+	Version(ctx context.Context) (version int64, dirty bool, err error)
+	SetVersion(ctx context.Context, version int64, dirty bool) error
+	Exec(ctx context.Context, query string) error
+	Unlock(ctx context.Context) error
+}
+
+var filenameRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Load reads migrations named like 000001_create_films.up.sql and
+// 000001_create_films.down.sql from the root of fsys, in version order.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		matches := filenameRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %s and %s", version, migration.Name, matches[2])
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migrate: migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return migrations, nil
+}
+
+// Migrator moves a store between the versions of a set of migrations.
+type Migrator struct {
+	store      Store
+	migrations []Migration
+}
+
+func New(store Store, migrations []Migration) *Migrator {
+	return &Migrator{store: store, migrations: migrations}
+}
+
+// Latest returns the version of the newest migration, 0 if there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status reports the current version and the migrations yet to be applied.
+// It doesn't wait for the lock, so it can be checked while a migration runs.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	version, dirty, err := m.store.Version(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+
+	return Status{
+		Version: version,
+		Dirty:   dirty,
+		Latest:  m.Latest(),
+		Pending: m.pending(version),
+	}, nil
+}
+
+func (m *Migrator) pending(version int64) []Migration {
+	pending := []Migration{}
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending
+}
+
+// Up applies every pending migration and returns those it applied. A
+// failed migration leaves the schema dirty at its version.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied := []Migration{}
+
+	err := m.withLock(ctx, func(session Session) error {
+		version, dirty, err := session.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		for _, migration := range m.pending(version) {
+			err := run(ctx, session, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: applying %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts up to steps migrations, newest first, and returns those it
+// reverted. A failed migration leaves the schema dirty at the version below
+// it.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	reverted := []Migration{}
+
+	err := m.withLock(ctx, func(session Session) error {
+		version, dirty, err := session.Version(ctx)
+		if err != nil {
+			return err
+		}
+
+		if dirty {
+			return fmt.Errorf("%w at version %d", ErrDirty, version)
+		}
+
+		for range steps {
+			if version == 0 {
+				return nil
+			}
+
+			i := slices.IndexFunc(m.migrations, func(migration Migration) bool {
+				return migration.Version == version
+			})
+			if i < 0 {
+				return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+			}
+
+			migration := m.migrations[i]
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %d", ErrNoDown, version)
+			}
+
+			var previous int64
+			if i > 0 {
+				previous = m.migrations[i-1].Version
+			}
+
+			err := run(ctx, session, migration.Down, previous)
+			if err != nil {
+				return fmt.Errorf("migrate: reverting %d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			reverted = append(reverted, migration)
+			version = previous
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Force records version as applied and clean without running anything. It's
+// how a dirty schema is marked repaired once it has been fixed by hand.
+func (m *Migrator) Force(ctx context.Context, version int64) error {
+	known := version == 0 || slices.ContainsFunc(m.migrations, func(migration Migration) bool {
+		return migration.Version == version
+	})
+	if !known {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(ctx, func(session Session) error {
+		return session.SetVersion(ctx, version, false)
+	})
+}
+
+// run executes query, recording target as dirty until it succeeds.
+func run(ctx context.Context, session Session, query string, target int64) error {
+	err := session.SetVersion(ctx, target, true)
+	if err != nil {
+		return err
+	}
+
+	err = session.Exec(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return session.SetVersion(ctx, target, false)
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(Session) error) error {
+	session, err := m.store.Lock(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(session)
+
+	// Unlock even if ctx was canceled, so the lock isn't held until the
+	// connection is closed.
+	unlockErr := session.Unlock(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+
+	return unlockErr
+}
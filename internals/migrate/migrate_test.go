@@ -0,0 +1,217 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// memoryStore is a Store kept in memory, standing in for the database. Exec
+// appends queries to a log and fails on the query failOn.
+type memoryStore struct {
+	mu      sync.Mutex
+	locked  bool
+	version int64
+	dirty   bool
+	log     []string
+	failOn  string
+}
+
+func (s *memoryStore) Version(ctx context.Context) (int64, bool, error) {
+	return s.version, s.dirty, nil
+}
+
+func (s *memoryStore) Lock(ctx context.Context) (Session, error) {
+	s.mu.Lock()
+	s.locked = true
+	return memorySession{s}, nil
+}
+
+type memorySession struct {
+	s *memoryStore
+}
+
+func (session memorySession) Version(ctx context.Context) (int64, bool, error) {
+	return session.s.version, session.s.dirty, nil
+}
+
+func (session memorySession) SetVersion(ctx context.Context, version int64, dirty bool) error {
+	session.s.version, session.s.dirty = version, dirty
+	return nil
+}
+
+func (session memorySession) Exec(ctx context.Context, query string) error {
+	if query == session.s.failOn {
+		return errors.New("syntax error")
+	}
+
+	session.s.log = append(session.s.log, query)
+	return nil
+}
+
+func (session memorySession) Unlock(ctx context.Context) error {
+	session.s.locked = false
+	session.s.mu.Unlock()
+	return nil
+}
+
+func testMigrations(t *testing.T) []Migration {
+	t.Helper()
+
+	migrations, err := Load(fstest.MapFS{
+		"000002_add_year.up.sql":       {Data: []byte("up 2")},
+		"000002_add_year.down.sql":     {Data: []byte("down 2")},
+		"000001_create_films.up.sql":   {Data: []byte("up 1")},
+		"000001_create_films.down.sql": {Data: []byte("down 1")},
+		"000003_add_index.up.sql":      {Data: []byte("up 3")},
+		"000003_add_index.down.sql":    {Data: []byte("down 3")},
+		"README.md":                    {Data: []byte("not a migration")},
+		"000004_seed.up.sql.orig":      {Data: []byte("not a migration either")},
+		"nested/000005_ignored.up.sql": {Data: []byte("in a subdirectory")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return migrations
+}
+
+// TestLoad tests reading migrations from a file system
+func TestLoad(t *testing.T) {
+	migrations := testMigrations(t)
+
+	var got []int64
+	for _, migration := range migrations {
+		got = append(got, migration.Version)
+	}
+
+	if !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Fatalf("got versions %v, want [1 2 3]", got)
+	}
+
+	if migrations[0].Name != "create_films" || migrations[0].Up != "up 1" || migrations[0].Down != "down 1" {
+		t.Errorf("got %+v, want create_films with its up and down files", migrations[0])
+	}
+
+	_, err := Load(fstest.MapFS{"000001_create_films.down.sql": {Data: []byte("down 1")}})
+	if err == nil {
+		t.Error("loaded a migration without an up file")
+	}
+
+	_, err = Load(fstest.MapFS{
+		"000001_create_films.up.sql": {Data: []byte("up 1")},
+		"000001_create_users.up.sql": {Data: []byte("up 1")},
+	})
+	if err == nil {
+		t.Error("loaded two migrations with the same version")
+	}
+}
+
+// TestUpAndDown tests applying and reverting migrations
+func TestUpAndDown(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+	m := New(store, testMigrations(t))
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 3 || store.version != 3 || store.dirty {
+		t.Fatalf("got %d applied and version %d (dirty %v), want 3 applied at version 3", len(applied), store.version, store.dirty)
+	}
+
+	applied, err = m.Up(ctx)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("second Up: got %d applied and error %v, want nothing to do", len(applied), err)
+	}
+
+	reverted, err := m.Down(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reverted) != 2 || store.version != 1 {
+		t.Errorf("got %d reverted and version %d, want 2 reverted at version 1", len(reverted), store.version)
+	}
+
+	reverted, err = m.Down(ctx, 5)
+	if err != nil || len(reverted) != 1 || store.version != 0 {
+		t.Errorf("reverting past the first migration: got %d reverted, version %d and error %v, want 1 reverted at version 0", len(reverted), store.version, err)
+	}
+
+	want := []string{"up 1", "up 2", "up 3", "down 3", "down 2", "down 1"}
+	if !slices.Equal(store.log, want) {
+		t.Errorf("got queries %v, want %v", store.log, want)
+	}
+
+	if store.locked {
+		t.Error("lock still held")
+	}
+}
+
+// TestUpDirty tests that a failed migration leaves the schema dirty until forced
+func TestUpDirty(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{failOn: "up 2"}
+	m := New(store, testMigrations(t))
+
+	applied, err := m.Up(ctx)
+	if err == nil {
+		t.Fatal("Up succeeded despite a failing migration")
+	}
+
+	if len(applied) != 1 || store.version != 2 || !store.dirty {
+		t.Fatalf("got %d applied and version %d (dirty %v), want 1 applied and dirty at version 2", len(applied), store.version, store.dirty)
+	}
+
+	store.failOn = ""
+
+	if _, err := m.Up(ctx); !errors.Is(err, ErrDirty) {
+		t.Errorf("Up on a dirty schema: got %v, want %v", err, ErrDirty)
+	}
+
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrDirty) {
+		t.Errorf("Down on a dirty schema: got %v, want %v", err, ErrDirty)
+	}
+
+	if err := m.Force(ctx, 9); !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("forcing an unknown version: got %v, want %v", err, ErrUnknownVersion)
+	}
+
+	if err := m.Force(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Version != 3 || status.Dirty || status.Latest != 3 || len(status.Pending) != 0 {
+		t.Errorf("got status %+v, want clean at the latest version", status)
+	}
+}
+
+// TestStatus tests reporting pending migrations
+func TestStatus(t *testing.T) {
+	store := &memoryStore{version: 1}
+	m := New(store, testMigrations(t))
+
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Version != 1 || status.Latest != 3 || len(status.Pending) != 2 || status.Pending[0].Version != 2 {
+		t.Errorf("got status %+v, want version 1 with 2 and 3 pending", status)
+	}
+}
@@ -0,0 +1,156 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+)
+
+// nilVersion is how golang-migrate records that no migration is applied while
+// the first one is being reverted and the schema is dirty.
+const nilVersion = -1
+
+// lockKey is the advisory lock held while migrating.
+var lockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("migrate:schema_migrations"))
+	return int64(h.Sum64())
+}()
+
+// PostgresStore keeps the version in the schema_migrations table and
+// serialises migrations with a session-level advisory lock, which is released
+// with the connection if the process dies.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Conn.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func readVersion(ctx context.Context, q queryRower) (int64, bool, error) {
+	var exists bool
+	err := q.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+
+	var version int64
+	var dirty bool
+
+	err = q.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	if version == nilVersion {
+		version = 0
+	}
+
+	return version, dirty, nil
+}
+
+func (store *PostgresStore) Version(ctx context.Context) (int64, bool, error) {
+	return readVersion(ctx, store.DB)
+}
+
+func (store *PostgresStore) Lock(ctx context.Context) (Session, error) {
+	conn, err := store.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	session := &postgresSession{conn: conn}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL PRIMARY KEY,
+			dirty boolean NOT NULL
+		)
+	`)
+	if err != nil {
+		session.Unlock(context.WithoutCancel(ctx))
+		return nil, err
+	}
+
+	return session, nil
+}
+
+type postgresSession struct {
+	conn *sql.Conn
+}
+
+func (session *postgresSession) Version(ctx context.Context) (int64, bool, error) {
+	return readVersion(ctx, session.conn)
+}
+
+// SetVersion replaces the table's only row. Like golang-migrate, it leaves
+// the table empty for a clean schema with nothing applied.
+func (session *postgresSession) SetVersion(ctx context.Context, version int64, dirty bool) error {
+	tx, err := session.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	if version > 0 || dirty {
+		if version == 0 {
+			version = nilVersion
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, version, dirty)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Exec runs a migration outside a transaction, as golang-migrate does, so
+// statements such as CREATE INDEX CONCURRENTLY work. A file holding several
+// statements is sent as one simple query.
+func (session *postgresSession) Exec(ctx context.Context, query string) error {
+	_, err := session.conn.ExecContext(ctx, query)
+	return err
+}
+
+// Unlock releases the lock and returns the connection to the pool. The
+// connection is closed rather than reused if unlocking fails, which drops
+// the lock anyway.
+func (session *postgresSession) Unlock(ctx context.Context) error {
+	_, err := session.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+	if err != nil {
+		session.conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+
+	closeErr := session.conn.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix marks a plaintext API key so it can't be mistaken for a token.
+const APIKeyPrefix = "fapi_"
+
+var ErrDuplicateAPIKeyName = errors.New("duplicate api key name")
+
+// apiKeyLastUsedPrecision is how stale last_used_at may get before a request
+// refreshes it, so a busy key doesn't write to the database on every request.
+// GetForKey's query spells it out as interval '1 minute'.
+const apiKeyLastUsedPrecision = time.Minute
+
+type APIKey struct {
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Plaintext   string      `json:"key,omitempty"`
+	Prefix      string      `json:"prefix"`
+	Hash        []byte      `json:"-"`
+	UserID      int64       `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at,omitempty"`
+	Expiry      *time.Time  `json:"expiry,omitempty"`
+}
+
+func generateAPIKey(userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key := &APIKey{
+		UserID:      userID,
+		Name:        name,
+		Permissions: permissions,
+		Expiry:      expiry,
+	}
+
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	key.Plaintext = APIKeyPrefix + secret
+	key.Prefix = APIKeyPrefix + secret[:8]
+	hash := sha256.Sum256([]byte(key.Plaintext))
+	key.Hash = hash[:]
+
+	return key, nil
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, keyPlaintext string) {
+	v.Check(keyPlaintext != "", "api_key", "must be provided")
+	v.Check(strings.HasPrefix(keyPlaintext, APIKeyPrefix), "api_key", "must start with "+APIKeyPrefix)
+	v.Check(len(keyPlaintext) == len(APIKeyPrefix)+32, "api_key", "must be 37 bytes long")
+}
+
+// ValidateAPIKey checks a key before insertion. granted holds the permissions
+// of the owning user; a key can only ever carry a subset of them.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(len(key.Permissions) >= 1, "permissions", "must contain at least 1 permission")
+	v.Check(validator.Unique(key.Permissions), "permissions", "must not contain duplicate values")
+	for _, code := range key.Permissions {
+		v.Check(granted.Include(code), "permissions", "you don't hold the permission: "+code)
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+type APIKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (model APIKeyModel) New(ctx context.Context, userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = model.Insert(ctx, key)
+	return key, err
+}
+
+func (model APIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array([]string(key.Permissions)), key.Expiry}
+
+	ctx, span := startSpan(ctx, "APIKeyModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "api_keys_user_name_unique"`:
+			return ErrDuplicateAPIKeyName
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+func (model APIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, permissions, created_at, last_used_at, expiry
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY id ASC
+	`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.GetAllForUser", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array((*[]string)(&key.Permissions)),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.Expiry,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return keys, nil
+}
+
+func (model APIKeyModel) Delete(ctx context.Context, userID, keyID int64) error {
+	if keyID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM api_keys
+		WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, span := startSpan(ctx, "APIKeyModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetForKey looks up an unexpired key by its plaintext and returns it together
+// with its owner. The key's last_used_at timestamp is refreshed on the way,
+// unless it was already refreshed within apiKeyLastUsedPrecision.
+func (model APIKeyModel) GetForKey(ctx context.Context, keyPlaintext string) (*User, *APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	query := `
+		WITH found AS (
+			SELECT users.id AS user_id, users.created_at AS user_created_at, users.name AS user_name,
+			users.email, users.password_hash, users.activated, users.suspended, users.version,
+			api_keys.id, api_keys.name, api_keys.prefix, api_keys.permissions, api_keys.created_at,
+			api_keys.last_used_at, api_keys.expiry
+			FROM api_keys
+			INNER JOIN users ON users.id = api_keys.user_id
+			WHERE api_keys.hash = $1
+			AND (api_keys.expiry IS NULL OR api_keys.expiry > $2)
+		), touched AS (
+			UPDATE api_keys
+			SET last_used_at = NOW()
+			FROM found
+			WHERE api_keys.id = found.id
+			AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < NOW() - interval '1 minute')
+			RETURNING api_keys.id, api_keys.last_used_at
+		)
+		SELECT found.user_id, found.user_created_at, found.user_name, found.email, found.password_hash,
+		found.activated, found.suspended, found.version,
+		found.id, found.name, found.prefix, found.permissions, found.created_at,
+		COALESCE(touched.last_used_at, found.last_used_at), found.expiry
+		FROM found
+		LEFT JOIN touched ON touched.id = found.id
+	`
+
+	args := []any{keyHash[:], time.Now()}
+
+	var user User
+	var key APIKey
+
+	ctx, span := startSpan(ctx, "APIKeyModel.GetForKey", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array((*[]string)(&key.Permissions)),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.Expiry,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, queryError(ctx, err)
+		}
+	}
+
+	key.UserID = user.ID
+
+	return &user, &key, nil
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+// TestGenerateAPIKey tests the API key generation function
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := generateAPIKey(1, "ci", Permissions{"films:read"}, nil)
+	if err != nil {
+		t.Fatalf("generateAPIKey() error = %v", err)
+	}
+
+	if !strings.HasPrefix(key.Plaintext, APIKeyPrefix) {
+		t.Errorf("generateAPIKey() plaintext = %q, want prefix %q", key.Plaintext, APIKeyPrefix)
+	}
+	if !strings.HasPrefix(key.Plaintext, key.Prefix) {
+		t.Errorf("generateAPIKey() prefix = %q is not a prefix of the plaintext", key.Prefix)
+	}
+	if len(key.Hash) == 0 {
+		t.Error("generateAPIKey() key.Hash is empty")
+	}
+
+	v := validator.New()
+	if ValidateAPIKeyPlaintext(v, key.Plaintext); !v.Valid() {
+		t.Errorf("generated key failed validation: %v", v.Errors)
+	}
+
+	other, err := generateAPIKey(1, "ci", Permissions{"films:read"}, nil)
+	if err != nil {
+		t.Fatalf("generateAPIKey() error = %v", err)
+	}
+	if other.Plaintext == key.Plaintext {
+		t.Error("generateAPIKey() returned the same key twice")
+	}
+}
+
+// TestValidateAPIKeyPlaintext tests the API key plaintext validation function
+func TestValidateAPIKeyPlaintext(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		wantValid bool
+	}{
+		{
+			name:      "Valid key",
+			plaintext: APIKeyPrefix + "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567",
+			wantValid: true,
+		},
+		{
+			name:      "Empty key",
+			plaintext: "",
+			wantValid: false,
+		},
+		{
+			name:      "Missing prefix",
+			plaintext: "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567ABCDE",
+			wantValid: false,
+		},
+		{
+			name:      "Too short",
+			plaintext: APIKeyPrefix + "ABCDEFGHIJ",
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateAPIKeyPlaintext(v, tt.plaintext)
+			if v.Valid() != tt.wantValid {
+				t.Errorf("ValidateAPIKeyPlaintext() got valid = %v, want %v, errors: %v", v.Valid(), tt.wantValid, v.Errors)
+			}
+		})
+	}
+}
+
+// TestValidateAPIKey tests the API key validation function
+func TestValidateAPIKey(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	granted := Permissions{"films:read", "films:write"}
+
+	tests := []struct {
+		name      string
+		key       *APIKey
+		wantValid bool
+	}{
+		{
+			name:      "Valid key",
+			key:       &APIKey{Name: "ci", Permissions: Permissions{"films:read"}, Expiry: &future},
+			wantValid: true,
+		},
+		{
+			name:      "Missing name",
+			key:       &APIKey{Permissions: Permissions{"films:read"}},
+			wantValid: false,
+		},
+		{
+			name:      "No permissions",
+			key:       &APIKey{Name: "ci"},
+			wantValid: false,
+		},
+		{
+			name:      "Permission not held by the user",
+			key:       &APIKey{Name: "ci", Permissions: Permissions{"films:delete"}},
+			wantValid: false,
+		},
+		{
+			name:      "Duplicate permissions",
+			key:       &APIKey{Name: "ci", Permissions: Permissions{"films:read", "films:read"}},
+			wantValid: false,
+		},
+		{
+			name:      "Expiry in the past",
+			key:       &APIKey{Name: "ci", Permissions: Permissions{"films:read"}, Expiry: &past},
+			wantValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateAPIKey(v, tt.key, granted)
+			if v.Valid() != tt.wantValid {
+				t.Errorf("ValidateAPIKey() got valid = %v, want %v, errors: %v", v.Valid(), tt.wantValid, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditEvent records a privileged change: who made it, to what, and the
+// resource's JSON before and after. ActorID is nil for changes made by an
+// unauthenticated request, such as activating an account with a token.
+type AuditEvent struct {
+	ID           int64           `json:"id"`
+	CreatedAt    time.Time       `json:"created_at"`
+	ActorID      *int64          `json:"actor_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   int64           `json:"resource_id"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+	RequestID    string          `json:"request_id,omitempty"`
+	IP           string          `json:"ip"`
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SetBefore stores the JSON form of the resource ahead of the change. It has
+// to be called before the resource is modified.
+func (event *AuditEvent) SetBefore(resource any) error {
+	before, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+
+	event.Before = before
+	return nil
+}
+
+// record inserts event with q, filling in the resource ID (for creates, it
+// isn't known until the insert) and its state after the change. A nil event
+// records nothing, so models can call it unconditionally.
+func (event *AuditEvent) record(ctx context.Context, q queryRower, resourceID int64, after any) error {
+	if event == nil {
+		return nil
+	}
+
+	event.ResourceID = resourceID
+
+	if after != nil {
+		data, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		event.After = data
+	}
+
+	query := `
+		INSERT INTO audit_events (actor_id, action, resource_type, resource_id, before, after, request_id, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING id, created_at
+	`
+
+	args := []any{
+		event.ActorID,
+		event.Action,
+		event.ResourceType,
+		event.ResourceID,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+		event.RequestID,
+		event.IP,
+	}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func nullableJSON(data json.RawMessage) any {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return []byte(data)
+}
+
+// inTx runs fn inside a transaction, committing only if it succeeds.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return queryError(ctx, err)
+	}
+
+	return queryError(ctx, tx.Commit())
+}
+
+type AuditModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+// Insert records an event on its own, for actions that don't change a row
+// the audit entry could share a transaction with.
+func (model AuditModel) Insert(ctx context.Context, event *AuditEvent, resourceID int64) error {
+	ctx, span := startSpan(ctx, "AuditModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	return queryError(ctx, event.record(ctx, model.DB, resourceID, nil))
+}
+
+// GetAll lists audit events, newest first unless sorted otherwise. Zero
+// values for actorID, action, resourceType and resourceID and nil times mean
+// "don't filter on this".
+func (model AuditModel) GetAll(ctx context.Context, actorID int64, action, resourceType string, resourceID int64, from, to *time.Time, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, actor_id, action, resource_type, resource_id,
+		before, after, COALESCE(request_id, ''), ip
+		FROM audit_events
+		WHERE ($1 = 0 OR actor_id = $1)
+		AND ($2 = '' OR action = $2)
+		AND ($3 = '' OR resource_type = $3)
+		AND ($4 = 0 OR resource_id = $4)
+		AND ($5::timestamptz IS NULL OR created_at >= $5)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		ORDER BY %s id DESC
+		LIMIT $7 OFFSET $8
+	`, filters.sortColumn())
+
+	args := []any{actorID, action, resourceType, resourceID, from, to, filters.limit(), filters.offset()}
+
+	ctx, span := startSpan(ctx, "AuditModel.GetAll", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	events := []*AuditEvent{}
+	totalRecords := 0
+	for rows.Next() {
+		var event AuditEvent
+		var before, after []byte
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.CreatedAt,
+			&event.ActorID,
+			&event.Action,
+			&event.ResourceType,
+			&event.ResourceID,
+			&before,
+			&after,
+			&event.RequestID,
+			&event.IP,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		event.Before = before
+		event.After = after
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
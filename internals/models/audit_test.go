@@ -0,0 +1,44 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestAuditEventSetBefore tests that the resource is captured as it was when
+// SetBefore was called
+func TestAuditEventSetBefore(t *testing.T) {
+	film := &Film{ID: 1, Title: "Alien", Year: 1979}
+
+	event := &AuditEvent{Action: "film.update", ResourceType: "film"}
+	if err := event.SetBefore(film); err != nil {
+		t.Fatalf("SetBefore() error = %v", err)
+	}
+
+	film.Title = "Aliens"
+
+	if got := string(event.Before); got == "" || !strings.Contains(got, `"title":"Alien"`) {
+		t.Errorf("Before = %s, want the original title", got)
+	}
+}
+
+// TestAuditEventRecordNil tests that models can record a nil event
+func TestAuditEventRecordNil(t *testing.T) {
+	var event *AuditEvent
+
+	if err := event.record(context.Background(), nil, 1, &Film{}); err != nil {
+		t.Errorf("record() on a nil event = %v, want nil", err)
+	}
+}
+
+// TestNullableJSON tests that missing snapshots are stored as NULL
+func TestNullableJSON(t *testing.T) {
+	if got := nullableJSON(nil); got != nil {
+		t.Errorf("nullableJSON(nil) = %v, want nil", got)
+	}
+
+	if got, ok := nullableJSON([]byte(`{}`)).([]byte); !ok || string(got) != `{}` {
+		t.Errorf("nullableJSON({}) = %v, want the raw bytes", got)
+	}
+}
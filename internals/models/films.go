@@ -6,8 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"filmapi.zeyadtarek.net/internals/cache"
 	"filmapi.zeyadtarek.net/internals/validator"
 	"github.com/lib/pq"
 )
@@ -28,9 +33,95 @@ type Film struct {
 
 type FilmModel struct {
 	DB        *sql.DB
+	Timeout   time.Duration
 	Genres    GenreModel
 	Actors    ActorModel
 	Directors DirectorModel
+	// Cache, if set, holds the results of Get and GetAll until a write
+	// through this model invalidates them or they expire. Writes made
+	// elsewhere, such as by another replica, show up once entries expire.
+	// A read that overlaps an invalidation isn't cached, as it may have
+	// seen the data from before the write.
+	Cache cache.Cache
+	audit *AuditEvent
+}
+
+// filmListPrefix starts the cache key of every film listing.
+const filmListPrefix = "films:"
+
+func filmCacheKey(id int64) string {
+	return "film:" + strconv.FormatInt(id, 10)
+}
+
+// filmListKey returns the cache key of a GetAll call. Parameters are
+// normalised so that requests with the same results share an entry: the
+// title search ignores case and spacing, and the name filters ignore order
+// and repeats.
+func filmListKey(title string, genres []string, actors []string, directors []string, filters Filters) string {
+	normalisedTitle := strings.ToLower(strings.Join(strings.Fields(title), " "))
+	if normalisedTitle == "" && title != "" {
+		// A blank search matches nothing, unlike no search at all.
+		normalisedTitle = " "
+	}
+
+	values := url.Values{}
+	values.Set("title", normalisedTitle)
+	values.Set("genres", normaliseNames(genres))
+	values.Set("actors", normaliseNames(actors))
+	values.Set("directors", normaliseNames(directors))
+	values.Set("sort", strings.Join(filters.SortValues, ","))
+	values.Set("page", strconv.Itoa(filters.Page))
+	values.Set("page_size", strconv.Itoa(filters.PageSize))
+
+	return filmListPrefix + values.Encode()
+}
+
+func normaliseNames(names []string) string {
+	sorted := slices.Clone(names)
+	slices.Sort(sorted)
+	return strings.Join(slices.Compact(sorted), ",")
+}
+
+// cachedFilmList is a GetAll result as it's kept in the cache.
+type cachedFilmList struct {
+	films    []*Film
+	metadata Metadata
+}
+
+// clone returns a copy of the film that shares nothing with it, so cached
+// films can't be changed by callers.
+func (f *Film) clone() *Film {
+	c := *f
+	c.Genres = slices.Clone(f.Genres)
+	c.Directors = slices.Clone(f.Directors)
+	c.Actors = slices.Clone(f.Actors)
+	return &c
+}
+
+func cloneFilms(films []*Film) []*Film {
+	clones := make([]*Film, len(films))
+	for i, film := range films {
+		clones[i] = film.clone()
+	}
+	return clones
+}
+
+// invalidate drops the cached film with the given ID and every cached
+// listing, since any of them may include it.
+func (model FilmModel) invalidate(id int64) {
+	if model.Cache == nil {
+		return
+	}
+
+	model.Cache.Delete(filmCacheKey(id))
+	model.Cache.DeletePrefix(filmListPrefix)
+}
+
+// WithAudit returns a copy of the model whose next write also records event,
+// in the same transaction.
+func (model FilmModel) WithAudit(event *AuditEvent) FilmRepository {
+	model.audit = event
+	return model
 }
 
 func NewFilmModel(db *sql.DB) FilmModel {
@@ -77,11 +168,19 @@ func (f Film) MarshalJSON() ([]byte, error) {
 	return json.Marshal(aux)
 }
 
-func (model FilmModel) Get(id int64) (*Film, error) {
+func (model FilmModel) Get(ctx context.Context, id int64) (*Film, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
+	var generation uint64
+	if model.Cache != nil {
+		if cached, ok := model.Cache.Get(filmCacheKey(id)); ok {
+			return cached.(*Film).clone(), nil
+		}
+		generation = model.Cache.Generation()
+	}
+
 	query := `
 		SELECT 
 		f.id, f.title, f.year, f.runtime, f.rating, f.description, f.image, f.version,
@@ -92,7 +191,10 @@ func (model FilmModel) Get(id int64) (*Film, error) {
 		WHERE f.id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "FilmModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	var film Film
@@ -118,7 +220,7 @@ func (model FilmModel) Get(id int64) (*Film, error) {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrRecordNotFound
 		}
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 
 	// Convert string arrays to respective types
@@ -137,44 +239,68 @@ func (model FilmModel) Get(id int64) (*Film, error) {
 		film.Directors[i] = Director{Name: director}
 	}
 
+	if model.Cache != nil {
+		model.Cache.SetIfUnchanged(filmCacheKey(id), film.clone(), generation)
+	}
+
 	return &film, nil
 }
 
-func (model FilmModel) Insert(film *Film) error {
-	tx, err := model.DB.Begin()
+func (model FilmModel) Insert(ctx context.Context, film *Film) error {
+	ctx, span := startSpan(ctx, "FilmModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 	defer tx.Rollback()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
 	// Insert film
 	query := `INSERT INTO films (title, year, runtime, rating, description, image, version) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
 	err = tx.QueryRowContext(ctx, query, film.Title, film.Year, film.Runtime, film.Rating, film.Description, film.Img, 1).Scan(&film.ID)
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 
 	// Batch insert related entities
 	if err := model.batchInsertRelations(tx, ctx, film); err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 
-	return tx.Commit()
-}
+	if err := model.audit.record(ctx, tx, film.ID, film); err != nil {
+		return queryError(ctx, err)
+	}
+
+	if err := publishWebhookEvent(ctx, tx, EventFilmCreated, nil, film); err != nil {
+		return queryError(ctx, err)
+	}
 
-func (model FilmModel) Update(film *Film) error {
-	tx, err := model.DB.Begin()
+	err = tx.Commit()
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
-	defer tx.Rollback()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	model.invalidate(film.ID)
+	return nil
+}
+
+func (model FilmModel) Update(ctx context.Context, film *Film) error {
+	ctx, span := startSpan(ctx, "FilmModel.Update", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
 	query := `
 		UPDATE films
 		SET title = $1, year = $2, runtime = $3, rating = $4, description = $5, image = $6, version = version + 1
@@ -198,47 +324,89 @@ func (model FilmModel) Update(film *Film) error {
 		if errors.Is(err, sql.ErrNoRows) {
 			return ErrEditConflict
 		}
-		return err
+		return queryError(ctx, err)
 	}
 
 	if err := model.batchInsertRelations(tx, ctx, film); err != nil {
-		return err
+		return queryError(ctx, err)
+	}
+
+	if err := model.audit.record(ctx, tx, film.ID, film); err != nil {
+		return queryError(ctx, err)
+	}
+
+	if err := publishWebhookEvent(ctx, tx, EventFilmUpdated, nil, film); err != nil {
+		return queryError(ctx, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return queryError(ctx, err)
 	}
 
-	return tx.Commit()
+	model.invalidate(film.ID)
+	return nil
 }
 
-func (model FilmModel) Delete(id int64) error {
+// Delete removes the film if it's still at film.Version, otherwise it
+// returns ErrEditConflict.
+func (model FilmModel) Delete(ctx context.Context, film *Film) error {
+	id := film.ID
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "FilmModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	query := `
-		DELETE FROM films WHERE id = $1
+		DELETE FROM films WHERE id = $1 AND version = $2
 	`
 
-	result, err := model.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	err := inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, id, film.Version)
+		if err != nil {
+			return err
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		if rowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		if err := model.audit.record(ctx, tx, id, nil); err != nil {
+			return err
+		}
+
+		return publishWebhookEvent(ctx, tx, EventFilmDeleted, nil, map[string]int64{"id": id})
+	})
+	if err != nil {
+		return queryError(ctx, err)
 	}
 
+	model.invalidate(id)
 	return nil
-
 }
 
-func (model FilmModel) GetAll(title string, genres []string, actors []string, directors []string, filters Filters) ([]*Film, Metadata, error) {
+func (model FilmModel) GetAll(ctx context.Context, title string, genres []string, actors []string, directors []string, filters Filters) ([]*Film, Metadata, error) {
+	var key string
+	var generation uint64
+	if model.Cache != nil {
+		key = filmListKey(title, genres, actors, directors, filters)
+		if cached, ok := model.Cache.Get(key); ok {
+			list := cached.(cachedFilmList)
+			return cloneFilms(list.films), list.metadata, nil
+		}
+		generation = model.Cache.Generation()
+	}
+
 	query := fmt.Sprintf(`
 	SELECT COUNT(*) OVER(),
 		f.*,
@@ -280,12 +448,15 @@ func (model FilmModel) GetAll(title string, genres []string, actors []string, di
 		LIMIT $5 OFFSET $6
 		`, filters.sortColumn())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "FilmModel.GetAll", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	rows, err := model.DB.QueryContext(ctx, query, title, pq.Array(genres), pq.Array(actors), pq.Array(directors), filters.limit(), filters.offset())
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -322,7 +493,7 @@ func (model FilmModel) GetAll(title string, genres []string, actors []string, di
 			pq.Array(&filmInput.Directors),
 		)
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 
 		film.ID = filmInput.ID
@@ -356,10 +527,15 @@ func (model FilmModel) GetAll(title string, genres []string, actors []string, di
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	if model.Cache != nil {
+		model.Cache.SetIfUnchanged(key, cachedFilmList{films: cloneFilms(films), metadata: metadata}, generation)
+	}
+
 	return films, metadata, nil
 }
 
@@ -440,12 +616,18 @@ func (model FilmModel) batchInsertRelations(tx *sql.Tx, ctx context.Context, fil
 }
 
 // Count returns the number of films in the database.
-func (m *FilmModel) Count() (int, error) {
+func (m FilmModel) Count(ctx context.Context) (int, error) {
+	ctx, span := startSpan(ctx, "FilmModel.Count", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
+	defer cancel()
+
 	var count int
 	query := `SELECT COUNT(*) FROM films` // Adjust the table name as necessary
-	err := m.DB.QueryRowContext(context.Background(), query).Scan(&count)
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
 	if err != nil {
-		return 0, err
+		return 0, queryError(ctx, err)
 	}
 	return count, nil
 }
@@ -3,12 +3,16 @@ package models
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
+	"filmapi.zeyadtarek.net/internals/cache"
 	"filmapi.zeyadtarek.net/internals/validator"
 	_ "github.com/lib/pq"
 )
@@ -593,3 +597,181 @@ func TestFilmMarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+// TestFilmListKey tests that listings with the same results share a cache
+// key and that different ones don't
+func TestFilmListKey(t *testing.T) {
+	filters := Filters{Page: 1, PageSize: 20, SortValues: []string{"id"}}
+	base := filmListKey("the dark knight", []string{"action", "drama"}, nil, nil, filters)
+
+	same := []struct {
+		name string
+		key  string
+	}{
+		{"Title case and spacing", filmListKey("  The  Dark KNIGHT ", []string{"action", "drama"}, nil, nil, filters)},
+		{"Genre order and repeats", filmListKey("the dark knight", []string{"drama", "action", "drama"}, nil, nil, filters)},
+		{"Empty and nil filters", filmListKey("the dark knight", []string{"action", "drama"}, []string{}, []string{}, filters)},
+	}
+
+	for _, tt := range same {
+		if tt.key != base {
+			t.Errorf("%s: got key %q, want %q", tt.name, tt.key, base)
+		}
+	}
+
+	different := []struct {
+		name string
+		key  string
+	}{
+		{"Genre case", filmListKey("the dark knight", []string{"Action", "drama"}, nil, nil, filters)},
+		{"Genre as actor", filmListKey("the dark knight", nil, []string{"action", "drama"}, nil, filters)},
+		{"Page", filmListKey("the dark knight", []string{"action", "drama"}, nil, nil, Filters{Page: 2, PageSize: 20, SortValues: []string{"id"}})},
+		{"Sort", filmListKey("the dark knight", []string{"action", "drama"}, nil, nil, Filters{Page: 1, PageSize: 20, SortValues: []string{"-id"}})},
+	}
+
+	for _, tt := range different {
+		if tt.key == base {
+			t.Errorf("%s: key matches the base listing", tt.name)
+		}
+	}
+
+	if filmListKey("", nil, nil, nil, filters) == filmListKey("   ", nil, nil, nil, filters) {
+		t.Error("a blank title search shares a key with no search")
+	}
+}
+
+// TestFilmCache tests that cached reads skip the database, hand out copies,
+// and are dropped on invalidation
+func TestFilmCache(t *testing.T) {
+	model := FilmModel{Cache: cache.NewLRU(10, 0)}
+	filters := Filters{Page: 1, PageSize: 20, SortValues: []string{"id"}}
+
+	heat := &Film{ID: 1, Title: "Heat", Genres: []Genre{{Name: "crime"}}, Version: 1}
+	model.Cache.Set(filmCacheKey(1), heat)
+	model.Cache.Set(filmListKey("", nil, nil, nil, filters), cachedFilmList{
+		films:    []*Film{heat},
+		metadata: Metadata{CurrentPage: 1, TotalRecords: 1},
+	})
+
+	// DB is nil, so these only succeed if they're served from the cache.
+	film, err := model.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	film.Title = "Changed"
+	film.Genres[0].Name = "changed"
+	if heat.Title != "Heat" || heat.Genres[0].Name != "crime" {
+		t.Error("changing a returned film changed the cached one")
+	}
+
+	films, metadata, err := model.GetAll(context.Background(), "", nil, nil, nil, filters)
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if len(films) != 1 || films[0].Title != "Heat" || metadata.TotalRecords != 1 {
+		t.Errorf("got %v, %+v from the cached listing", films, metadata)
+	}
+
+	model.invalidate(1)
+
+	if stats := model.Cache.Stats(); stats.Entries != 0 {
+		t.Errorf("got %d entries after invalidation, want 0", stats.Entries)
+	}
+}
+
+// filmRowDriver is a database/sql driver whose every query returns the row
+// of a single film, calling during first, as a concurrent write would.
+type filmRowDriver struct {
+	during func()
+}
+
+func (d filmRowDriver) Open(name string) (driver.Conn, error) {
+	return filmRowConn(d), nil
+}
+
+func (d filmRowDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d filmRowDriver) Driver() driver.Driver {
+	return d
+}
+
+type filmRowConn filmRowDriver
+
+func (c filmRowConn) Prepare(query string) (driver.Stmt, error) {
+	return filmRowStmt{during: c.during, counted: strings.Contains(query, "COUNT(*)")}, nil
+}
+
+func (c filmRowConn) Close() error              { return nil }
+func (c filmRowConn) Begin() (driver.Tx, error) { return nil, errors.New("not supported") }
+
+// filmRowStmt is a query of Get, or if counted of GetAll, whose rows start
+// with the total count.
+type filmRowStmt struct {
+	during  func()
+	counted bool
+}
+
+func (s filmRowStmt) Close() error  { return nil }
+func (s filmRowStmt) NumInput() int { return -1 }
+
+func (s filmRowStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s filmRowStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.during()
+
+	row := []driver.Value{int64(1), "Heat", int64(1995), int64(170), 8.3, "", "", int64(1), []byte("{crime}"), []byte("{}"), []byte("{}")}
+	if s.counted {
+		row = append([]driver.Value{int64(1)}, row...)
+	}
+
+	return &filmRows{row: row}, nil
+}
+
+type filmRows struct {
+	row  []driver.Value
+	done bool
+}
+
+func (r *filmRows) Columns() []string {
+	return make([]string, len(r.row))
+}
+
+func (r *filmRows) Close() error { return nil }
+
+func (r *filmRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+
+	copy(dest, r.row)
+	return nil
+}
+
+// TestFilmCacheInvalidatedDuringRead tests that a read overlapping an
+// invalidation isn't cached, as it may have seen the film before the write
+func TestFilmCacheInvalidatedDuringRead(t *testing.T) {
+	var model FilmModel
+	db := sql.OpenDB(filmRowDriver{during: func() { model.invalidate(1) }})
+	defer db.Close()
+
+	model = FilmModel{DB: db, Cache: cache.NewLRU(10, 0)}
+	filters := Filters{Page: 1, PageSize: 20, SortValues: []string{"id"}, SortSafelist: []string{"id"}}
+
+	if _, err := model.Get(context.Background(), 1); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if _, _, err := model.GetAll(context.Background(), "", nil, nil, nil, filters); err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+
+	if stats := model.Cache.Stats(); stats.Entries != 0 {
+		t.Errorf("got %d entries cached from reads that overlapped an invalidation, want 0", stats.Entries)
+	}
+}
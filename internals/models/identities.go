@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrDuplicateIdentity = errors.New("duplicate identity")
+
+// Identity links a local user to an account at an external OpenID Connect
+// provider, identified by the provider's issuer and subject.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type IdentityModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (model IdentityModel) Insert(ctx context.Context, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	args := []any{identity.UserID, identity.Issuer, identity.Subject, identity.Email}
+
+	ctx, span := startSpan(ctx, "IdentityModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&identity.ID, &identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_issuer_subject_unique"`:
+			return ErrDuplicateIdentity
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// GetUser returns the user linked to the given provider account.
+func (model IdentityModel) GetUser(ctx context.Context, issuer, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash,
+		users.activated, users.suspended, users.version
+		FROM users
+		INNER JOIN user_identities
+		ON users.id = user_identities.user_id
+		WHERE user_identities.issuer = $1
+		AND user_identities.subject = $2
+	`
+
+	var user User
+
+	ctx, span := startSpan(ctx, "IdentityModel.GetUser", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	LoginScopeAccount = "account"
+	LoginScopeIP      = "ip"
+)
+
+// LockoutPolicy decides how long logins are refused after failed attempts.
+// Every failure doubles the wait, starting from BaseDelay, until Threshold
+// failures lock the key out for the whole Lockout duration. Failures older
+// than Window are forgotten.
+type LockoutPolicy struct {
+	Threshold int
+	BaseDelay time.Duration
+	Lockout   time.Duration
+	Window    time.Duration
+}
+
+// Delay returns how long to refuse logins after the given number of
+// consecutive failures.
+func (policy LockoutPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	if policy.Threshold > 0 && failures >= policy.Threshold {
+		return policy.Lockout
+	}
+
+	delay := policy.BaseDelay
+	for i := 1; i < failures && delay < policy.Lockout; i++ {
+		delay *= 2
+	}
+
+	if delay > policy.Lockout {
+		return policy.Lockout
+	}
+
+	return delay
+}
+
+// LockedOut reports whether failures is enough for a full lockout.
+func (policy LockoutPolicy) LockedOut(failures int) bool {
+	return policy.Threshold > 0 && failures >= policy.Threshold
+}
+
+type LoginAttempt struct {
+	Scope        string
+	Key          string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+}
+
+// RetryAfter returns how long until the key may try again, or zero.
+func (attempt *LoginAttempt) RetryAfter(now time.Time) time.Duration {
+	if attempt == nil || attempt.LockedUntil == nil || !attempt.LockedUntil.After(now) {
+		return 0
+	}
+
+	return attempt.LockedUntil.Sub(now)
+}
+
+type LoginAttemptModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (model LoginAttemptModel) Get(ctx context.Context, scope, key string) (*LoginAttempt, error) {
+	query := `
+		SELECT scope, key, failures, last_failed_at, locked_until
+		FROM login_attempts
+		WHERE scope = $1 AND key = $2
+	`
+
+	var attempt LoginAttempt
+
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, scope, key).Scan(
+		&attempt.Scope,
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure counts a failed login for the key and locks it according to
+// policy. Counting restarts when the previous failure is outside the window.
+func (model LoginAttemptModel) RecordFailure(ctx context.Context, scope, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	ctx, span := startSpan(ctx, "LoginAttemptModel.RecordFailure", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO login_attempts (scope, key, failures, last_failed_at)
+		VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE
+				WHEN login_attempts.last_failed_at < NOW() - make_interval(secs => $3) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failed_at = NOW()
+		RETURNING scope, key, failures, last_failed_at
+	`
+
+	attempt := LoginAttempt{}
+	err = tx.QueryRowContext(ctx, query, scope, key, policy.Window.Seconds()).Scan(
+		&attempt.Scope,
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailedAt,
+	)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	lockedUntil := time.Now().Add(policy.Delay(attempt.Failures))
+	attempt.LockedUntil = &lockedUntil
+
+	_, err = tx.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $3 WHERE scope = $1 AND key = $2`, scope, key, lockedUntil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return &attempt, tx.Commit()
+}
+
+// Reset forgets all failures for the key, lifting any lockout.
+func (model LoginAttemptModel) Reset(ctx context.Context, scope, key string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE scope = $1 AND key = $2
+	`
+
+	ctx, span := startSpan(ctx, "LoginAttemptModel.Reset", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, scope, key)
+	return queryError(ctx, err)
+}
+
+// DeleteStale removes records whose last failure is older than cutoff and
+// that aren't holding a lockout, returning how many were deleted.
+func (model LoginAttemptModel) DeleteStale(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failed_at < $1
+		AND (locked_until IS NULL OR locked_until < NOW())
+	`
+
+	ctx, span := startSpan(ctx, "LoginAttemptModel.DeleteStale", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
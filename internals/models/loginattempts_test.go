@@ -0,0 +1,72 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLockoutPolicyDelay tests the exponential backoff and lockout
+func TestLockoutPolicyDelay(t *testing.T) {
+	policy := LockoutPolicy{
+		Threshold: 5,
+		BaseDelay: time.Second,
+		Lockout:   15 * time.Minute,
+		Window:    15 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 1, want: time.Second},
+		{failures: 2, want: 2 * time.Second},
+		{failures: 3, want: 4 * time.Second},
+		{failures: 4, want: 8 * time.Second},
+		{failures: 5, want: 15 * time.Minute},
+		{failures: 50, want: 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	if policy.LockedOut(4) || !policy.LockedOut(5) {
+		t.Error("LockedOut() doesn't match the threshold")
+	}
+}
+
+// TestLockoutPolicyDelayCapped tests that backoff never exceeds the lockout
+func TestLockoutPolicyDelayCapped(t *testing.T) {
+	policy := LockoutPolicy{
+		Threshold: 100,
+		BaseDelay: time.Second,
+		Lockout:   time.Minute,
+	}
+
+	if got := policy.Delay(99); got != time.Minute {
+		t.Errorf("Delay(99) = %v, want %v", got, time.Minute)
+	}
+}
+
+// TestLoginAttemptRetryAfter tests the remaining lockout calculation
+func TestLoginAttemptRetryAfter(t *testing.T) {
+	now := time.Now()
+	future := now.Add(30 * time.Second)
+	past := now.Add(-time.Second)
+
+	var missing *LoginAttempt
+	if got := missing.RetryAfter(now); got != 0 {
+		t.Errorf("RetryAfter() on nil = %v, want 0", got)
+	}
+
+	if got := (&LoginAttempt{LockedUntil: &past}).RetryAfter(now); got != 0 {
+		t.Errorf("RetryAfter() for an expired lock = %v, want 0", got)
+	}
+
+	if got := (&LoginAttempt{LockedUntil: &future}).RetryAfter(now); got != 30*time.Second {
+		t.Errorf("RetryAfter() = %v, want %v", got, 30*time.Second)
+	}
+}
@@ -0,0 +1,992 @@
+package models
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryPermissionCodes are the permissions the migrations create. Granting
+// any other code does nothing, as it matches no row in the permissions table.
+var memoryPermissionCodes = []string{"films:read", "films:write", "users:admin", "webhooks:manage"}
+
+// MemoryStore keeps every model's records in memory, behaving as the
+// PostgreSQL models do: versions are checked and bumped on writes, unique
+// emails, key names, identities and watchlist entries are enforced, deleting
+// a user or film removes what references it, and listings filter, sort and
+// paginate the same way. It's meant for tests, so that handlers can be
+// exercised without a database.
+//
+// Webhook events are published to an outbox by the same writes and relayed
+// to subscribed webhooks with the same rules. Audit events are kept and can
+// be read back with AuditEvents.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	films       map[int64]*memoryFilm
+	users       map[int64]*memoryUser
+	tokens      []*Token
+	permissions map[int64][]string
+	watchlist   map[int64]*Watchlist
+	audit       []AuditEvent
+	apiKeys     map[int64]*APIKey
+	identities  []*Identity
+	totp        map[int64]*memoryTOTP
+	logins      map[loginKey]*LoginAttempt
+	webhooks    map[int64]*Webhook
+	deliveries  map[int64]*WebhookDelivery
+	outbox      []memoryOutboxEvent
+
+	lastFilmID      int64
+	lastUserID      int64
+	lastWatchlistID int64
+	lastAPIKeyID    int64
+	lastIdentityID  int64
+	lastWebhookID   int64
+	lastDeliveryID  int64
+	lastOutboxID    int64
+}
+
+// memoryFilm is a film as it's stored, with its relations as names only.
+type memoryFilm struct {
+	film      Film
+	genres    []string
+	actors    []string
+	directors []string
+}
+
+// memoryUser is a user as it's stored. activatedAt is the activated_at
+// column, which DeleteUnactivated looks at.
+type memoryUser struct {
+	user        User
+	activatedAt *time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		films:       make(map[int64]*memoryFilm),
+		users:       make(map[int64]*memoryUser),
+		permissions: make(map[int64][]string),
+		watchlist:   make(map[int64]*Watchlist),
+		apiKeys:     make(map[int64]*APIKey),
+		totp:        make(map[int64]*memoryTOTP),
+		logins:      make(map[loginKey]*LoginAttempt),
+		webhooks:    make(map[int64]*Webhook),
+		deliveries:  make(map[int64]*WebhookDelivery),
+	}
+}
+
+// Models returns models whose records are all kept in the store.
+func (store *MemoryStore) Models() Models {
+	return Models{
+		Films:       memoryFilms{store: store},
+		Users:       memoryUsers{store: store},
+		Tokens:      memoryTokens{store: store},
+		Permissions: memoryPermissions{store: store},
+		Watchlist:   memoryWatchlist{store: store},
+		APIKeys:     memoryAPIKeys{store: store},
+		Identities:  memoryIdentities{store: store},
+		TOTP:        memoryTOTPs{store: store},
+		Logins:      memoryLogins{store: store},
+		Audit:       memoryAudit{store: store},
+		Webhooks:    memoryWebhooks{store: store},
+		Schema:      memorySchema{},
+	}
+}
+
+// AuditEvents returns the audit events recorded so far, oldest first.
+func (store *MemoryStore) AuditEvents() []AuditEvent {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return slices.Clone(store.audit)
+}
+
+// recordAudit is the in-memory counterpart of AuditEvent.record. The caller
+// must hold the lock.
+func (store *MemoryStore) recordAudit(event *AuditEvent, resourceID int64, after any) error {
+	if event == nil {
+		return nil
+	}
+
+	event.ResourceID = resourceID
+
+	if after != nil {
+		data, err := json.Marshal(after)
+		if err != nil {
+			return err
+		}
+		event.After = data
+	}
+
+	event.ID = int64(len(store.audit) + 1)
+	event.CreatedAt = time.Now()
+	store.audit = append(store.audit, *event)
+
+	return nil
+}
+
+// sortRecords orders records as the ORDER BY clause built from filters
+// would: by each sort value in turn, then by tiebreak. compare is given the
+// column without its direction prefix.
+func sortRecords[T any](records []T, filters Filters, compare func(column string, a, b T) int, tiebreak func(a, b T) int) {
+	// Panics on unsafe sort values, as the SQL models do.
+	filters.sortColumn()
+
+	slices.SortStableFunc(records, func(a, b T) int {
+		for _, sortValue := range filters.SortValues {
+			c := compare(strings.TrimPrefix(sortValue, "-"), a, b)
+			if strings.HasPrefix(sortValue, "-") {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+
+		return tiebreak(a, b)
+	})
+}
+
+// paginate returns the page of records that filters asks for, with the
+// metadata the SQL models would compute. Like them it reports no records
+// when the page is past the end.
+func paginate[T any](records []T, filters Filters) ([]T, Metadata) {
+	start := min(filters.offset(), len(records))
+	end := min(start+filters.limit(), len(records))
+	page := records[start:end]
+
+	if len(page) == 0 {
+		return page, calculateMetadata(0, filters.Page, filters.PageSize)
+	}
+
+	return page, calculateMetadata(len(records), filters.Page, filters.PageSize)
+}
+
+// searchWords splits s into lowercase words the way PostgreSQL's simple
+// text search configuration does.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case !a:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// addNames appends the names that aren't in names yet, as inserting a
+// film's relations with ON CONFLICT DO NOTHING would.
+func addNames(names []string, added ...string) []string {
+	for _, name := range added {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (stored *memoryFilm) toFilm() *Film {
+	film := stored.film
+
+	film.Genres = make([]Genre, len(stored.genres))
+	for i, genre := range stored.genres {
+		film.Genres[i] = Genre{Name: genre}
+	}
+
+	film.Actors = make([]Actor, len(stored.actors))
+	for i, actor := range stored.actors {
+		film.Actors[i] = Actor{Name: actor}
+	}
+
+	film.Directors = make([]Director, len(stored.directors))
+	for i, director := range stored.directors {
+		film.Directors[i] = Director{Name: director}
+	}
+
+	return &film
+}
+
+func (stored *memoryFilm) addRelations(film *Film) {
+	for _, genre := range film.Genres {
+		stored.genres = addNames(stored.genres, genre.Name)
+	}
+
+	for _, actor := range film.Actors {
+		stored.actors = addNames(stored.actors, actor.Name)
+	}
+
+	for _, director := range film.Directors {
+		stored.directors = addNames(stored.directors, director.Name)
+	}
+}
+
+// matches reports whether the film passes GetAll's filters.
+func (stored *memoryFilm) matches(title string, genres []string, actors []string, directors []string) bool {
+	if title != "" {
+		words := searchWords(title)
+		if len(words) == 0 {
+			return false
+		}
+
+		titleWords := searchWords(stored.film.Title)
+		for _, word := range words {
+			if !slices.Contains(titleWords, word) {
+				return false
+			}
+		}
+	}
+
+	anyOf := func(have []string, want []string) bool {
+		if len(want) == 0 {
+			return true
+		}
+
+		for _, name := range want {
+			if slices.Contains(have, name) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return anyOf(stored.genres, genres) && anyOf(stored.actors, actors) && anyOf(stored.directors, directors)
+}
+
+type memoryFilms struct {
+	store *MemoryStore
+	audit *AuditEvent
+}
+
+func (films memoryFilms) WithAudit(event *AuditEvent) FilmRepository {
+	films.audit = event
+	return films
+}
+
+func (films memoryFilms) Get(ctx context.Context, id int64) (*Film, error) {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	stored, ok := films.store.films[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return stored.toFilm(), nil
+}
+
+func (films memoryFilms) GetAll(ctx context.Context, title string, genres []string, actors []string, directors []string, filters Filters) ([]*Film, Metadata, error) {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	matched := []*memoryFilm{}
+	for _, stored := range films.store.films {
+		if stored.matches(title, genres, actors, directors) {
+			matched = append(matched, stored)
+		}
+	}
+
+	sortRecords(matched, filters, func(column string, a, b *memoryFilm) int {
+		switch column {
+		case "title":
+			return strings.Compare(a.film.Title, b.film.Title)
+		case "year":
+			return cmp.Compare(a.film.Year, b.film.Year)
+		case "runtime":
+			return cmp.Compare(a.film.Runtime, b.film.Runtime)
+		case "rating":
+			return cmp.Compare(a.film.Rating, b.film.Rating)
+		default:
+			return cmp.Compare(a.film.ID, b.film.ID)
+		}
+	}, func(a, b *memoryFilm) int {
+		return cmp.Compare(a.film.ID, b.film.ID)
+	})
+
+	page, metadata := paginate(matched, filters)
+
+	result := make([]*Film, len(page))
+	for i, stored := range page {
+		result[i] = stored.toFilm()
+	}
+
+	return result, metadata, nil
+}
+
+func (films memoryFilms) Insert(ctx context.Context, film *Film) error {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	films.store.lastFilmID++
+
+	stored := &memoryFilm{film: *film}
+	stored.film.ID = films.store.lastFilmID
+	stored.film.Version = 1
+	stored.film.Genres, stored.film.Actors, stored.film.Directors = nil, nil, nil
+	stored.addRelations(film)
+
+	film.ID = stored.film.ID
+
+	if err := films.store.recordAudit(films.audit, film.ID, film); err != nil {
+		return err
+	}
+
+	if err := films.store.publishWebhookEvent(EventFilmCreated, nil, film); err != nil {
+		return err
+	}
+
+	films.store.films[film.ID] = stored
+	return nil
+}
+
+func (films memoryFilms) Update(ctx context.Context, film *Film) error {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	stored, ok := films.store.films[film.ID]
+	if !ok || stored.film.Version != film.Version {
+		return ErrEditConflict
+	}
+
+	if err := films.store.recordAudit(films.audit, film.ID, film); err != nil {
+		return err
+	}
+
+	stored.film.Title = film.Title
+	stored.film.Year = film.Year
+	stored.film.Runtime = film.Runtime
+	stored.film.Rating = film.Rating
+	stored.film.Description = film.Description
+	stored.film.Img = film.Img
+	stored.film.Version++
+	stored.addRelations(film)
+
+	film.Version = stored.film.Version
+	return films.store.publishWebhookEvent(EventFilmUpdated, nil, film)
+}
+
+func (films memoryFilms) Delete(ctx context.Context, film *Film) error {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	id := film.ID
+
+	stored, ok := films.store.films[id]
+	if !ok || stored.film.Version != film.Version {
+		return ErrEditConflict
+	}
+
+	if err := films.store.recordAudit(films.audit, id, nil); err != nil {
+		return err
+	}
+
+	if err := films.store.publishWebhookEvent(EventFilmDeleted, nil, map[string]int64{"id": id}); err != nil {
+		return err
+	}
+
+	delete(films.store.films, id)
+
+	for entryID, entry := range films.store.watchlist {
+		if entry.FilmID == id {
+			delete(films.store.watchlist, entryID)
+		}
+	}
+
+	return nil
+}
+
+func (films memoryFilms) Count(ctx context.Context) (int, error) {
+	films.store.mu.Lock()
+	defer films.store.mu.Unlock()
+
+	return len(films.store.films), nil
+}
+
+// toUser returns a copy of the stored user, without a plaintext password
+// as one read from the database wouldn't have.
+func (stored *memoryUser) toUser() *User {
+	user := stored.user
+	user.Password.plaintext = nil
+	return &user
+}
+
+// emailTaken reports whether a user other than exceptID has the email. Like
+// the citext column it ignores case.
+func (store *MemoryStore) emailTaken(email string, exceptID int64) bool {
+	for id, stored := range store.users {
+		if id != exceptID && strings.EqualFold(stored.user.Email, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type memoryUsers struct {
+	store *MemoryStore
+	audit *AuditEvent
+}
+
+func (users memoryUsers) WithAudit(event *AuditEvent) UserRepository {
+	users.audit = event
+	return users
+}
+
+func (users memoryUsers) Insert(ctx context.Context, user *User) error {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	if users.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	users.store.lastUserID++
+
+	user.ID = users.store.lastUserID
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	stored := &memoryUser{user: *user}
+	if user.Activated {
+		stored.activatedAt = &user.CreatedAt
+	}
+
+	users.store.users[user.ID] = stored
+	return nil
+}
+
+func (users memoryUsers) Get(ctx context.Context, id int64) (*User, error) {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	stored, ok := users.store.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return stored.toUser(), nil
+}
+
+func (users memoryUsers) GetAll(ctx context.Context, search string, permission string, filters Filters) ([]*User, Metadata, error) {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	search = strings.ToLower(search)
+
+	matched := []*memoryUser{}
+	for id, stored := range users.store.users {
+		if search != "" &&
+			!strings.Contains(strings.ToLower(stored.user.Name), search) &&
+			!strings.Contains(strings.ToLower(stored.user.Email), search) {
+			continue
+		}
+
+		if permission != "" && !slices.Contains(users.store.permissions[id], permission) {
+			continue
+		}
+
+		matched = append(matched, stored)
+	}
+
+	sortRecords(matched, filters, func(column string, a, b *memoryUser) int {
+		switch column {
+		case "name":
+			return strings.Compare(a.user.Name, b.user.Name)
+		case "email":
+			return strings.Compare(strings.ToLower(a.user.Email), strings.ToLower(b.user.Email))
+		case "created_at":
+			return a.user.CreatedAt.Compare(b.user.CreatedAt)
+		default:
+			return cmp.Compare(a.user.ID, b.user.ID)
+		}
+	}, func(a, b *memoryUser) int {
+		return cmp.Compare(a.user.ID, b.user.ID)
+	})
+
+	page, metadata := paginate(matched, filters)
+
+	result := make([]*User, len(page))
+	for i, stored := range page {
+		result[i] = stored.toUser()
+	}
+
+	return result, metadata, nil
+}
+
+func (users memoryUsers) GetByEmail(ctx context.Context, email string) (*User, error) {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	for _, stored := range users.store.users {
+		if strings.EqualFold(stored.user.Email, email) {
+			return stored.toUser(), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (users memoryUsers) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	now := time.Now()
+
+	for _, token := range users.store.tokens {
+		if bytes.Equal(token.Hash, tokenHash[:]) && token.Scope == tokenScope && token.Expiry.After(now) {
+			if stored, ok := users.store.users[token.UserId]; ok {
+				return stored.toUser(), nil
+			}
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (users memoryUsers) Update(ctx context.Context, user *User) error {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	stored, ok := users.store.users[user.ID]
+	if !ok || stored.user.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if users.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	if err := users.store.recordAudit(users.audit, user.ID, user); err != nil {
+		return err
+	}
+
+	if user.Activated && stored.activatedAt == nil {
+		now := time.Now()
+		stored.activatedAt = &now
+	}
+
+	stored.user.Name = user.Name
+	stored.user.Email = user.Email
+	stored.user.Password.hash = user.Password.hash
+	stored.user.Activated = user.Activated
+	stored.user.Suspended = user.Suspended
+	stored.user.Version++
+
+	user.Version = stored.user.Version
+	return nil
+}
+
+func (users memoryUsers) Delete(ctx context.Context, user *User) error {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	stored, ok := users.store.users[user.ID]
+	if !ok || stored.user.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if err := users.store.recordAudit(users.audit, user.ID, nil); err != nil {
+		return err
+	}
+
+	users.store.deleteUser(user.ID)
+	return nil
+}
+
+func (users memoryUsers) DeleteUnactivated(ctx context.Context, cutoff time.Time) (int64, error) {
+	users.store.mu.Lock()
+	defer users.store.mu.Unlock()
+
+	var deleted int64
+	for id, stored := range users.store.users {
+		if !stored.user.Activated && stored.activatedAt == nil && stored.user.CreatedAt.Before(cutoff) {
+			users.store.deleteUser(id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteUser removes the user along with their tokens, permissions,
+// watchlist, API keys, identities, authenticator and webhooks. The caller
+// must hold the lock.
+func (store *MemoryStore) deleteUser(id int64) {
+	delete(store.users, id)
+	delete(store.permissions, id)
+	delete(store.totp, id)
+
+	store.tokens = slices.DeleteFunc(store.tokens, func(token *Token) bool {
+		return token.UserId == id
+	})
+
+	for entryID, entry := range store.watchlist {
+		if entry.UserID == id {
+			delete(store.watchlist, entryID)
+		}
+	}
+
+	for keyID, key := range store.apiKeys {
+		if key.UserID == id {
+			delete(store.apiKeys, keyID)
+		}
+	}
+
+	store.identities = slices.DeleteFunc(store.identities, func(identity *Identity) bool {
+		return identity.UserID == id
+	})
+
+	for webhookID, webhook := range store.webhooks {
+		if webhook.UserID == id {
+			store.deleteWebhook(webhookID)
+		}
+	}
+}
+
+type memoryTokens struct {
+	store *MemoryStore
+}
+
+func (tokens memoryTokens) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tokens.Insert(ctx, token)
+	return token, err
+}
+
+func (tokens memoryTokens) Insert(ctx context.Context, token *Token) error {
+	tokens.store.mu.Lock()
+	defer tokens.store.mu.Unlock()
+
+	if _, ok := tokens.store.users[token.UserId]; !ok {
+		return fmt.Errorf("token for user %d: user does not exist", token.UserId)
+	}
+
+	stored := *token
+	stored.Plaintext = ""
+	tokens.store.tokens = append(tokens.store.tokens, &stored)
+
+	return nil
+}
+
+func (tokens memoryTokens) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	tokens.store.mu.Lock()
+	defer tokens.store.mu.Unlock()
+
+	tokens.store.tokens = slices.DeleteFunc(tokens.store.tokens, func(token *Token) bool {
+		return token.UserId == userID && token.Scope == scope
+	})
+
+	return nil
+}
+
+func (tokens memoryTokens) DeleteExpired(ctx context.Context) (int64, error) {
+	tokens.store.mu.Lock()
+	defer tokens.store.mu.Unlock()
+
+	now := time.Now()
+	before := len(tokens.store.tokens)
+
+	tokens.store.tokens = slices.DeleteFunc(tokens.store.tokens, func(token *Token) bool {
+		return token.Expiry.Before(now)
+	})
+
+	return int64(before - len(tokens.store.tokens)), nil
+}
+
+type memoryPermissions struct {
+	store *MemoryStore
+	audit *AuditEvent
+}
+
+func (permissions memoryPermissions) WithAudit(event *AuditEvent) PermissionRepository {
+	permissions.audit = event
+	return permissions
+}
+
+func (permissions memoryPermissions) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	permissions.store.mu.Lock()
+	defer permissions.store.mu.Unlock()
+
+	if len(permissions.store.permissions[userID]) == 0 {
+		return nil, nil
+	}
+
+	return slices.Clone(Permissions(permissions.store.permissions[userID])), nil
+}
+
+func (permissions memoryPermissions) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	permissions.store.mu.Lock()
+	defer permissions.store.mu.Unlock()
+
+	held := permissions.store.permissions[userID]
+
+	granted := Permissions{}
+	for _, code := range memoryPermissionCodes {
+		if slices.Contains(codes, code) && !slices.Contains(held, code) {
+			granted = append(granted, code)
+		}
+	}
+
+	if len(granted) == 0 {
+		return nil
+	}
+
+	if _, ok := permissions.store.users[userID]; !ok {
+		return fmt.Errorf("permissions for user %d: user does not exist", userID)
+	}
+
+	if err := permissions.store.recordAudit(permissions.audit, userID, map[string]any{"granted": granted}); err != nil {
+		return err
+	}
+
+	permissions.store.permissions[userID] = append(held, granted...)
+	return nil
+}
+
+type memoryWatchlist struct {
+	store *MemoryStore
+}
+
+// withFilm returns a copy of the entry with its film's details, as the
+// join in the SQL model adds them. The caller must hold the lock.
+func (watchlist memoryWatchlist) withFilm(entry *Watchlist) *Watchlist {
+	result := *entry
+	result.Film = watchlist.store.films[entry.FilmID].toFilm()
+	return &result
+}
+
+func (watchlist memoryWatchlist) Insert(ctx context.Context, entry *Watchlist) error {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	if _, ok := watchlist.store.users[entry.UserID]; !ok {
+		return fmt.Errorf("watchlist entry for user %d: user does not exist", entry.UserID)
+	}
+
+	if _, ok := watchlist.store.films[entry.FilmID]; !ok {
+		return fmt.Errorf("watchlist entry for film %d: film does not exist", entry.FilmID)
+	}
+
+	for _, existing := range watchlist.store.watchlist {
+		if existing.UserID == entry.UserID && existing.FilmID == entry.FilmID {
+			return ErrDuplicateWatchlistEntry
+		}
+	}
+
+	watchlist.store.lastWatchlistID++
+
+	entry.ID = watchlist.store.lastWatchlistID
+	entry.AddedAt = time.Now()
+	entry.Version = 1
+
+	stored := *entry
+	stored.Film = nil
+	watchlist.store.watchlist[entry.ID] = &stored
+
+	return nil
+}
+
+func (watchlist memoryWatchlist) Get(ctx context.Context, userID, entryID int64) (*Watchlist, error) {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	entry, ok := watchlist.store.watchlist[entryID]
+	if !ok || entry.UserID != userID {
+		return nil, ErrRecordNotFound
+	}
+
+	return watchlist.withFilm(entry), nil
+}
+
+func (watchlist memoryWatchlist) GetAll(ctx context.Context, userID int64, watched *bool, priority int, filters Filters) ([]*Watchlist, Metadata, error) {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	matched := []*Watchlist{}
+	for _, entry := range watchlist.store.watchlist {
+		if entry.UserID != userID {
+			continue
+		}
+
+		if watched != nil && entry.Watched != *watched {
+			continue
+		}
+
+		if priority > 0 && entry.Priority != priority {
+			continue
+		}
+
+		matched = append(matched, entry)
+	}
+
+	sortRecords(matched, filters, func(column string, a, b *Watchlist) int {
+		switch column {
+		case "added_at":
+			return a.AddedAt.Compare(b.AddedAt)
+		case "priority":
+			return cmp.Compare(a.Priority, b.Priority)
+		case "watched":
+			return compareBool(a.Watched, b.Watched)
+		default:
+			return cmp.Compare(a.ID, b.ID)
+		}
+	}, func(a, b *Watchlist) int {
+		// added_at DESC, with the ID breaking ties between entries added
+		// within the clock's resolution.
+		if c := b.AddedAt.Compare(a.AddedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	page, metadata := paginate(matched, filters)
+
+	result := make([]*Watchlist, len(page))
+	for i, entry := range page {
+		result[i] = watchlist.withFilm(entry)
+	}
+
+	return result, metadata, nil
+}
+
+func (watchlist memoryWatchlist) Update(ctx context.Context, entry *Watchlist) error {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	stored, ok := watchlist.store.watchlist[entry.ID]
+	if !ok || stored.UserID != entry.UserID || stored.Version != entry.Version {
+		return ErrEditConflict
+	}
+
+	wasWatched := stored.Watched
+
+	stored.Notes = entry.Notes
+	stored.Priority = entry.Priority
+	stored.Watched = entry.Watched
+	stored.WatchedAt = entry.WatchedAt
+	stored.Rating = entry.Rating
+	stored.Version++
+
+	entry.Version = stored.Version
+
+	if entry.Watched && !wasWatched {
+		return watchlist.store.publishWebhookEvent(EventWatchlistWatched, &entry.UserID, entry)
+	}
+
+	return nil
+}
+
+func (watchlist memoryWatchlist) Delete(ctx context.Context, entry *Watchlist) error {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	stored, ok := watchlist.store.watchlist[entry.ID]
+	if !ok || stored.UserID != entry.UserID || stored.Version != entry.Version {
+		return ErrEditConflict
+	}
+
+	delete(watchlist.store.watchlist, entry.ID)
+	return nil
+}
+
+func (watchlist memoryWatchlist) CheckExists(ctx context.Context, userID, filmID int64) (bool, error) {
+	watchlist.store.mu.Lock()
+	defer watchlist.store.mu.Unlock()
+
+	for _, entry := range watchlist.store.watchlist {
+		if entry.UserID == userID && entry.FilmID == filmID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+type memoryAudit struct {
+	store *MemoryStore
+}
+
+func (audit memoryAudit) Insert(ctx context.Context, event *AuditEvent, resourceID int64) error {
+	audit.store.mu.Lock()
+	defer audit.store.mu.Unlock()
+
+	return audit.store.recordAudit(event, resourceID, nil)
+}
+
+func (audit memoryAudit) GetAll(ctx context.Context, actorID int64, action, resourceType string, resourceID int64, from, to *time.Time, filters Filters) ([]*AuditEvent, Metadata, error) {
+	audit.store.mu.Lock()
+	defer audit.store.mu.Unlock()
+
+	matched := []*AuditEvent{}
+	for i := range audit.store.audit {
+		event := audit.store.audit[i]
+
+		switch {
+		case actorID != 0 && (event.ActorID == nil || *event.ActorID != actorID),
+			action != "" && event.Action != action,
+			resourceType != "" && event.ResourceType != resourceType,
+			resourceID != 0 && event.ResourceID != resourceID,
+			from != nil && event.CreatedAt.Before(*from),
+			to != nil && !event.CreatedAt.Before(*to):
+			continue
+		}
+
+		matched = append(matched, &event)
+	}
+
+	sortRecords(matched, filters, func(column string, a, b *AuditEvent) int {
+		if column == "created_at" {
+			return a.CreatedAt.Compare(b.CreatedAt)
+		}
+		return cmp.Compare(a.ID, b.ID)
+	}, func(a, b *AuditEvent) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	events, metadata := paginate(matched, filters)
+	return events, metadata, nil
+}
+
+// memorySchema reports a database that's always reachable and migrated to
+// SchemaVersion.
+type memorySchema struct{}
+
+func (memorySchema) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (memorySchema) Version(ctx context.Context) (int64, bool, error) {
+	return SchemaVersion, false, nil
+}
+
+var (
+	_ FilmRepository       = memoryFilms{}
+	_ UserRepository       = memoryUsers{}
+	_ TokenRepository      = memoryTokens{}
+	_ PermissionRepository = memoryPermissions{}
+	_ WatchlistRepository  = memoryWatchlist{}
+	_ AuditRepository      = memoryAudit{}
+	_ SchemaRepository     = memorySchema{}
+)
@@ -0,0 +1,362 @@
+package models
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// memoryTOTP is an authenticator enrolment as it's stored, with the hashes
+// of its recovery codes.
+type memoryTOTP struct {
+	totp          TOTP
+	recoveryCodes []*memoryRecoveryCode
+}
+
+type memoryRecoveryCode struct {
+	hash []byte
+	used bool
+}
+
+// loginKey identifies a row of login_attempts.
+type loginKey struct {
+	scope string
+	key   string
+}
+
+type memoryAPIKeys struct {
+	store *MemoryStore
+}
+
+// toAPIKey returns a copy of the stored key as the SQL model reads one back,
+// without its plaintext or hash.
+func toAPIKey(stored *APIKey) *APIKey {
+	key := *stored
+	key.Plaintext = ""
+	key.Hash = nil
+	key.Permissions = slices.Clone(stored.Permissions)
+	return &key
+}
+
+func (keys memoryAPIKeys) New(ctx context.Context, userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, permissions, expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = keys.Insert(ctx, key)
+	return key, err
+}
+
+func (keys memoryAPIKeys) Insert(ctx context.Context, key *APIKey) error {
+	keys.store.mu.Lock()
+	defer keys.store.mu.Unlock()
+
+	if _, ok := keys.store.users[key.UserID]; !ok {
+		return fmt.Errorf("api key for user %d: user does not exist", key.UserID)
+	}
+
+	for _, existing := range keys.store.apiKeys {
+		if existing.UserID == key.UserID && existing.Name == key.Name {
+			return ErrDuplicateAPIKeyName
+		}
+	}
+
+	keys.store.lastAPIKeyID++
+
+	key.ID = keys.store.lastAPIKeyID
+	key.CreatedAt = time.Now()
+
+	stored := *key
+	stored.Plaintext = ""
+	stored.Permissions = slices.Clone(key.Permissions)
+	keys.store.apiKeys[key.ID] = &stored
+
+	return nil
+}
+
+func (keys memoryAPIKeys) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	keys.store.mu.Lock()
+	defer keys.store.mu.Unlock()
+
+	result := []*APIKey{}
+	for _, stored := range keys.store.apiKeys {
+		if stored.UserID == userID {
+			result = append(result, toAPIKey(stored))
+		}
+	}
+
+	slices.SortFunc(result, func(a, b *APIKey) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return result, nil
+}
+
+func (keys memoryAPIKeys) Delete(ctx context.Context, userID, keyID int64) error {
+	keys.store.mu.Lock()
+	defer keys.store.mu.Unlock()
+
+	stored, ok := keys.store.apiKeys[keyID]
+	if !ok || stored.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(keys.store.apiKeys, keyID)
+	return nil
+}
+
+func (keys memoryAPIKeys) GetForKey(ctx context.Context, keyPlaintext string) (*User, *APIKey, error) {
+	keys.store.mu.Lock()
+	defer keys.store.mu.Unlock()
+
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+	now := time.Now()
+
+	for _, stored := range keys.store.apiKeys {
+		if !bytes.Equal(stored.Hash, keyHash[:]) || (stored.Expiry != nil && !stored.Expiry.After(now)) {
+			continue
+		}
+
+		owner, ok := keys.store.users[stored.UserID]
+		if !ok {
+			break
+		}
+
+		if stored.LastUsedAt == nil || stored.LastUsedAt.Before(now.Add(-apiKeyLastUsedPrecision)) {
+			stored.LastUsedAt = &now
+		}
+
+		return owner.toUser(), toAPIKey(stored), nil
+	}
+
+	return nil, nil, ErrRecordNotFound
+}
+
+type memoryIdentities struct {
+	store *MemoryStore
+}
+
+func (identities memoryIdentities) Insert(ctx context.Context, identity *Identity) error {
+	identities.store.mu.Lock()
+	defer identities.store.mu.Unlock()
+
+	if _, ok := identities.store.users[identity.UserID]; !ok {
+		return fmt.Errorf("identity for user %d: user does not exist", identity.UserID)
+	}
+
+	for _, existing := range identities.store.identities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return ErrDuplicateIdentity
+		}
+	}
+
+	identities.store.lastIdentityID++
+
+	identity.ID = identities.store.lastIdentityID
+	identity.CreatedAt = time.Now()
+
+	stored := *identity
+	identities.store.identities = append(identities.store.identities, &stored)
+
+	return nil
+}
+
+func (identities memoryIdentities) GetUser(ctx context.Context, issuer, subject string) (*User, error) {
+	identities.store.mu.Lock()
+	defer identities.store.mu.Unlock()
+
+	for _, identity := range identities.store.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			if stored, ok := identities.store.users[identity.UserID]; ok {
+				return stored.toUser(), nil
+			}
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+type memoryTOTPs struct {
+	store *MemoryStore
+}
+
+func (totps memoryTOTPs) Get(ctx context.Context, userID int64) (*TOTP, error) {
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	stored, ok := totps.store.totp[userID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	t := stored.totp
+	return &t, nil
+}
+
+func (totps memoryTOTPs) Begin(ctx context.Context, userID int64, secret string) error {
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	if _, ok := totps.store.users[userID]; !ok {
+		return fmt.Errorf("totp for user %d: user does not exist", userID)
+	}
+
+	stored, ok := totps.store.totp[userID]
+	if ok && stored.totp.Enabled() {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	if !ok {
+		stored = &memoryTOTP{}
+		totps.store.totp[userID] = stored
+	}
+
+	stored.totp = TOTP{UserID: userID, Secret: secret}
+	return nil
+}
+
+func (totps memoryTOTPs) Confirm(ctx context.Context, userID int64, step int64) ([]string, error) {
+	codes, err := generateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	stored, ok := totps.store.totp[userID]
+	if !ok || stored.totp.Enabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	now := time.Now()
+	stored.totp.ConfirmedAt = &now
+	stored.totp.LastStep = step
+
+	stored.recoveryCodes = make([]*memoryRecoveryCode, len(codes))
+	for i, code := range codes {
+		stored.recoveryCodes[i] = &memoryRecoveryCode{hash: hashRecoveryCode(code)}
+	}
+
+	return codes, nil
+}
+
+func (totps memoryTOTPs) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	stored, ok := totps.store.totp[userID]
+	if !ok || stored.totp.LastStep >= step {
+		return false, nil
+	}
+
+	stored.totp.LastStep = step
+	return true, nil
+}
+
+func (totps memoryTOTPs) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	stored, ok := totps.store.totp[userID]
+	if !ok {
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for _, recoveryCode := range stored.recoveryCodes {
+		if !recoveryCode.used && bytes.Equal(recoveryCode.hash, hash) {
+			recoveryCode.used = true
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (totps memoryTOTPs) Delete(ctx context.Context, userID int64) error {
+	totps.store.mu.Lock()
+	defer totps.store.mu.Unlock()
+
+	delete(totps.store.totp, userID)
+	return nil
+}
+
+type memoryLogins struct {
+	store *MemoryStore
+}
+
+func (logins memoryLogins) Get(ctx context.Context, scope, key string) (*LoginAttempt, error) {
+	logins.store.mu.Lock()
+	defer logins.store.mu.Unlock()
+
+	stored, ok := logins.store.logins[loginKey{scope, key}]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	attempt := *stored
+	return &attempt, nil
+}
+
+func (logins memoryLogins) RecordFailure(ctx context.Context, scope, key string, policy LockoutPolicy) (*LoginAttempt, error) {
+	logins.store.mu.Lock()
+	defer logins.store.mu.Unlock()
+
+	now := time.Now()
+
+	stored, ok := logins.store.logins[loginKey{scope, key}]
+	switch {
+	case !ok:
+		stored = &LoginAttempt{Scope: scope, Key: key, Failures: 1}
+		logins.store.logins[loginKey{scope, key}] = stored
+	case stored.LastFailedAt.Before(now.Add(-policy.Window)):
+		stored.Failures = 1
+	default:
+		stored.Failures++
+	}
+
+	lockedUntil := now.Add(policy.Delay(stored.Failures))
+	stored.LastFailedAt = now
+	stored.LockedUntil = &lockedUntil
+
+	attempt := *stored
+	return &attempt, nil
+}
+
+func (logins memoryLogins) Reset(ctx context.Context, scope, key string) error {
+	logins.store.mu.Lock()
+	defer logins.store.mu.Unlock()
+
+	delete(logins.store.logins, loginKey{scope, key})
+	return nil
+}
+
+func (logins memoryLogins) DeleteStale(ctx context.Context, cutoff time.Time) (int64, error) {
+	logins.store.mu.Lock()
+	defer logins.store.mu.Unlock()
+
+	now := time.Now()
+
+	var deleted int64
+	for key, attempt := range logins.store.logins {
+		if attempt.LastFailedAt.Before(cutoff) && (attempt.LockedUntil == nil || attempt.LockedUntil.Before(now)) {
+			delete(logins.store.logins, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+var (
+	_ APIKeyRepository       = memoryAPIKeys{}
+	_ IdentityRepository     = memoryIdentities{}
+	_ TOTPRepository         = memoryTOTPs{}
+	_ LoginAttemptRepository = memoryLogins{}
+)
@@ -0,0 +1,589 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestMemoryFilmsGetAll tests filtering, sorting and paginating films in memory
+func TestMemoryFilmsGetAll(t *testing.T) {
+	ctx := context.Background()
+	films := NewMemoryStore().Models().Films
+
+	for _, film := range []*Film{
+		{Title: "The Dark Knight", Year: 2008, Genres: []Genre{{Name: "action"}}, Directors: []Director{{Name: "Christopher Nolan"}}},
+		{Title: "Inception", Year: 2010, Genres: []Genre{{Name: "sci-fi"}}, Directors: []Director{{Name: "Christopher Nolan"}}},
+		{Title: "The Matrix", Year: 1999, Genres: []Genre{{Name: "sci-fi"}, {Name: "action"}}},
+		{Title: "Knight and Day", Year: 2010, Genres: []Genre{{Name: "comedy"}}},
+	} {
+		if err := films.Insert(ctx, film); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		title     string
+		genres    []string
+		directors []string
+		sort      []string
+		page      int
+		pageSize  int
+		want      []string
+		wantTotal int
+	}{
+		{name: "All", want: []string{"The Dark Knight", "Inception", "The Matrix", "Knight and Day"}, wantTotal: 4},
+		{name: "Title words", title: "KNIGHT the", want: []string{"The Dark Knight"}, wantTotal: 1},
+		{name: "Blank title", title: "  ", want: []string{}},
+		{name: "Any genre", genres: []string{"comedy", "action"}, want: []string{"The Dark Knight", "The Matrix", "Knight and Day"}, wantTotal: 3},
+		{name: "Director", directors: []string{"Christopher Nolan"}, sort: []string{"-year"}, want: []string{"Inception", "The Dark Knight"}, wantTotal: 2},
+		{name: "Sorted with ties", sort: []string{"-year", "title"}, want: []string{"Inception", "Knight and Day", "The Dark Knight", "The Matrix"}, wantTotal: 4},
+		{name: "Second page", sort: []string{"title"}, page: 2, pageSize: 3, want: []string{"The Matrix"}, wantTotal: 4},
+		{name: "Past the end", page: 3, pageSize: 3, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filters := Filters{
+				Page:         1,
+				PageSize:     20,
+				SortValues:   tt.sort,
+				SortSafelist: []string{"id", "title", "year", "-id", "-title", "-year"},
+			}
+			if tt.page != 0 {
+				filters.Page, filters.PageSize = tt.page, tt.pageSize
+			}
+
+			got, metadata, err := films.GetAll(ctx, tt.title, tt.genres, nil, tt.directors, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			titles := []string{}
+			for _, film := range got {
+				titles = append(titles, film.Title)
+			}
+
+			if fmt.Sprint(titles) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", titles, tt.want)
+			}
+
+			if metadata.TotalRecords != tt.wantTotal {
+				t.Errorf("got %d total records, want %d", metadata.TotalRecords, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// TestMemoryFilmsUpdate tests optimistic locking and relations of films in memory
+func TestMemoryFilmsUpdate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	films := store.Models().Films
+
+	film := &Film{Title: "Inception", Year: 2010, Genres: []Genre{{Name: "sci-fi"}}}
+	if err := films.Insert(ctx, film); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := films.Get(ctx, film.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := films.Get(ctx, film.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fresh.Title = "Inception (2010)"
+	fresh.Genres = []Genre{{Name: "thriller"}}
+	event := &AuditEvent{Action: "film.update", ResourceType: "film"}
+	if err := films.WithAudit(event).Update(ctx, fresh); err != nil {
+		t.Fatal(err)
+	}
+
+	if fresh.Version != 2 {
+		t.Errorf("got version %d, want 2", fresh.Version)
+	}
+
+	if err := films.Update(ctx, stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a stale copy: got %v, want %v", err, ErrEditConflict)
+	}
+
+	got, err := films.Get(ctx, film.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Title != "Inception (2010)" || len(got.Genres) != 2 {
+		t.Errorf("got %q with genres %v, want the new title with both genres", got.Title, got.Genres)
+	}
+
+	if events := store.AuditEvents(); len(events) != 1 || events[0].ResourceID != film.ID {
+		t.Errorf("got audit events %+v, want one for film %d", events, film.ID)
+	}
+}
+
+// TestMemoryUsers tests duplicate emails, tokens and deleting users in memory
+func TestMemoryUsers(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore().Models()
+
+	alice := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := m.Users.Insert(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Users.Insert(ctx, &User{Name: "Alice", Email: "ALICE@example.com"}); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("inserting a duplicate email: got %v, want %v", err, ErrDuplicateEmail)
+	}
+
+	bob := &User{Name: "Bob", Email: "bob@example.com"}
+	if err := m.Users.Insert(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.Email = "Alice@Example.com"
+	if err := m.Users.Update(ctx, bob); !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("updating to a duplicate email: got %v, want %v", err, ErrDuplicateEmail)
+	}
+
+	token, err := m.Tokens.New(ctx, alice.ID, time.Hour, ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Tokens.New(ctx, alice.ID, -time.Hour, ScopeAuthentication); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := m.Users.GetForToken(ctx, ScopeAuthentication, token.Plaintext); err != nil || got.ID != alice.ID {
+		t.Errorf("got user %v and error %v for the token, want Alice", got, err)
+	}
+
+	if _, err := m.Users.GetForToken(ctx, ScopeActivation, token.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up the token in another scope: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	if deleted, err := m.Tokens.DeleteExpired(ctx); err != nil || deleted != 1 {
+		t.Errorf("got %d expired tokens deleted and error %v, want 1", deleted, err)
+	}
+
+	if err := m.Permissions.AddForUser(ctx, alice.ID, "films:read", "films:unknown"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _, err := m.Users.GetAll(ctx, "ALI", "films:read", Filters{Page: 1, PageSize: 20}); err != nil || len(got) != 1 {
+		t.Errorf("got users %v and error %v searching for readers, want Alice", got, err)
+	}
+
+	stale := *alice
+	alice.Name = "Alice Smith"
+	if err := m.Users.Update(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Users.Delete(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("deleting a stale copy: got %v, want %v", err, ErrEditConflict)
+	}
+
+	if err := m.Users.Delete(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Users.GetForToken(ctx, ScopeAuthentication, token.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up a deleted user's token: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	if permissions, err := m.Permissions.GetAllForUser(ctx, alice.ID); err != nil || len(permissions) != 0 {
+		t.Errorf("got permissions %v and error %v for a deleted user, want none", permissions, err)
+	}
+
+	if deleted, err := m.Users.DeleteUnactivated(ctx, time.Now().Add(time.Minute)); err != nil || deleted != 1 {
+		t.Errorf("got %d unactivated users deleted and error %v, want 1", deleted, err)
+	}
+}
+
+// TestMemoryWatchlist tests duplicate entries and optimistic locking of watchlists in memory
+func TestMemoryWatchlist(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore().Models()
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	film := &Film{Title: "Inception", Year: 2010}
+	if err := m.Films.Insert(ctx, film); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &Watchlist{UserID: user.ID, FilmID: film.ID, Priority: 5}
+	if err := m.Watchlist.Insert(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Watchlist.Insert(ctx, &Watchlist{UserID: user.ID, FilmID: film.ID, Priority: 1}); !errors.Is(err, ErrDuplicateWatchlistEntry) {
+		t.Errorf("inserting a duplicate entry: got %v, want %v", err, ErrDuplicateWatchlistEntry)
+	}
+
+	if _, err := m.Watchlist.Get(ctx, user.ID+1, entry.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("getting another user's entry: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	stale := *entry
+	entry.Watched = true
+	if err := m.Watchlist.Update(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Watchlist.Update(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a stale copy: got %v, want %v", err, ErrEditConflict)
+	}
+
+	watched := true
+	got, _, err := m.Watchlist.GetAll(ctx, user.ID, &watched, 0, Filters{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].Film == nil || got[0].Film.Title != "Inception" {
+		t.Errorf("got %+v, want the watched entry with its film", got)
+	}
+
+	film, err = m.Films.Get(ctx, film.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Films.Delete(ctx, &Film{ID: film.ID, Version: film.Version + 1}); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("deleting a film at another version: got %v, want %v", err, ErrEditConflict)
+	}
+
+	if err := m.Watchlist.Delete(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("deleting a stale entry: got %v, want %v", err, ErrEditConflict)
+	}
+
+	if err := m.Films.Delete(ctx, film); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, err := m.Watchlist.CheckExists(ctx, user.ID, film.ID); err != nil || exists {
+		t.Errorf("got exists %v and error %v after deleting the film, want the entry gone", exists, err)
+	}
+
+	if err := m.Watchlist.Delete(ctx, entry); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("deleting a removed entry: got %v, want %v", err, ErrEditConflict)
+	}
+}
+
+// TestMemoryAPIKeys tests looking up, expiring and cascading API keys and identities in memory
+func TestMemoryAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	m := store.Models()
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := m.APIKeys.New(ctx, user.ID, "ci", Permissions{"films:read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.APIKeys.New(ctx, user.ID, "ci", nil, nil); !errors.Is(err, ErrDuplicateAPIKeyName) {
+		t.Errorf("inserting a duplicate name: got %v, want %v", err, ErrDuplicateAPIKeyName)
+	}
+
+	expiry := time.Now().Add(-time.Minute)
+	expired, err := m.APIKeys.New(ctx, user.ID, "old", nil, &expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owner, found, err := m.APIKeys.GetForKey(ctx, key.Plaintext)
+	if err != nil || owner.ID != user.ID || found.LastUsedAt == nil || !found.Permissions.Include("films:read") {
+		t.Errorf("got user %v, key %+v and error %v, want Alice's used key", owner, found, err)
+	}
+
+	// A key used again within a minute keeps its timestamp.
+	if _, again, err := m.APIKeys.GetForKey(ctx, key.Plaintext); err != nil || !again.LastUsedAt.Equal(*found.LastUsedAt) {
+		t.Errorf("got key %+v and error %v, want last_used_at unchanged", again, err)
+	}
+
+	stale := time.Now().Add(-2 * time.Minute)
+	store.apiKeys[key.ID].LastUsedAt = &stale
+
+	if _, again, err := m.APIKeys.GetForKey(ctx, key.Plaintext); err != nil || !again.LastUsedAt.After(stale) {
+		t.Errorf("got key %+v and error %v, want last_used_at refreshed", again, err)
+	}
+
+	if _, _, err := m.APIKeys.GetForKey(ctx, expired.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up an expired key: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	keys, err := m.APIKeys.GetAllForUser(ctx, user.ID)
+	if err != nil || len(keys) != 2 || keys[0].Name != "ci" || keys[0].Hash != nil || keys[0].Plaintext != "" {
+		t.Errorf("got keys %+v and error %v, want both keys without secrets", keys, err)
+	}
+
+	if err := m.APIKeys.Delete(ctx, user.ID+1, key.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("deleting another user's key: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	identity := &Identity{UserID: user.ID, Issuer: "https://accounts.example.com", Subject: "123"}
+	if err := m.Identities.Insert(ctx, identity); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Identities.Insert(ctx, &Identity{UserID: user.ID, Issuer: identity.Issuer, Subject: "123"}); !errors.Is(err, ErrDuplicateIdentity) {
+		t.Errorf("inserting a duplicate identity: got %v, want %v", err, ErrDuplicateIdentity)
+	}
+
+	if got, err := m.Identities.GetUser(ctx, identity.Issuer, "123"); err != nil || got.ID != user.ID {
+		t.Errorf("got user %v and error %v for the identity, want Alice", got, err)
+	}
+
+	if err := m.Users.Delete(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.APIKeys.GetForKey(ctx, key.Plaintext); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up a deleted user's key: got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	if _, err := m.Identities.GetUser(ctx, identity.Issuer, "123"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("looking up a deleted user's identity: got %v, want %v", err, ErrRecordNotFound)
+	}
+}
+
+// TestMemoryTOTPAndLogins tests authenticator enrolment, replay protection and login lockouts in memory
+func TestMemoryTOTPAndLogins(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore().Models()
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.TOTP.Begin(ctx, user.ID, "SECRET"); err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := m.TOTP.Confirm(ctx, user.ID, 10)
+	if err != nil || len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes and error %v, want %d", len(codes), err, RecoveryCodeCount)
+	}
+
+	if err := m.TOTP.Begin(ctx, user.ID, "OTHER"); !errors.Is(err, ErrTOTPAlreadyEnabled) {
+		t.Errorf("beginning again once enabled: got %v, want %v", err, ErrTOTPAlreadyEnabled)
+	}
+
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{{10, false}, {11, true}, {11, false}} {
+		if ok, err := m.TOTP.UseStep(ctx, user.ID, tt.step); err != nil || ok != tt.want {
+			t.Errorf("using step %d: got %v and error %v, want %v", tt.step, ok, err, tt.want)
+		}
+	}
+
+	for _, want := range []bool{true, false} {
+		if ok, err := m.TOTP.UseRecoveryCode(ctx, user.ID, codes[0]); err != nil || ok != want {
+			t.Errorf("using a recovery code: got %v and error %v, want %v", ok, err, want)
+		}
+	}
+
+	policy := LockoutPolicy{Threshold: 2, BaseDelay: time.Second, Lockout: time.Minute, Window: time.Hour}
+
+	var attempt *LoginAttempt
+	for range 3 {
+		if attempt, err = m.Logins.RecordFailure(ctx, "email", "alice@example.com", policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if attempt.Failures != 3 || attempt.LockedUntil == nil || !attempt.LockedUntil.After(time.Now()) {
+		t.Errorf("got %+v after three failures, want a lockout", attempt)
+	}
+
+	if deleted, err := m.Logins.DeleteStale(ctx, time.Now().Add(time.Minute)); err != nil || deleted != 0 {
+		t.Errorf("got %d deleted and error %v, want the locked attempt kept", deleted, err)
+	}
+
+	if err := m.Logins.Reset(ctx, "email", "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Logins.Get(ctx, "email", "alice@example.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("getting a reset attempt: got %v, want %v", err, ErrRecordNotFound)
+	}
+}
+
+// TestMemoryWebhooks tests optimistic locking, redelivery and cascading of webhooks in memory
+func TestMemoryWebhooks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	m := store.Models()
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	if err := m.Users.Insert(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &Webhook{UserID: user.ID, URL: "https://example.com/hook", Events: []string{"film.created"}, Active: true}
+	if err := m.Webhooks.Insert(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Films.Insert(ctx, &Film{Title: "Inception", Year: 2010}); err != nil {
+		t.Fatal(err)
+	}
+
+	var relayed []int64
+	if n, err := m.Webhooks.Relay(ctx, 100, func(ctx context.Context, tx *sql.Tx, id int64) error {
+		relayed = append(relayed, id)
+		return nil
+	}); err != nil || n != 1 || len(relayed) != 1 {
+		t.Fatalf("relayed %d events into deliveries %v with error %v, want one delivery", n, relayed, err)
+	}
+
+	original, _, err := m.Webhooks.GetDelivery(ctx, relayed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale := *webhook
+	webhook.Active = false
+	if err := m.Webhooks.Update(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Webhooks.Update(ctx, &stale); !errors.Is(err, ErrEditConflict) {
+		t.Errorf("updating a stale copy: got %v, want %v", err, ErrEditConflict)
+	}
+
+	if _, err := m.Webhooks.Redeliver(ctx, webhook.ID, original.ID, func(ctx context.Context, tx *sql.Tx, id int64) error {
+		return errors.New("queue unavailable")
+	}); err == nil {
+		t.Error("redelivering with a failing queue succeeded")
+	}
+
+	var enqueued int64
+	copied, err := m.Webhooks.Redeliver(ctx, webhook.ID, original.ID, func(ctx context.Context, tx *sql.Tx, id int64) error {
+		enqueued = id
+		return nil
+	})
+	if err != nil || copied.ID != enqueued || copied.Status != DeliveryPending {
+		t.Errorf("got delivery %+v and error %v, want a pending copy enqueued", copied, err)
+	}
+
+	deliveries, metadata, err := m.Webhooks.GetDeliveries(ctx, webhook.ID, DeliveryPending, Filters{Page: 1, PageSize: 20, SortValues: []string{"-id"}, SortSafelist: []string{"id", "-id"}})
+	if err != nil || metadata.TotalRecords != 2 || deliveries[0].ID != copied.ID {
+		t.Errorf("got deliveries %+v and error %v, want the copy first of two", deliveries, err)
+	}
+
+	if err := m.Users.Delete(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := m.Webhooks.GetDelivery(ctx, copied.ID); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("getting a deleted user's delivery: got %v, want %v", err, ErrRecordNotFound)
+	}
+}
+
+// TestMemoryWebhooksRelay tests that private events are only relayed to their
+// owner's webhooks
+func TestMemoryWebhooksRelay(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore().Models()
+
+	film := &Film{Title: "Inception", Year: 2010}
+	if err := m.Films.Insert(ctx, film); err != nil {
+		t.Fatal(err)
+	}
+
+	events := []string{EventFilmUpdated, EventWatchlistWatched}
+
+	var users []*User
+	var webhooks []*Webhook
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user := &User{Name: "User", Email: email, Activated: true}
+		if err := m.Users.Insert(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+
+		webhook := &Webhook{UserID: user.ID, URL: "https://example.com/hook", Events: events, Active: true}
+		if err := m.Webhooks.Insert(ctx, webhook); err != nil {
+			t.Fatal(err)
+		}
+
+		users = append(users, user)
+		webhooks = append(webhooks, webhook)
+	}
+
+	entry := &Watchlist{UserID: users[0].ID, FilmID: film.ID, Priority: 5}
+	if err := m.Watchlist.Insert(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	entry.Watched = true
+	if err := m.Watchlist.Update(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	film, err := m.Films.Get(ctx, film.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	film.Year = 2011
+	if err := m.Films.Update(ctx, film); err != nil {
+		t.Fatal(err)
+	}
+
+	// The film.created event has no subscribers, so three events are relayed.
+	if n, err := m.Webhooks.Relay(ctx, 100, func(ctx context.Context, tx *sql.Tx, id int64) error {
+		return nil
+	}); err != nil || n != 3 {
+		t.Fatalf("relayed %d events with error %v, want 3", n, err)
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, SortValues: []string{"id"}, SortSafelist: []string{"id"}}
+
+	tests := []struct {
+		name    string
+		webhook *Webhook
+		want    []string
+	}{
+		{name: "Owner", webhook: webhooks[0], want: []string{EventWatchlistWatched, EventFilmUpdated}},
+		{name: "Other user", webhook: webhooks[1], want: []string{EventFilmUpdated}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deliveries, _, err := m.Webhooks.GetDeliveries(ctx, tt.webhook.ID, "", filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, delivery := range deliveries {
+				got = append(got, delivery.Event)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got events %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type memoryWebhooks struct {
+	store *MemoryStore
+}
+
+// toWebhook returns a copy of the stored webhook. The caller must hold the
+// lock.
+func toWebhook(stored *Webhook) *Webhook {
+	webhook := *stored
+	webhook.Events = slices.Clone(stored.Events)
+	return &webhook
+}
+
+func (webhooks memoryWebhooks) Insert(ctx context.Context, webhook *Webhook) error {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	if _, ok := webhooks.store.users[webhook.UserID]; !ok {
+		return fmt.Errorf("webhook for user %d: user does not exist", webhook.UserID)
+	}
+
+	webhooks.store.lastWebhookID++
+
+	webhook.ID = webhooks.store.lastWebhookID
+	webhook.CreatedAt = time.Now()
+	webhook.Version = 1
+
+	webhooks.store.webhooks[webhook.ID] = toWebhook(webhook)
+	return nil
+}
+
+func (webhooks memoryWebhooks) Get(ctx context.Context, id, userID int64) (*Webhook, error) {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	stored, ok := webhooks.store.webhooks[id]
+	if !ok || stored.UserID != userID {
+		return nil, ErrRecordNotFound
+	}
+
+	return toWebhook(stored), nil
+}
+
+func (webhooks memoryWebhooks) GetAllForUser(ctx context.Context, userID int64) ([]*Webhook, error) {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	result := []*Webhook{}
+	for _, stored := range webhooks.store.webhooks {
+		if stored.UserID == userID {
+			result = append(result, toWebhook(stored))
+		}
+	}
+
+	slices.SortFunc(result, func(a, b *Webhook) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return result, nil
+}
+
+func (webhooks memoryWebhooks) Update(ctx context.Context, webhook *Webhook) error {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	stored, ok := webhooks.store.webhooks[webhook.ID]
+	if !ok || stored.UserID != webhook.UserID || stored.Version != webhook.Version {
+		return ErrEditConflict
+	}
+
+	stored.URL = webhook.URL
+	stored.Events = slices.Clone(webhook.Events)
+	stored.Active = webhook.Active
+	stored.Version++
+
+	webhook.Version = stored.Version
+	return nil
+}
+
+func (webhooks memoryWebhooks) Delete(ctx context.Context, id, userID int64) error {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	stored, ok := webhooks.store.webhooks[id]
+	if !ok || stored.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	webhooks.store.deleteWebhook(id)
+	return nil
+}
+
+// deleteWebhook removes the webhook and its deliveries. The caller must hold
+// the lock.
+func (store *MemoryStore) deleteWebhook(id int64) {
+	delete(store.webhooks, id)
+
+	for deliveryID, delivery := range store.deliveries {
+		if delivery.WebhookID == id {
+			delete(store.deliveries, deliveryID)
+		}
+	}
+}
+
+// memoryOutboxEvent is a row of webhook_outbox.
+type memoryOutboxEvent struct {
+	id         int64
+	occurredAt time.Time
+	event      string
+	userID     *int64
+	payload    []byte
+}
+
+// publishWebhookEvent is the in-memory counterpart of publishWebhookEvent.
+// The caller must hold the lock.
+func (store *MemoryStore) publishWebhookEvent(event string, userID *int64, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if userID != nil {
+		owner := *userID
+		userID = &owner
+	}
+
+	store.lastOutboxID++
+	store.outbox = append(store.outbox, memoryOutboxEvent{
+		id:         store.lastOutboxID,
+		occurredAt: time.Now(),
+		event:      event,
+		userID:     userID,
+		payload:    payload,
+	})
+
+	return nil
+}
+
+// Relay turns up to limit outbox events into deliveries and passes each to
+// enqueue with a nil transaction. If enqueue fails, the batch is put back
+// as the SQL transaction would be rolled back.
+func (webhooks memoryWebhooks) Relay(ctx context.Context, limit int, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (int, error) {
+	webhooks.store.mu.Lock()
+
+	batch := webhooks.store.outbox[:min(limit, len(webhooks.store.outbox))]
+	webhooks.store.outbox = slices.Clone(webhooks.store.outbox[len(batch):])
+
+	var deliveryIDs []int64
+	for _, e := range batch {
+		for _, webhook := range webhooks.store.webhooks {
+			// Private events only go to their owner's webhooks.
+			if !webhook.Active || !slices.Contains(webhook.Events, e.event) || (e.userID != nil && *e.userID != webhook.UserID) {
+				continue
+			}
+
+			webhooks.store.lastDeliveryID++
+			webhooks.store.deliveries[webhooks.store.lastDeliveryID] = &WebhookDelivery{
+				ID:         webhooks.store.lastDeliveryID,
+				CreatedAt:  time.Now(),
+				WebhookID:  webhook.ID,
+				EventID:    e.id,
+				Event:      e.event,
+				Payload:    e.payload,
+				OccurredAt: e.occurredAt,
+				Status:     DeliveryPending,
+			}
+			deliveryIDs = append(deliveryIDs, webhooks.store.lastDeliveryID)
+		}
+	}
+
+	// enqueue may call back into the store, so it runs without the lock.
+	webhooks.store.mu.Unlock()
+
+	slices.Sort(deliveryIDs)
+
+	for _, id := range deliveryIDs {
+		if err := enqueue(ctx, nil, id); err != nil {
+			webhooks.store.mu.Lock()
+			for _, id := range deliveryIDs {
+				delete(webhooks.store.deliveries, id)
+			}
+			webhooks.store.outbox = append(slices.Clone(batch), webhooks.store.outbox...)
+			webhooks.store.mu.Unlock()
+
+			return 0, err
+		}
+	}
+
+	return len(batch), nil
+}
+
+func (webhooks memoryWebhooks) GetDeliveries(ctx context.Context, webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	matched := []*WebhookDelivery{}
+	for _, delivery := range webhooks.store.deliveries {
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			matched = append(matched, delivery)
+		}
+	}
+
+	sortRecords(matched, filters, func(column string, a, b *WebhookDelivery) int {
+		return cmp.Compare(a.ID, b.ID)
+	}, func(a, b *WebhookDelivery) int {
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	page, metadata := paginate(matched, filters)
+
+	result := make([]*WebhookDelivery, len(page))
+	for i, delivery := range page {
+		copied := *delivery
+		result[i] = &copied
+	}
+
+	return result, metadata, nil
+}
+
+func (webhooks memoryWebhooks) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, *Webhook, error) {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	stored, ok := webhooks.store.deliveries[id]
+	if !ok {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	delivery := *stored
+	return &delivery, toWebhook(webhooks.store.webhooks[stored.WebhookID]), nil
+}
+
+func (webhooks memoryWebhooks) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+	webhooks.store.mu.Lock()
+	defer webhooks.store.mu.Unlock()
+
+	stored, ok := webhooks.store.deliveries[delivery.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.LastAttemptAt = delivery.LastAttemptAt
+	stored.ResponseStatus = delivery.ResponseStatus
+	stored.ResponseBody = delivery.ResponseBody
+	stored.Error = delivery.Error
+
+	return nil
+}
+
+// Redeliver copies the delivery and passes the copy to enqueue with a nil
+// transaction. The copy is dropped again if enqueue fails.
+func (webhooks memoryWebhooks) Redeliver(ctx context.Context, webhookID, deliveryID int64, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (*WebhookDelivery, error) {
+	webhooks.store.mu.Lock()
+
+	original, ok := webhooks.store.deliveries[deliveryID]
+	if !ok || original.WebhookID != webhookID {
+		webhooks.store.mu.Unlock()
+		return nil, ErrRecordNotFound
+	}
+
+	webhooks.store.lastDeliveryID++
+
+	delivery := &WebhookDelivery{
+		ID:         webhooks.store.lastDeliveryID,
+		CreatedAt:  time.Now(),
+		WebhookID:  original.WebhookID,
+		EventID:    original.EventID,
+		Event:      original.Event,
+		Payload:    original.Payload,
+		OccurredAt: original.OccurredAt,
+		Status:     DeliveryPending,
+	}
+
+	stored := *delivery
+	webhooks.store.deliveries[delivery.ID] = &stored
+
+	// enqueue may call back into the store, so it runs without the lock.
+	webhooks.store.mu.Unlock()
+
+	if err := enqueue(ctx, nil, delivery.ID); err != nil {
+		webhooks.store.mu.Lock()
+		delete(webhooks.store.deliveries, delivery.ID)
+		webhooks.store.mu.Unlock()
+
+		return nil, err
+	}
+
+	return delivery, nil
+}
+
+var _ WebhookRepository = memoryWebhooks{}
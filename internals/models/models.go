@@ -1,27 +1,81 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
 )
 
 var ErrRecordNotFound = errors.New("record doesn't exist")
 var ErrEditConflict = errors.New("edit conflict")
 
+// ErrCanceled means a query was abandoned before it finished, because the
+// caller gave up or because it ran past its timeout. It wraps
+// context.Canceled or context.DeadlineExceeded to tell the two apart.
+var ErrCanceled = errors.New("query canceled")
+
+// DefaultTimeout is how long a model call may take if its model's Timeout
+// is zero.
+const DefaultTimeout = 3 * time.Second
+
 type Models struct {
-	Films       FilmModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
-	Watchlist   WatchlistModel
+	Films       FilmRepository
+	Users       UserRepository
+	Tokens      TokenRepository
+	Permissions PermissionRepository
+	Watchlist   WatchlistRepository
+	APIKeys     APIKeyRepository
+	Identities  IdentityRepository
+	TOTP        TOTPRepository
+	Logins      LoginAttemptRepository
+	Audit       AuditRepository
+	Webhooks    WebhookRepository
+	Schema      SchemaRepository
 }
 
-func New(DB *sql.DB) Models {
+// New returns the models for DB. Each call may take up to timeout, or
+// DefaultTimeout if it's zero, and is also canceled with the context it's
+// given.
+func New(DB *sql.DB, timeout time.Duration) Models {
 	return Models{
-		Films:       FilmModel{DB: DB},
-		Users:       UserModel{DB: DB},
-		Tokens:      TokenModel{DB: DB},
-		Permissions: PermissionModel{DB: DB},
-		Watchlist:   WatchlistModel{DB: DB},
+		Films:       FilmModel{DB: DB, Timeout: timeout},
+		Users:       UserModel{DB: DB, Timeout: timeout},
+		Tokens:      TokenModel{DB: DB, Timeout: timeout},
+		Permissions: PermissionModel{DB: DB, Timeout: timeout},
+		Watchlist:   WatchlistModel{DB: DB, Timeout: timeout},
+		APIKeys:     APIKeyModel{DB: DB, Timeout: timeout},
+		Identities:  IdentityModel{DB: DB, Timeout: timeout},
+		TOTP:        TOTPModel{DB: DB, Timeout: timeout},
+		Logins:      LoginAttemptModel{DB: DB, Timeout: timeout},
+		Audit:       AuditModel{DB: DB, Timeout: timeout},
+		Webhooks:    WebhookModel{DB: DB, Timeout: timeout},
+		Schema:      SchemaModel{DB: DB},
+	}
+}
+
+// withTimeout bounds a model call by timeout, or DefaultTimeout if it's
+// zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError turns err into ErrCanceled if ctx ended while the query ran.
+// The driver reports a canceled query as an error of its own, which would
+// otherwise look like any other database failure.
+func queryError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrCanceled) {
+		return err
 	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ErrCanceled, ctxErr)
+	}
+
+	return err
 }
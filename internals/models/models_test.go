@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestQueryError tests that failures of queries whose context ended become
+// ErrCanceled, keeping the reason, and that other failures are left alone
+func TestQueryError(t *testing.T) {
+	driverErr := errors.New("pq: canceling statement due to user request")
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		want    []error
+		notWant error
+	}{
+		{"nil", canceled, nil, nil, ErrCanceled},
+		{"live context", context.Background(), driverErr, []error{driverErr}, ErrCanceled},
+		{"client canceled", canceled, driverErr, []error{ErrCanceled, context.Canceled}, context.DeadlineExceeded},
+		{"timed out", expired, driverErr, []error{ErrCanceled, context.DeadlineExceeded}, context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := queryError(tt.ctx, tt.err)
+
+			if tt.err == nil && got != nil {
+				t.Fatalf("got %v, want nil", got)
+			}
+			for _, want := range tt.want {
+				if !errors.Is(got, want) {
+					t.Errorf("got %v, want it to wrap %v", got, want)
+				}
+			}
+			if errors.Is(got, tt.notWant) {
+				t.Errorf("got %v, don't want it to wrap %v", got, tt.notWant)
+			}
+		})
+	}
+
+	// Errors that already went through an inner call aren't wrapped twice.
+	once := queryError(canceled, driverErr)
+	if twice := queryError(canceled, once); twice != once {
+		t.Errorf("got %v, want %v unchanged", twice, once)
+	}
+}
+
+// TestWithTimeout tests that a zero timeout falls back to DefaultTimeout
+func TestWithTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		ctx, cancel := withTimeout(context.Background(), timeout)
+		defer cancel()
+
+		want := timeout
+		if want == 0 {
+			want = DefaultTimeout
+		}
+
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatalf("withTimeout(%s) has no deadline", timeout)
+		}
+		if got := time.Until(deadline); got > want || got < want-time.Second {
+			t.Errorf("withTimeout(%s) expires in %s, want %s", timeout, got, want)
+		}
+	}
+}
@@ -20,10 +20,19 @@ func (permissions Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	audit   *AuditEvent
 }
 
-func (model PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+// WithAudit returns a copy of the model whose next grant also records event,
+// in the same transaction.
+func (model PermissionModel) WithAudit(event *AuditEvent) PermissionRepository {
+	model.audit = event
+	return model
+}
+
+func (model PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	query := `
 		SELECT permissions.code
 		FROM permissions
@@ -32,12 +41,15 @@ func (model PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		WHERE users.id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "PermissionModel.GetAllForUser", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	rows, err := model.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -46,29 +58,66 @@ func (model PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 		var permission string
 		err := rows.Scan(&permission)
 		if err != nil {
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 
 		permissions = append(permissions, permission)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
 
 	return permissions, nil
 }
 
-func (model PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser grants the permissions to the user. Codes the user already
+// holds are skipped, and an audit event is only recorded if something new
+// was granted.
+func (model PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
 	query := `
-		INSERT INTO users_permissions
-		(user_id, permission_id)
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		WITH granted AS (
+			INSERT INTO users_permissions (user_id, permission_id)
+			SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+			ON CONFLICT DO NOTHING
+			RETURNING permission_id
+		)
+		SELECT permissions.code
+		FROM granted
+		INNER JOIN permissions ON granted.permission_id = permissions.id
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "PermissionModel.AddForUser", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
-	_, err := model.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	return inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, query, userID, pq.Array(codes))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		granted := Permissions{}
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				return err
+			}
+
+			granted = append(granted, code)
+		}
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if len(granted) == 0 {
+			return nil
+		}
+
+		return model.audit.record(ctx, tx, userID, map[string]any{"granted": granted})
+	})
 }
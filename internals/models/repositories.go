@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// FilmRepository stores films. FilmModel keeps them in PostgreSQL and
+// MemoryStore in memory.
+type FilmRepository interface {
+	Get(ctx context.Context, id int64) (*Film, error)
+	GetAll(ctx context.Context, title string, genres []string, actors []string, directors []string, filters Filters) ([]*Film, Metadata, error)
+	Insert(ctx context.Context, film *Film) error
+	Update(ctx context.Context, film *Film) error
+	Delete(ctx context.Context, film *Film) error
+	Count(ctx context.Context) (int, error)
+	WithAudit(event *AuditEvent) FilmRepository
+}
+
+// UserRepository stores user accounts. UserModel keeps them in PostgreSQL
+// and MemoryStore in memory.
+type UserRepository interface {
+	Get(ctx context.Context, id int64) (*User, error)
+	GetAll(ctx context.Context, search string, permission string, filters Filters) ([]*User, Metadata, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	Insert(ctx context.Context, user *User) error
+	Update(ctx context.Context, user *User) error
+	Delete(ctx context.Context, user *User) error
+	DeleteUnactivated(ctx context.Context, cutoff time.Time) (int64, error)
+	WithAudit(event *AuditEvent) UserRepository
+}
+
+// TokenRepository stores activation and authentication tokens. TokenModel
+// keeps them in PostgreSQL and MemoryStore in memory.
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// PermissionRepository stores which permissions users hold. PermissionModel
+// keeps them in PostgreSQL and MemoryStore in memory.
+type PermissionRepository interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+	WithAudit(event *AuditEvent) PermissionRepository
+}
+
+// WatchlistRepository stores users' watchlists. WatchlistModel keeps them
+// in PostgreSQL and MemoryStore in memory.
+type WatchlistRepository interface {
+	Get(ctx context.Context, userID, entryID int64) (*Watchlist, error)
+	GetAll(ctx context.Context, userID int64, watched *bool, priority int, filters Filters) ([]*Watchlist, Metadata, error)
+	CheckExists(ctx context.Context, userID, filmID int64) (bool, error)
+	Insert(ctx context.Context, entry *Watchlist) error
+	Update(ctx context.Context, entry *Watchlist) error
+	Delete(ctx context.Context, entry *Watchlist) error
+}
+
+// APIKeyRepository stores users' API keys. APIKeyModel keeps them in
+// PostgreSQL and MemoryStore in memory.
+type APIKeyRepository interface {
+	New(ctx context.Context, userID int64, name string, permissions Permissions, expiry *time.Time) (*APIKey, error)
+	Insert(ctx context.Context, key *APIKey) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	Delete(ctx context.Context, userID, keyID int64) error
+	GetForKey(ctx context.Context, keyPlaintext string) (*User, *APIKey, error)
+}
+
+// IdentityRepository links users to OpenID Connect accounts. IdentityModel
+// keeps the links in PostgreSQL and MemoryStore in memory.
+type IdentityRepository interface {
+	Insert(ctx context.Context, identity *Identity) error
+	GetUser(ctx context.Context, issuer, subject string) (*User, error)
+}
+
+// TOTPRepository stores authenticator enrolments and recovery codes.
+// TOTPModel keeps them in PostgreSQL and MemoryStore in memory.
+type TOTPRepository interface {
+	Get(ctx context.Context, userID int64) (*TOTP, error)
+	Begin(ctx context.Context, userID int64, secret string) error
+	Confirm(ctx context.Context, userID int64, step int64) ([]string, error)
+	UseStep(ctx context.Context, userID int64, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error)
+	Delete(ctx context.Context, userID int64) error
+}
+
+// LoginAttemptRepository counts failed logins. LoginAttemptModel keeps the
+// counts in PostgreSQL and MemoryStore in memory.
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, scope, key string) (*LoginAttempt, error)
+	RecordFailure(ctx context.Context, scope, key string, policy LockoutPolicy) (*LoginAttempt, error)
+	Reset(ctx context.Context, scope, key string) error
+	DeleteStale(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// AuditRepository stores audit events. AuditModel keeps them in PostgreSQL
+// and MemoryStore in memory.
+type AuditRepository interface {
+	Insert(ctx context.Context, event *AuditEvent, resourceID int64) error
+	GetAll(ctx context.Context, actorID int64, action, resourceType string, resourceID int64, from, to *time.Time, filters Filters) ([]*AuditEvent, Metadata, error)
+}
+
+// WebhookRepository stores webhooks and their deliveries. WebhookModel keeps
+// them in PostgreSQL and MemoryStore in memory. Relay and Redeliver hand
+// enqueue the transaction creating the delivery; MemoryStore has none to
+// give and passes nil.
+type WebhookRepository interface {
+	Insert(ctx context.Context, webhook *Webhook) error
+	Get(ctx context.Context, id, userID int64) (*Webhook, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*Webhook, error)
+	Update(ctx context.Context, webhook *Webhook) error
+	Delete(ctx context.Context, id, userID int64) error
+	Relay(ctx context.Context, limit int, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (int, error)
+	GetDeliveries(ctx context.Context, webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error)
+	GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, *Webhook, error)
+	RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error
+	Redeliver(ctx context.Context, webhookID, deliveryID int64, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (*WebhookDelivery, error)
+}
+
+// SchemaRepository reports on the database itself. SchemaModel asks
+// PostgreSQL; MemoryStore is always reachable and at SchemaVersion.
+type SchemaRepository interface {
+	Ping(ctx context.Context) error
+	Version(ctx context.Context) (int64, bool, error)
+}
+
+var (
+	_ FilmRepository         = FilmModel{}
+	_ UserRepository         = UserModel{}
+	_ TokenRepository        = TokenModel{}
+	_ PermissionRepository   = PermissionModel{}
+	_ WatchlistRepository    = WatchlistModel{}
+	_ APIKeyRepository       = APIKeyModel{}
+	_ IdentityRepository     = IdentityModel{}
+	_ TOTPRepository         = TOTPModel{}
+	_ LoginAttemptRepository = LoginAttemptModel{}
+	_ AuditRepository        = AuditModel{}
+	_ WebhookRepository      = WebhookModel{}
+	_ SchemaRepository       = SchemaModel{}
+)
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// SchemaVersion is the migration the code expects the database to be at. It
+// must be raised with every new migration.
+const SchemaVersion = 19
+
+// SchemaModel reports on the database itself rather than any one table.
+type SchemaModel struct {
+	DB *sql.DB
+}
+
+// Ping checks that the database can be reached.
+func (m SchemaModel) Ping(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "SchemaModel.Ping", "")
+	defer span.End()
+
+	return m.DB.PingContext(ctx)
+}
+
+// Version returns the last migration applied and whether it failed part way
+// through, leaving the schema dirty. ErrRecordNotFound means no migration has
+// ever been applied.
+func (m SchemaModel) Version(ctx context.Context) (int64, bool, error) {
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	ctx, span := startSpan(ctx, "SchemaModel.Version", "SELECT")
+	defer span.End()
+
+	var version int64
+	var dirty bool
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, ErrRecordNotFound
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
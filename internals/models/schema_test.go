@@ -0,0 +1,33 @@
+package models
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestSchemaVersion tests that SchemaVersion is the newest migration
+func TestSchemaVersion(t *testing.T) {
+	entries, err := os.ReadDir("../../migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var newest int64
+	for _, entry := range entries {
+		prefix, _, found := strings.Cut(entry.Name(), "_")
+		if !found {
+			continue
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		newest = max(newest, version)
+	}
+
+	if newest != SchemaVersion {
+		t.Errorf("SchemaVersion is %d, but the newest migration is %d", SchemaVersion, newest)
+	}
+}
@@ -50,20 +50,21 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
-func (model TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (model TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = model.Insert(token)
+	err = model.Insert(ctx, token)
 	return token, err
 }
 
-func (model TokenModel) Insert(token *Token) error {
+func (model TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
 		INSERT INTO tokens (hash, user_id, expiry, scope)
 		VALUES ($1, $2, $3, $4)
@@ -71,24 +72,51 @@ func (model TokenModel) Insert(token *Token) error {
 
 	args := []any{token.Hash, token.UserId, token.Expiry, token.Scope}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	_, err := model.DB.ExecContext(ctx, query, args...)
-	return err
+	return queryError(ctx, err)
 }
 
-func (model TokenModel) DeleteAllForUser(scopeActivation string, userID int64) error {
+func (model TokenModel) DeleteAllForUser(ctx context.Context, scopeActivation string, userID int64) error {
 
 	query := `
 		DELETE FROM tokens WHERE user_id = $1 AND scope = $2
 	`
 
 	args := []any{userID, scopeActivation}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "TokenModel.DeleteAllForUser", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	_, err := model.DB.ExecContext(ctx, query, args...)
 
-	return err
+	return queryError(ctx, err)
+}
+
+// DeleteExpired removes tokens of every scope whose expiry has passed and
+// returns how many were deleted.
+func (model TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM tokens WHERE expiry < $1
+	`
+
+	ctx, span := startSpan(ctx, "TokenModel.DeleteExpired", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
 }
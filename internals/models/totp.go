@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+const ScopeTwoFactorPending = "2fa-pending"
+
+// RecoveryCodeCount is how many one-time recovery codes are issued on enrolment.
+const RecoveryCodeCount = 10
+
+var ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+
+// TOTP is a user's authenticator enrolment. Until it is confirmed with a valid
+// code it doesn't affect how the user logs in.
+type TOTP struct {
+	UserID      int64
+	Secret      string
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+func (t *TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			v.AddError("code", "must only contain digits")
+			break
+		}
+	}
+}
+
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	for i := range codes {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(encoding.EncodeToString(randomBytes))
+		codes[i] = code[:8] + "-" + code[8:]
+	}
+
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	hash := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hash[:]
+}
+
+type TOTPModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (model TOTPModel) Get(ctx context.Context, userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at, last_step
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	var t TOTP
+
+	ctx, span := startSpan(ctx, "TOTPModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &t, nil
+}
+
+// Begin stores a new, unconfirmed secret for the user, replacing any earlier
+// enrolment that was never confirmed.
+func (model TOTPModel) Begin(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_step = 0, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL
+	`
+
+	ctx, span := startSpan(ctx, "TOTPModel.Begin", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Confirm enables the user's enrolment and replaces their recovery codes in
+// one transaction. It returns the new recovery codes in plaintext.
+func (model TOTPModel) Confirm(ctx context.Context, userID int64, step int64) ([]string, error) {
+	codes, err := generateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, "TOTPModel.Confirm", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp
+		SET confirmed_at = NOW(), last_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL
+	`
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	for _, code := range codes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (hash, user_id) VALUES ($1, $2)`, hashRecoveryCode(code), userID)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// UseStep records that the code for step has been used. It reports false if
+// that step (or a later one) was already used, which stops a code that was
+// observed in transit from being replayed.
+func (model TOTPModel) UseStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp
+		SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2
+	`
+
+	ctx, span := startSpan(ctx, "TOTPModel.UseStep", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode burns one of the user's unused recovery codes.
+func (model TOTPModel) UseRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	query := `
+		UPDATE recovery_codes
+		SET used_at = NOW()
+		WHERE hash = $1 AND user_id = $2 AND used_at IS NULL
+	`
+
+	ctx, span := startSpan(ctx, "TOTPModel.UseRecoveryCode", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, hashRecoveryCode(code), userID)
+	if err != nil {
+		return false, queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Delete removes the user's enrolment and recovery codes.
+func (model TOTPModel) Delete(ctx context.Context, userID int64) error {
+	ctx, span := startSpan(ctx, "TOTPModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	tx, err := model.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	return queryError(ctx, tx.Commit())
+}
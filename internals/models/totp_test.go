@@ -0,0 +1,59 @@
+package models
+
+import (
+	"bytes"
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+// TestValidateTOTPCode tests the TOTP code validation function
+func TestValidateTOTPCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		wantValid bool
+	}{
+		{name: "Valid code", code: "123456", wantValid: true},
+		{name: "Empty code", code: "", wantValid: false},
+		{name: "Too short", code: "12345", wantValid: false},
+		{name: "Not digits", code: "12a456", wantValid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateTOTPCode(v, tt.code)
+			if v.Valid() != tt.wantValid {
+				t.Errorf("ValidateTOTPCode() got valid = %v, want %v, errors: %v", v.Valid(), tt.wantValid, v.Errors)
+			}
+		})
+	}
+}
+
+// TestGenerateRecoveryCodes tests recovery code generation and hashing
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("generateRecoveryCodes() returned %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	if !validator.Unique(codes) {
+		t.Error("generateRecoveryCodes() returned duplicate codes")
+	}
+
+	for _, code := range codes {
+		if len(code) != 17 || code[8] != '-' {
+			t.Errorf("recovery code %q is not formatted as xxxxxxxx-xxxxxxxx", code)
+		}
+	}
+
+	// Codes are matched regardless of case and surrounding whitespace.
+	if !bytes.Equal(hashRecoveryCode(codes[0]), hashRecoveryCode(" "+codes[0]+"\n")) {
+		t.Error("hashRecoveryCode() is sensitive to surrounding whitespace")
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("filmapi.zeyadtarek.net/internals/models")
+
+// startSpan starts a span for one model call, named after the model and
+// method, such as "FilmModel.Get". operation is the SQL statement it runs,
+// such as "SELECT", or "" if it runs none. The caller must end the span.
+func startSpan(ctx context.Context, name, operation string) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("db.system", "postgresql")}
+	if operation != "" {
+		attrs = append(attrs, attribute.String("db.operation.name", operation))
+	}
+
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
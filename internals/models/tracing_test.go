@@ -0,0 +1,49 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestStartSpan tests that model spans are children of the caller's span and
+// carry the SQL operation
+func TestStartSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /v1/films/{id}")
+	_, span := startSpan(ctx, "FilmModel.Get", "SELECT")
+	span.End()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	got := spans[0]
+	if got.Name() != "FilmModel.Get" {
+		t.Errorf("got span name %q, want %q", got.Name(), "FilmModel.Get")
+	}
+	if got.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("model span isn't a child of the request span")
+	}
+
+	want := attribute.String("db.operation.name", "SELECT")
+	found := false
+	for _, attr := range got.Attributes() {
+		found = found || attr == want
+	}
+	if !found {
+		t.Errorf("got attributes %v, want %v among them", got.Attributes(), want)
+	}
+}
@@ -2,12 +2,17 @@ package models
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"filmapi.zeyadtarek.net/internals/validator"
 	"golang.org/x/crypto/bcrypt"
-	"time"
 )
 
 var (
@@ -16,22 +21,34 @@ var (
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	audit   *AuditEvent
+}
+
+// WithAudit returns a copy of the model whose next write also records event,
+// in the same transaction.
+func (model UserModel) WithAudit(event *AuditEvent) UserRepository {
+	model.audit = event
+	return model
 }
 
 func (user *User) IsAnonyomous() bool {
 	return user == AnonymousUser
 }
-func (model UserModel) Insert(user *User) error {
+func (model UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, activated_at)
+		VALUES ($1, $2, $3, $4, CASE WHEN $4 THEN NOW() END)
 		RETURNING id, created_at, version
 	`
 
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -41,31 +58,39 @@ func (model UserModel) Insert(user *User) error {
 			return ErrDuplicateEmail
 
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func (model UserModel) GetByEmail(email string) (*User, error) {
+func (model UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, suspended, version
 		FROM users
-		WHERE email = $1
+		WHERE id = $1
 	`
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
-	err := model.DB.QueryRowContext(ctx, query, email).Scan(
+	err := model.DB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
 		&user.Version,
 	)
 
@@ -75,40 +100,209 @@ func (model UserModel) GetByEmail(email string) (*User, error) {
 			return nil, ErrRecordNotFound
 
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &user, nil
 }
 
-func (model UserModel) Update(user *User) error {
+// GetAll lists users for the admin API. search matches part of the name or
+// email, and permission, when set, keeps only users holding that code.
+func (model UserModel) GetAll(ctx context.Context, search string, permission string, filters Filters) ([]*User, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, name, email, password_hash, activated, suspended, version
+		FROM users
+		WHERE ($1 = '' OR name ILIKE $2 OR email ILIKE $2)
+		AND ($3 = '' OR EXISTS (
+			SELECT 1 FROM users_permissions
+			INNER JOIN permissions ON users_permissions.permission_id = permissions.id
+			WHERE users_permissions.user_id = users.id
+			AND permissions.code = $3
+		))
+		ORDER BY %s id ASC
+		LIMIT $4 OFFSET $5
+	`, filters.sortColumn())
+
+	args := []any{search, containsPattern(search), permission, filters.limit(), filters.offset()}
+
+	ctx, span := startSpan(ctx, "UserModel.GetAll", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	totalRecords := 0
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Suspended,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// containsPattern turns search into an ILIKE pattern matching it anywhere,
+// with LIKE's own wildcards escaped so they are matched literally.
+func containsPattern(search string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + escaper.Replace(search) + "%"
+}
+
+func (model UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version
+		SELECT id, created_at, name, email, password_hash, activated, suspended, version
+		FROM users
+		WHERE email = $1
 	`
+	var user User
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
+	ctx, span := startSpan(ctx, "UserModel.GetByEmail", "SELECT")
+	defer span.End()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
-	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+	err := model.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+	)
+
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique
-			constraint "users_email_key"`:
-			return ErrDuplicateEmail
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrRecordNotFound
+
 		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+func (model UserModel) Update(ctx context.Context, user *User) error {
+	query := `
+		UPDATE users
+		SET name = $1, email = $2, password_hash = $3, activated = $4, suspended = $5, version = version + 1,
+		activated_at = CASE WHEN $4 THEN COALESCE(activated_at, NOW()) ELSE activated_at END
+		WHERE id = $6 AND version = $7
+		RETURNING version
+	`
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Suspended, user.ID, user.Version}
+
+	ctx, span := startSpan(ctx, "UserModel.Update", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	return inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return model.audit.record(ctx, tx, user.ID, user)
+	})
+}
+
+// Delete removes the user, and through cascading foreign keys their tokens,
+// permissions and watchlist. Like Update it fails with ErrEditConflict if the
+// record changed since it was read.
+func (model UserModel) Delete(ctx context.Context, user *User) error {
+	query := `
+		DELETE FROM users
+		WHERE id = $1 AND version = $2
+	`
+
+	ctx, span := startSpan(ctx, "UserModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	return inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, user.ID, user.Version)
+		if err != nil {
 			return err
 		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrEditConflict
+		}
+
+		return model.audit.record(ctx, tx, user.ID, nil)
+	})
+}
+
+// DeleteUnactivated removes accounts that were created before cutoff and
+// never activated, returning how many were deleted. Accounts deactivated
+// later on, e.g. pending re-verification of a new email, are kept.
+func (model UserModel) DeleteUnactivated(ctx context.Context, cutoff time.Time) (int64, error) {
+	query := `
+		DELETE FROM users
+		WHERE activated = false AND activated_at IS NULL AND created_at < $1
+	`
+
+	ctx, span := startSpan(ctx, "UserModel.DeleteUnactivated", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, queryError(ctx, err)
 	}
 
-	return nil
+	return result.RowsAffected()
 }
 
 type User struct {
@@ -118,6 +312,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Suspended bool      `json:"suspended"`
 	Version   int       `json:"-"`
 }
 
@@ -139,6 +334,26 @@ func (pass *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// SetUnusable stores the hash of a random secret that is never revealed, for
+// accounts that sign in through an external identity provider.
+func (pass *password) SetUnusable() error {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(base64.RawStdEncoding.EncodeToString(randomBytes)), 12)
+	if err != nil {
+		return err
+	}
+
+	pass.plaintext = nil
+	pass.hash = hash
+
+	return nil
+}
+
 func (pass *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(pass.hash, []byte(plaintextPassword))
 
@@ -167,25 +382,31 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes")
-	ValidateEmail(v, user.Email)
+	ValidateUserDetails(v, user)
 	if user.Password.plaintext != nil {
 		ValidatePasswordPlaintext(v, *user.Password.plaintext)
 	}
+}
+
+// ValidateUserDetails checks everything ValidateUser does except the new
+// password, for callers that check it against a validator.PasswordPolicy
+// instead, so the policy's length limits are the only ones applied.
+func ValidateUserDetails(v *validator.Validator, user *User) {
+	v.Check(user.Name != "", "name", "must be provided")
+	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes")
+	ValidateEmail(v, user.Email)
 
 	if user.Password.hash == nil {
 		panic("missing password hash for user")
 	}
-
 }
 
-func (model UserModel) GetForToken(tokenscope, tokenPlaintext string) (*User, error) {
+func (model UserModel) GetForToken(ctx context.Context, tokenscope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
 		SELECT users.id, users.created_at, users.name, users.email, users.password_hash,
-		users.activated, users.version
+		users.activated, users.suspended, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -198,7 +419,10 @@ func (model UserModel) GetForToken(tokenscope, tokenPlaintext string) (*User, er
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "UserModel.GetForToken", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
 	defer cancel()
 
 	err := model.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -208,6 +432,7 @@ func (model UserModel) GetForToken(tokenscope, tokenPlaintext string) (*User, er
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
 		&user.Version,
 	)
 
@@ -216,7 +441,7 @@ func (model UserModel) GetForToken(tokenscope, tokenPlaintext string) (*User, er
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
@@ -269,3 +269,22 @@ func TestUserIsAnonymous(t *testing.T) {
 		})
 	}
 }
+
+// TestContainsPattern tests that LIKE wildcards in searches are escaped
+func TestContainsPattern(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+	}{
+		{search: "jane", want: "%jane%"},
+		{search: "100%", want: `%100\%%`},
+		{search: "a_b", want: `%a\_b%`},
+		{search: `c:\dir`, want: `%c:\\dir%`},
+	}
+
+	for _, tt := range tests {
+		if got := containsPattern(tt.search); got != tt.want {
+			t.Errorf("containsPattern(%q) = %q, want %q", tt.search, got, tt.want)
+		}
+	}
+}
@@ -26,7 +26,8 @@ type Watchlist struct {
 }
 
 type WatchlistModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
 }
 
 func ValidateWatchlistEntry(v *validator.Validator, entry *Watchlist) {
@@ -44,7 +45,7 @@ func ValidateWatchlistEntry(v *validator.Validator, entry *Watchlist) {
 	}
 }
 
-func (m WatchlistModel) Insert(entry *Watchlist) error {
+func (m WatchlistModel) Insert(ctx context.Context, entry *Watchlist) error {
 	query := `
 		INSERT INTO watchlist (user_id, film_id, notes, priority, watched, watched_at, rating)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -61,7 +62,10 @@ func (m WatchlistModel) Insert(entry *Watchlist) error {
 		entry.Rating,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID, &entry.AddedAt, &entry.Version)
@@ -70,14 +74,14 @@ func (m WatchlistModel) Insert(entry *Watchlist) error {
 		case err.Error() == `pq: duplicate key value violates unique constraint "watchlist_user_film_unique"`:
 			return ErrDuplicateWatchlistEntry
 		default:
-			return err
+			return queryError(ctx, err)
 		}
 	}
 
 	return nil
 }
 
-func (m WatchlistModel) Get(userID, entryID int64) (*Watchlist, error) {
+func (m WatchlistModel) Get(ctx context.Context, userID, entryID int64) (*Watchlist, error) {
 	if entryID < 1 {
 		return nil, ErrRecordNotFound
 	}
@@ -94,7 +98,10 @@ func (m WatchlistModel) Get(userID, entryID int64) (*Watchlist, error) {
 		WHERE w.id = $1 AND w.user_id = $2
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var entry Watchlist
@@ -129,7 +136,7 @@ func (m WatchlistModel) Get(userID, entryID int64) (*Watchlist, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
@@ -152,7 +159,7 @@ func (m WatchlistModel) Get(userID, entryID int64) (*Watchlist, error) {
 	return &entry, nil
 }
 
-func (m WatchlistModel) GetAll(userID int64, watched *bool, priority int, filters Filters) ([]*Watchlist, Metadata, error) {
+func (m WatchlistModel) GetAll(ctx context.Context, userID int64, watched *bool, priority int, filters Filters) ([]*Watchlist, Metadata, error) {
 	whereClause := "w.user_id = $1"
 	args := []any{userID}
 	argCount := 1
@@ -186,12 +193,15 @@ func (m WatchlistModel) GetAll(userID int64, watched *bool, priority int, filter
 
 	args = append(args, filters.limit(), filters.offset())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.GetAll", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 	defer rows.Close()
 
@@ -228,7 +238,7 @@ func (m WatchlistModel) GetAll(userID int64, watched *bool, priority int, filter
 		)
 
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 
 		// Set film data
@@ -251,19 +261,24 @@ func (m WatchlistModel) GetAll(userID int64, watched *bool, priority int, filter
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
 	return watchlist, metadata, nil
 }
 
-func (m WatchlistModel) Update(entry *Watchlist) error {
+// Update saves changes to an entry. Marking it watched publishes a
+// watchlist.watched webhook event in the same transaction.
+func (m WatchlistModel) Update(ctx context.Context, entry *Watchlist) error {
+	// previous sees the row as it was before the update, so the RETURNING
+	// clause can tell whether this update is the one that marked it watched.
 	query := `
 		UPDATE watchlist
 		SET notes = $1, priority = $2, watched = $3, watched_at = $4, rating = $5, version = version + 1
-		WHERE id = $6 AND user_id = $7 AND version = $8
-		RETURNING version
+		FROM (SELECT id, watched FROM watchlist WHERE id = $6) AS previous
+		WHERE watchlist.id = previous.id AND watchlist.user_id = $7 AND watchlist.version = $8
+		RETURNING watchlist.version, previous.watched
 	`
 
 	args := []any{
@@ -277,38 +292,54 @@ func (m WatchlistModel) Update(entry *Watchlist) error {
 		entry.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.Update", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.Version)
-	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
+	return inTx(ctx, m.DB, func(tx *sql.Tx) error {
+		var wasWatched bool
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&entry.Version, &wasWatched)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
 		}
-	}
 
-	return nil
+		if entry.Watched && !wasWatched {
+			return publishWebhookEvent(ctx, tx, EventWatchlistWatched, &entry.UserID, entry)
+		}
+
+		return nil
+	})
 }
 
-func (m WatchlistModel) Delete(userID, entryID int64) error {
-	if entryID < 1 {
+// Delete removes the entry if it's still at entry.Version, otherwise it
+// returns ErrEditConflict.
+func (m WatchlistModel) Delete(ctx context.Context, entry *Watchlist) error {
+	if entry.ID < 1 {
 		return ErrRecordNotFound
 	}
 
 	query := `
 		DELETE FROM watchlist
-		WHERE id = $1 AND user_id = $2
+		WHERE id = $1 AND user_id = $2 AND version = $3
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, entryID, userID)
+	result, err := m.DB.ExecContext(ctx, query, entry.ID, entry.UserID, entry.Version)
 	if err != nil {
-		return err
+		return queryError(ctx, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -317,24 +348,27 @@ func (m WatchlistModel) Delete(userID, entryID int64) error {
 	}
 
 	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		return ErrEditConflict
 	}
 
 	return nil
 }
 
-func (m WatchlistModel) CheckExists(userID, filmID int64) (bool, error) {
+func (m WatchlistModel) CheckExists(ctx context.Context, userID, filmID int64) (bool, error) {
 	query := `
 		SELECT EXISTS(SELECT 1 FROM watchlist WHERE user_id = $1 AND film_id = $2)
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startSpan(ctx, "WatchlistModel.CheckExists", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var exists bool
 	err := m.DB.QueryRowContext(ctx, query, userID, filmID).Scan(&exists)
 	if err != nil {
-		return false, err
+		return false, queryError(ctx, err)
 	}
 
 	return exists, nil
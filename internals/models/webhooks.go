@@ -0,0 +1,557 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+	"github.com/lib/pq"
+)
+
+// Webhook event names.
+const (
+	EventFilmCreated      = "film.created"
+	EventFilmUpdated      = "film.updated"
+	EventFilmDeleted      = "film.deleted"
+	EventWatchlistWatched = "watchlist.watched"
+)
+
+// WebhookEvents lists every event a subscription can ask for.
+var WebhookEvents = []string{EventFilmCreated, EventFilmUpdated, EventFilmDeleted, EventWatchlistWatched}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    int64     `json:"-"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int       `json:"version"`
+}
+
+// WebhookDelivery is one event on its way to one subscription, along with
+// the outcome of its latest attempt.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventID        int64           `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	ResponseStatus *int            `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"`
+	Error          string          `json:"error,omitempty"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "unknown event: "+event)
+	}
+}
+
+// publishWebhookEvent adds an event to the outbox as part of the transaction
+// making the change, so the event exists exactly when the change does.
+// userID is set on events about one user's data, which only that user's
+// webhooks receive, as with events.Event.VisibleTo; catalogue events pass
+// nil and go to everyone subscribed.
+func publishWebhookEvent(ctx context.Context, tx *sql.Tx, event string, userID *int64, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO webhook_outbox (event, user_id, payload) VALUES ($1, $2, $3)`, event, userID, payload)
+	return err
+}
+
+type WebhookModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+}
+
+func (model WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (user_id, url, secret, events, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version
+	`
+
+	args := []any{webhook.UserID, webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, span := startSpan(ctx, "WebhookModel.Insert", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+	return queryError(ctx, err)
+}
+
+// Get returns one of userID's webhooks.
+func (model WebhookModel) Get(ctx context.Context, id, userID int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, span := startSpan(ctx, "WebhookModel.Get", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	var webhook Webhook
+
+	err := model.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &webhook, nil
+}
+
+func (model WebhookModel) GetAllForUser(ctx context.Context, userID int64) ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, user_id, url, secret, events, active, version
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY id
+	`
+
+	ctx, span := startSpan(ctx, "WebhookModel.GetAllForUser", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.UserID,
+			&webhook.URL,
+			&webhook.Secret,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return webhooks, nil
+}
+
+func (model WebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3, version = version + 1
+		WHERE id = $4 AND user_id = $5 AND version = $6
+		RETURNING version
+	`
+
+	args := []any{webhook.URL, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.UserID, webhook.Version}
+
+	ctx, span := startSpan(ctx, "WebhookModel.Update", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	err := model.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes one of userID's webhooks along with its delivery log.
+func (model WebhookModel) Delete(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, span := startSpan(ctx, "WebhookModel.Delete", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	result, err := model.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Relay moves up to limit events from the outbox into a delivery for every
+// active webhook subscribed to them, oldest first. enqueue is called with
+// each new delivery's ID inside the same transaction, so that scheduling the
+// delivery and consuming the event either both happen or neither does. It
+// returns the number of events relayed.
+func (model WebhookModel) Relay(ctx context.Context, limit int, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (int, error) {
+	ctx, span := startSpan(ctx, "WebhookModel.Relay", "DELETE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	type outboxEvent struct {
+		id         int64
+		occurredAt time.Time
+		event      string
+		userID     *int64
+		payload    []byte
+	}
+
+	var events []outboxEvent
+
+	err := inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM webhook_outbox
+			WHERE id IN (
+				SELECT id FROM webhook_outbox
+				ORDER BY id
+				FOR UPDATE SKIP LOCKED
+				LIMIT $1
+			)
+			RETURNING id, created_at, event, user_id, payload
+		`
+
+		rows, err := tx.QueryContext(ctx, query, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var e outboxEvent
+			if err := rows.Scan(&e.id, &e.occurredAt, &e.event, &e.userID, &e.payload); err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].id < events[j].id
+		})
+
+		query = `
+			INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, occurred_at)
+			SELECT id, $1, $2, $3, $4
+			FROM webhooks
+			WHERE active AND $2 = ANY(events)
+			AND ($5::bigint IS NULL OR user_id = $5)
+			RETURNING id
+		`
+
+		for _, e := range events {
+			var deliveryIDs []int64
+
+			rows, err := tx.QueryContext(ctx, query, e.id, e.event, e.payload, e.occurredAt, e.userID)
+			if err != nil {
+				return err
+			}
+
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return err
+				}
+				deliveryIDs = append(deliveryIDs, id)
+			}
+			rows.Close()
+
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, id := range deliveryIDs {
+				if err := enqueue(ctx, tx, id); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return len(events), nil
+}
+
+const deliveryColumns = `
+	id, created_at, webhook_id, event_id, event, payload, occurred_at,
+	status, attempts, last_attempt_at, response_status, response_body, error
+`
+
+func scanDelivery(scan func(dest ...any) error, delivery *WebhookDelivery, extra ...any) error {
+	var payload []byte
+
+	dest := []any{
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.Event,
+		&payload,
+		&delivery.OccurredAt,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.LastAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.ResponseBody,
+		&delivery.Error,
+	}
+
+	err := scan(append(dest, extra...)...)
+	if err != nil {
+		return err
+	}
+
+	delivery.Payload = payload
+	return nil
+}
+
+// GetDeliveries lists a webhook's deliveries, newest first unless sorted
+// otherwise. An empty status lists them all.
+func (model WebhookModel) GetDeliveries(ctx context.Context, webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		AND ($2 = '' OR status = $2)
+		ORDER BY %s id DESC
+		LIMIT $3 OFFSET $4
+	`, deliveryColumns, filters.sortColumn())
+
+	ctx, span := startSpan(ctx, "WebhookModel.GetDeliveries", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	rows, err := model.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	totalRecords := 0
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := scanDelivery(func(dest ...any) error {
+			return rows.Scan(append([]any{&totalRecords}, dest...)...)
+		}, &delivery)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// GetDelivery returns a delivery together with the webhook it goes to.
+func (model WebhookModel) GetDelivery(ctx context.Context, id int64) (*WebhookDelivery, *Webhook, error) {
+	query := `
+		SELECT d.id, d.created_at, d.webhook_id, d.event_id, d.event, d.payload, d.occurred_at,
+		d.status, d.attempts, d.last_attempt_at, d.response_status, d.response_body, d.error,
+		w.id, w.created_at, w.user_id, w.url, w.secret, w.events, w.active, w.version
+		FROM webhook_deliveries d
+		INNER JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1
+	`
+
+	ctx, span := startSpan(ctx, "WebhookModel.GetDelivery", "SELECT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	var delivery WebhookDelivery
+	var webhook Webhook
+
+	err := scanDelivery(model.DB.QueryRowContext(ctx, query, id).Scan, &delivery,
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, queryError(ctx, err)
+		}
+	}
+
+	return &delivery, &webhook, nil
+}
+
+// RecordAttempt saves the outcome of an attempt to send delivery.
+func (model WebhookModel) RecordAttempt(ctx context.Context, delivery *WebhookDelivery) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, last_attempt_at = $3, response_status = $4, response_body = $5, error = $6
+		WHERE id = $7
+	`
+
+	args := []any{
+		delivery.Status,
+		delivery.Attempts,
+		delivery.LastAttemptAt,
+		delivery.ResponseStatus,
+		delivery.ResponseBody,
+		delivery.Error,
+		delivery.ID,
+	}
+
+	ctx, span := startSpan(ctx, "WebhookModel.RecordAttempt", "UPDATE")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	_, err := model.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+// Redeliver copies one of a webhook's deliveries into a new one for the same
+// event and passes it to enqueue inside the same transaction. The copy keeps
+// the event ID, so receivers can recognise it as a repeat.
+func (model WebhookModel) Redeliver(ctx context.Context, webhookID, deliveryID int64, enqueue func(ctx context.Context, tx *sql.Tx, deliveryID int64) error) (*WebhookDelivery, error) {
+	query := fmt.Sprintf(`
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload, occurred_at)
+		SELECT webhook_id, event_id, event, payload, occurred_at
+		FROM webhook_deliveries
+		WHERE id = $1 AND webhook_id = $2
+		RETURNING %s
+	`, deliveryColumns)
+
+	ctx, span := startSpan(ctx, "WebhookModel.Redeliver", "INSERT")
+	defer span.End()
+
+	ctx, cancel := withTimeout(ctx, model.Timeout)
+	defer cancel()
+
+	var delivery WebhookDelivery
+
+	err := inTx(ctx, model.DB, func(tx *sql.Tx) error {
+		err := scanDelivery(tx.QueryRowContext(ctx, query, deliveryID, webhookID).Scan, &delivery)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRecordNotFound
+			}
+			return err
+		}
+
+		return enqueue(ctx, tx, delivery.ID)
+	})
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return &delivery, nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/validator"
+)
+
+// TestValidateWebhook tests the webhook validation function
+func TestValidateWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		wantKey string
+	}{
+		{name: "Valid", webhook: Webhook{URL: "https://example.com/hooks", Events: []string{EventFilmCreated, EventWatchlistWatched}}},
+		{name: "Plain http", webhook: Webhook{URL: "http://10.0.0.5:8080/hook", Events: []string{EventFilmDeleted}}},
+		{name: "Missing URL", webhook: Webhook{Events: []string{EventFilmCreated}}, wantKey: "url"},
+		{name: "Relative URL", webhook: Webhook{URL: "/hooks", Events: []string{EventFilmCreated}}, wantKey: "url"},
+		{name: "Other scheme", webhook: Webhook{URL: "ftp://example.com/hooks", Events: []string{EventFilmCreated}}, wantKey: "url"},
+		{name: "No events", webhook: Webhook{URL: "https://example.com/hooks"}, wantKey: "events"},
+		{name: "Unknown event", webhook: Webhook{URL: "https://example.com/hooks", Events: []string{"film.rated"}}, wantKey: "events"},
+		{name: "Duplicate events", webhook: Webhook{URL: "https://example.com/hooks", Events: []string{EventFilmCreated, EventFilmCreated}}, wantKey: "events"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateWebhook(v, &tt.webhook)
+
+			if tt.wantKey == "" {
+				if !v.Valid() {
+					t.Errorf("ValidateWebhook() errors = %v, want none", v.Errors)
+				}
+				return
+			}
+
+			if _, exists := v.Errors[tt.wantKey]; !exists {
+				t.Errorf("ValidateWebhook() errors = %v, want one for %q", v.Errors, tt.wantKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+// Package oidc implements the relying-party side of the OpenID Connect
+// authorization-code flow with PKCE: provider discovery, the code exchange,
+// JWKS caching and ID token validation.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken  = errors.New("oidc: invalid id token")
+	ErrUnknownKey    = errors.New("oidc: id token signed with an unknown key")
+	ErrExchange      = errors.New("oidc: authorization code exchange failed")
+	ErrIssuerMissing = errors.New("oidc: issuer must be provided")
+)
+
+// Config holds the client registration details for a single provider.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// discovery is the subset of the provider metadata document we rely on.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims are the ID token claims the API cares about.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience accepts both the single string and the array form of "aud".
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+
+	*a = many
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Provider talks to one OpenID Connect provider. It is safe for concurrent use.
+type Provider struct {
+	config   Config
+	client   *http.Client
+	metadata discovery
+	keys     *keySet
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// NewProvider fetches the provider's discovery document and checks that it
+// describes the configured issuer.
+func NewProvider(ctx context.Context, cfg Config, client *http.Client) (*Provider, error) {
+	if cfg.IssuerURL == "" {
+		return nil, ErrIssuerMissing
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+
+	var metadata discovery
+	if err := getJSON(ctx, client, wellKnown, &metadata); err != nil {
+		return nil, fmt.Errorf("oidc: discovery failed: %w", err)
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(cfg.IssuerURL, "/") {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %q got %q", cfg.IssuerURL, metadata.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing required endpoints")
+	}
+
+	provider := &Provider{
+		config:   cfg,
+		client:   client,
+		metadata: metadata,
+		now:      time.Now,
+	}
+	provider.keys = newKeySet(client, metadata.JWKSURI, provider.nowFunc)
+
+	return provider, nil
+}
+
+func (p *Provider) nowFunc() time.Time {
+	return p.now()
+}
+
+// Issuer returns the issuer identifier as advertised by the provider.
+func (p *Provider) Issuer() string {
+	return p.metadata.Issuer
+}
+
+// AuthCodeURL builds the URL the user agent is sent to in order to log in.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.metadata.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange trades an authorization code for the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s: %s", ErrExchange, res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: response did not include an id_token", ErrExchange)
+	}
+
+	return tokens.IDToken, nil
+}
+
+// Verify checks the ID token's signature and standard claims and returns the
+// decoded claims. nonce must match the value sent with the auth request.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	token, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.keys.get(ctx, token.header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.verify(key); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(token.payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	// Allow a little clock skew between us and the provider.
+	const leeway = time.Minute
+	now := p.now()
+
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: token not issued for this client", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party", ErrInvalidToken)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidToken)
+	case claims.IssuedAt != 0 && time.Unix(claims.IssuedAt, 0).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// S256Challenge derives the PKCE code challenge for a verifier.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dst)
+}
+
+// LoginState is what we need to remember between redirecting the user to the
+// provider and handling the callback.
+type LoginState struct {
+	Verifier string
+	Nonce    string
+	Expires  time.Time
+}
+
+// StateStore keeps pending logins keyed by their state parameter. Entries are
+// single-use and expire after ttl. Because it lives in memory, the callback
+// has to reach the same process that started the login.
+type StateStore struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	states map[string]LoginState
+}
+
+func NewStateStore(ttl time.Duration) *StateStore {
+	return &StateStore{
+		ttl:    ttl,
+		states: make(map[string]LoginState),
+	}
+}
+
+// Begin creates a new pending login and returns its state parameter.
+func (s *StateStore) Begin() (string, LoginState, error) {
+	state, err := randomString(24)
+	if err != nil {
+		return "", LoginState{}, err
+	}
+
+	nonce, err := randomString(24)
+	if err != nil {
+		return "", LoginState{}, err
+	}
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		return "", LoginState{}, err
+	}
+
+	login := LoginState{
+		Verifier: verifier,
+		Nonce:    nonce,
+		Expires:  time.Now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, pending := range s.states {
+		if now.After(pending.Expires) {
+			delete(s.states, key)
+		}
+	}
+
+	s.states[state] = login
+
+	return state, login, nil
+}
+
+// Take removes and returns the pending login for state, if it exists and
+// hasn't expired.
+func (s *StateStore) Take(state string) (LoginState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	login, ok := s.states[state]
+	if !ok {
+		return LoginState{}, false
+	}
+
+	delete(s.states, state)
+
+	if time.Now().After(login.Expires) {
+		return LoginState{}, false
+	}
+
+	return login, true
+}
@@ -0,0 +1,241 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+
+	idp := oidctest.NewServer("film-api", "s3cret")
+	t.Cleanup(idp.Close)
+
+	provider, err := NewProvider(context.Background(), Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     "film-api",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:4000/v1/auth/oidc/callback",
+	}, idp.Client())
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+
+	return provider, idp
+}
+
+// authorize follows the provider's authorization endpoint and returns the
+// code and state it redirects back with.
+func authorize(t *testing.T, provider *Provider, idp *oidctest.Server, state, nonce, challenge string) (string, string) {
+	t.Helper()
+
+	client := idp.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(provider.AuthCodeURL(state, nonce, challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned status %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// TestAuthorizationCodeFlow tests a full login against the stand-in provider
+func TestAuthorizationCodeFlow(t *testing.T) {
+	provider, idp := newTestProvider(t)
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := authorize(t, provider, idp, "state-1", "nonce-1", S256Challenge(verifier))
+	if state != "state-1" {
+		t.Errorf("state = %q, want %q", state, "state-1")
+	}
+
+	rawIDToken, err := provider.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	claims, err := provider.Verify(context.Background(), rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Subject != "user-1" {
+		t.Errorf("Verify() claims = %+v", claims)
+	}
+}
+
+// TestExchangeRejectsWrongVerifier tests that the provider enforces PKCE
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	provider, idp := newTestProvider(t)
+
+	verifier, _ := NewVerifier()
+	code, _ := authorize(t, provider, idp, "state", "nonce", S256Challenge(verifier))
+
+	_, err := provider.Exchange(context.Background(), code, "not-the-verifier")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("Exchange() error = %v, want %v", err, ErrExchange)
+	}
+}
+
+// TestVerify tests ID token validation
+func TestVerify(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	identity := oidctest.Identity{Subject: "user-1", Email: "alice@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name    string
+		mutate  func(claims map[string]any)
+		token   func(claims map[string]any) string
+		wantErr bool
+	}{
+		{
+			name:   "Valid token",
+			mutate: func(map[string]any) {},
+		},
+		{
+			name:    "Wrong nonce",
+			mutate:  func(c map[string]any) { c["nonce"] = "other" },
+			wantErr: true,
+		},
+		{
+			name:    "Expired",
+			mutate:  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: true,
+		},
+		{
+			name:    "Other audience",
+			mutate:  func(c map[string]any) { c["aud"] = "someone-else" },
+			wantErr: true,
+		},
+		{
+			name:    "Other issuer",
+			mutate:  func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+			wantErr: true,
+		},
+		{
+			name:   "Audience array",
+			mutate: func(c map[string]any) { c["aud"] = []string{"film-api"} },
+		},
+		{
+			name:   "Tampered payload",
+			mutate: func(map[string]any) {},
+			token: func(c map[string]any) string {
+				signed := idp.SignIDToken(c)
+				c["sub"] = "admin"
+				forged := idp.SignIDToken(c)
+				// Combine the forged payload with the original signature.
+				return splitJWT(forged)[0] + "." + splitJWT(forged)[1] + "." + splitJWT(signed)[2]
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Unsigned",
+			mutate: func(map[string]any) {},
+			token: func(c map[string]any) string {
+				parts := splitJWT(idp.SignIDToken(c))
+				// {"alg":"none"}
+				return "eyJhbGciOiJub25lIn0." + parts[1] + "."
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idp.Claims(identity, "nonce")
+			tt.mutate(claims)
+
+			raw := ""
+			if tt.token != nil {
+				raw = tt.token(claims)
+			} else {
+				raw = idp.SignIDToken(claims)
+			}
+
+			_, err := provider.Verify(context.Background(), raw, "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestVerifyKeyRotation tests that the JWKS cache picks up rotated keys
+// without refetching on every unknown key ID
+func TestVerifyKeyRotation(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	identity := oidctest.Identity{Subject: "user-1", Email: "alice@example.com", EmailVerified: true}
+
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+
+	if _, err := provider.Verify(context.Background(), idp.SignIDToken(idp.Claims(identity, "n")), "n"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	idp.RotateKey()
+	rotated := idp.SignIDToken(idp.Claims(identity, "n"))
+
+	// The keys were fetched moments ago, so an unknown key ID is rejected.
+	if _, err := provider.Verify(context.Background(), rotated, "n"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	now = now.Add(2 * keySetMinRefresh)
+	if _, err := provider.Verify(context.Background(), rotated, "n"); err != nil {
+		t.Errorf("Verify() after refresh interval error = %v", err)
+	}
+}
+
+// TestStateStore tests that pending logins are single-use
+func TestStateStore(t *testing.T) {
+	store := NewStateStore(time.Minute)
+
+	state, login, err := store.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := store.Take(state)
+	if !ok || got != login {
+		t.Errorf("Take() = %+v, %v, want %+v, true", got, ok, login)
+	}
+
+	if _, ok := store.Take(state); ok {
+		t.Error("Take() returned the same state twice")
+	}
+
+	expired := NewStateStore(-time.Second)
+	state, _, _ = expired.Begin()
+	if _, ok := expired.Take(state); ok {
+		t.Error("Take() returned an expired state")
+	}
+}
+
+func splitJWT(raw string) []string {
+	return strings.Split(raw, ".")
+}
@@ -0,0 +1,271 @@
+// Package oidctest provides a minimal in-process OpenID Connect provider for
+// tests. It implements discovery, a JWKS endpoint, an authorization endpoint
+// that logs in a configurable identity without any user interaction, and a
+// token endpoint that enforces PKCE.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Identity is the end user the provider logs in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	identity    Identity
+}
+
+// Server is a stand-in identity provider backed by an httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	kid      string
+	keyCount int
+	identity Identity
+	codes    map[string]grant
+}
+
+// NewServer starts a provider that accepts the given client credentials.
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]grant),
+		identity: Identity{
+			Subject:       "user-1",
+			Email:         "alice@example.com",
+			EmailVerified: true,
+			Name:          "Alice",
+		},
+	}
+	s.RotateKey()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("GET /jwks", s.jwks)
+	mux.HandleFunc("GET /authorize", s.authorize)
+	mux.HandleFunc("POST /token", s.token)
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Issuer returns the issuer identifier, which is the server's base URL.
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// SetIdentity changes who the next authorization request logs in as.
+func (s *Server) SetIdentity(identity Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = identity
+}
+
+// RotateKey replaces the signing key with a fresh one under a new key ID.
+func (s *Server) RotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyCount++
+	s.key = key
+	s.kid = "key-" + strconv.Itoa(s.keyCount)
+}
+
+// SignIDToken signs arbitrary claims with the current key, which lets tests
+// craft tokens that are expired, for another audience and so on.
+func (s *Server) SignIDToken(claims map[string]any) string {
+	s.mu.Lock()
+	key, kid := s.key, s.kid
+	s.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Claims returns a valid set of ID token claims for identity and nonce.
+func (s *Server) Claims(identity Identity, nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            s.Issuer(),
+		"sub":            identity.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.Issuer(),
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	key, kid := s.key, s.kid
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "pkce required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.String() == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	s.mu.Lock()
+	s.codes[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: redirectURI.String(),
+		identity:    s.identity,
+	}
+	s.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	s.mu.Lock()
+	g, found := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	verifierSum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(verifierSum[:])
+
+	switch {
+	case !found, r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	case g.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect_uri mismatch"})
+		return
+	case challenge != g.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "pkce verification failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     s.SignIDToken(s.Claims(g.identity, g.nonce)),
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
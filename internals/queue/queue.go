@@ -0,0 +1,375 @@
+// Package queue is a durable task queue. Tasks are stored by a Store
+// (normally Postgres) and run by a pool of workers that dispatch them by kind
+// to registered handlers. Failed tasks are retried with exponential backoff
+// and dead-lettered once they run out of attempts. Delivery is at least once,
+// so handlers should be idempotent.
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+var (
+	ErrDuplicateKind = errors.New("queue: a handler for this kind already exists")
+	ErrUnknownKind   = errors.New("queue: no handler for this kind")
+	ErrStarted       = errors.New("queue: handlers can't be added after Start")
+)
+
+// HandlerFunc processes one task. An error schedules a retry unless it is
+// wrapped with Permanent.
+type HandlerFunc func(ctx context.Context, task *Task) error
+
+// Config tunes the workers. Zero fields take the defaults noted below.
+type Config struct {
+	// Workers is how many tasks run at once (default 4).
+	Workers int
+	// PollInterval is how long an idle worker waits before looking for work
+	// again (default 1s). Tasks enqueued by this process wake a worker early.
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a task stays claimed. A handler is
+	// cancelled when it runs out, and a task whose worker died becomes
+	// available again after it (default 5m).
+	VisibilityTimeout time.Duration
+	// MaxAttempts is how many times a task is tried before it's dead-lettered
+	// (default 5).
+	MaxAttempts int
+	// BackoffBase is the delay before the first retry, doubling for each
+	// later one up to BackoffMax (defaults 10s and 1h).
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+type Queue struct {
+	store  Store
+	logger *jsonlog.Logger
+	config Config
+
+	mu       sync.Mutex
+	handlers map[string]HandlerFunc
+	kinds    []string
+	started  bool
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+
+	wake chan struct{}
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+func New(store Store, logger *jsonlog.Logger, config Config) *Queue {
+	if config.Workers <= 0 {
+		config.Workers = 4
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Second
+	}
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = 5 * time.Minute
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.BackoffBase <= 0 {
+		config.BackoffBase = 10 * time.Second
+	}
+	if config.BackoffMax <= 0 {
+		config.BackoffMax = time.Hour
+	}
+
+	return &Queue{
+		store:    store,
+		logger:   logger,
+		config:   config,
+		handlers: make(map[string]HandlerFunc),
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+	}
+}
+
+// Handle registers the handler for a kind of task. It must be called before
+// Start.
+func (q *Queue) Handle(kind string, handler HandlerFunc) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.started {
+		return ErrStarted
+	}
+
+	if _, exists := q.handlers[kind]; exists {
+		return ErrDuplicateKind
+	}
+
+	q.handlers[kind] = handler
+	q.kinds = append(q.kinds, kind)
+
+	return nil
+}
+
+// Register is Handle for a handler that takes its payload decoded into T. A
+// payload that can't be decoded is dead-lettered straight away.
+func Register[T any](q *Queue, kind string, handler func(ctx context.Context, payload T) error) error {
+	return q.Handle(kind, func(ctx context.Context, task *Task) error {
+		var payload T
+
+		err := json.Unmarshal(task.Payload, &payload)
+		if err != nil {
+			return Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+
+		return handler(ctx, payload)
+	})
+}
+
+// Enqueue adds a task to run as soon as a worker is free. payload is stored
+// as JSON.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any) (*Task, error) {
+	return q.EnqueueAt(ctx, kind, payload, q.now())
+}
+
+// EnqueueAt adds a task that won't run before runAt.
+func (q *Queue) EnqueueAt(ctx context.Context, kind string, payload any, runAt time.Time) (*Task, error) {
+	task, err := q.newTask(kind, payload, runAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = q.store.Enqueue(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	q.notify()
+
+	return task, nil
+}
+
+// EnqueueTx adds a task as part of tx, for work that must happen if and only
+// if the transaction commits. The store has to implement TxStore.
+func (q *Queue) EnqueueTx(ctx context.Context, tx *sql.Tx, kind string, payload any) (*Task, error) {
+	store, ok := q.store.(TxStore)
+	if !ok {
+		return nil, ErrNoTxSupport
+	}
+
+	task, err := q.newTask(kind, payload, q.now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.EnqueueTx(ctx, tx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	// Waking a worker before the commit is harmless; it just finds nothing
+	// and the task is picked up on a later poll.
+	q.notify()
+
+	return task, nil
+}
+
+func (q *Queue) newTask(kind string, payload any, runAt time.Time) (*Task, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Task{
+		Kind:        kind,
+		Payload:     js,
+		MaxAttempts: q.config.MaxAttempts,
+		RunAt:       runAt,
+	}, nil
+}
+
+// notify wakes an idle worker, if there is one.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Dead lists up to limit dead-lettered tasks, newest first.
+func (q *Queue) Dead(ctx context.Context, limit int) ([]*Task, error) {
+	return q.store.Dead(ctx, limit)
+}
+
+// Requeue gives a dead-lettered task another full set of attempts.
+func (q *Queue) Requeue(ctx context.Context, id int64) error {
+	err := q.store.Requeue(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	q.notify()
+
+	return nil
+}
+
+// Start launches the workers. They stop when ctx is cancelled or Stop is
+// called. Without any handlers no workers are started.
+func (q *Queue) Start(ctx context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.started {
+		return
+	}
+	q.started = true
+
+	if len(q.handlers) == 0 {
+		return
+	}
+
+	ctx, q.cancel = context.WithCancel(ctx)
+
+	for i := 0; i < q.config.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+}
+
+// Stop cancels running handlers and waits for the workers to return. Tasks
+// interrupted this way are put back to run again straight away.
+func (q *Queue) Stop() {
+	q.mu.Lock()
+	cancel := q.cancel
+	q.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	q.wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	for ctx.Err() == nil {
+		task, err := q.store.Claim(ctx, q.kinds, q.config.VisibilityTimeout)
+		if err != nil && ctx.Err() == nil {
+			q.logger.PrintError(err, nil)
+		}
+
+		if task != nil {
+			q.process(ctx, task)
+			continue
+		}
+
+		timer := time.NewTimer(q.config.PollInterval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-q.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+func (q *Queue) process(ctx context.Context, task *Task) {
+	var err error
+
+	// A task claimed once too often timed out on every attempt, most likely
+	// because it crashes or hangs its worker.
+	if task.Attempts > task.MaxAttempts {
+		err = Permanent(errors.New("visibility timeout expired on every attempt"))
+	} else {
+		runCtx, cancel := context.WithTimeout(ctx, q.config.VisibilityTimeout)
+		err = q.call(runCtx, task)
+		cancel()
+	}
+
+	// The worker's context may be cancelled already, but the outcome still
+	// has to be saved.
+	storeCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	properties := map[string]string{
+		"task_id":  strconv.FormatInt(task.ID, 10),
+		"kind":     task.Kind,
+		"attempts": strconv.Itoa(task.Attempts),
+	}
+
+	var permanent *permanentError
+
+	switch {
+	case err == nil:
+		err = q.store.Complete(storeCtx, task)
+	case ctx.Err() != nil:
+		err = q.store.Retry(storeCtx, task, q.now(), "interrupted by shutdown")
+	case errors.As(err, &permanent) || task.Attempts >= task.MaxAttempts:
+		q.logger.PrintError(fmt.Errorf("task dead-lettered: %w", err), properties)
+		err = q.store.Bury(storeCtx, task, err.Error())
+	default:
+		q.logger.PrintError(err, properties)
+		err = q.store.Retry(storeCtx, task, q.now().Add(q.backoff(task.Attempts)), err.Error())
+	}
+
+	if err != nil {
+		q.logger.PrintError(err, properties)
+	}
+}
+
+// call runs the task's handler, turning a panic into an error.
+func (q *Queue) call(ctx context.Context, task *Task) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	handler, ok := q.handlers[task.Kind]
+	if !ok {
+		return Permanent(ErrUnknownKind)
+	}
+
+	return handler(ctx, task)
+}
+
+// backoff is the delay before retrying a task that has failed attempts
+// times.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.config.BackoffBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= q.config.BackoffMax {
+			return q.config.BackoffMax
+		}
+	}
+
+	return min(delay, q.config.BackoffMax)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying; the task is dead-lettered
+// instead.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
@@ -0,0 +1,482 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+// memoryStore is a Store kept in a map, standing in for the tasks table.
+type memoryStore struct {
+	mu     sync.Mutex
+	nextID int64
+	tasks  map[int64]*Task
+	locked map[int64]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{tasks: make(map[int64]*Task), locked: make(map[int64]time.Time)}
+}
+
+func (s *memoryStore) Enqueue(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	task.ID = s.nextID
+	task.CreatedAt = time.Now()
+	task.Status = StatusPending
+
+	stored := *task
+	s.tasks[task.ID] = &stored
+	return nil
+}
+
+func (s *memoryStore) Claim(ctx context.Context, kinds []string, visibility time.Duration) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id := int64(1); id <= s.nextID; id++ {
+		task, ok := s.tasks[id]
+		if !ok || !contains(kinds, task.Kind) {
+			continue
+		}
+
+		due := task.Status == StatusPending && !task.RunAt.After(now)
+		expired := task.Status == StatusRunning && s.locked[id].Before(now)
+		if !due && !expired {
+			continue
+		}
+
+		task.Status = StatusRunning
+		task.Attempts++
+		s.locked[id] = now.Add(visibility)
+
+		claimed := *task
+		return &claimed, nil
+	}
+
+	return nil, nil
+}
+
+func (s *memoryStore) current(task *Task) *Task {
+	stored, ok := s.tasks[task.ID]
+	if !ok || stored.Status != StatusRunning || stored.Attempts != task.Attempts {
+		return nil
+	}
+	return stored
+}
+
+func (s *memoryStore) Complete(ctx context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current(task) != nil {
+		delete(s.tasks, task.ID)
+	}
+	return nil
+}
+
+func (s *memoryStore) Retry(ctx context.Context, task *Task, runAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored := s.current(task); stored != nil {
+		stored.Status = StatusPending
+		stored.RunAt = runAt
+		stored.LastError = lastError
+	}
+	return nil
+}
+
+func (s *memoryStore) Bury(ctx context.Context, task *Task, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored := s.current(task); stored != nil {
+		stored.Status = StatusDead
+		stored.LastError = lastError
+	}
+	return nil
+}
+
+func (s *memoryStore) Dead(ctx context.Context, limit int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := []*Task{}
+	for id := s.nextID; id >= 1 && len(tasks) < limit; id-- {
+		if task, ok := s.tasks[id]; ok && task.Status == StatusDead {
+			copied := *task
+			tasks = append(tasks, &copied)
+		}
+	}
+	return tasks, nil
+}
+
+func (s *memoryStore) Requeue(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok || task.Status != StatusDead {
+		return ErrTaskNotFound
+	}
+
+	task.Status = StatusPending
+	task.Attempts = 0
+	task.RunAt = time.Now()
+	return nil
+}
+
+// get returns a copy of a stored task, or nil once it has been completed.
+func (s *memoryStore) get(id int64) *Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[id]
+	if !ok {
+		return nil
+	}
+
+	copied := *task
+	return &copied
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// syncWriter lets the test read the log while workers write to it.
+type syncWriter struct {
+	mu sync.Mutex
+	w  bytes.Buffer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *syncWriter) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.String()
+}
+
+func newTestQueue(store Store, config Config) (*Queue, *syncWriter) {
+	logs := &syncWriter{}
+	if config.PollInterval == 0 {
+		config.PollInterval = 5 * time.Millisecond
+	}
+	if config.BackoffBase == 0 {
+		config.BackoffBase = time.Millisecond
+	}
+	return New(store, jsonlog.New(logs, jsonlog.LevelInfo), config), logs
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestRegister tests that a typed handler receives its decoded payload and
+// that the finished task is removed
+func TestRegister(t *testing.T) {
+	type greeting struct {
+		Name string `json:"name"`
+	}
+
+	store := newMemoryStore()
+	q, _ := newTestQueue(store, Config{})
+
+	received := make(chan string, 1)
+	err := Register(q, "greet", func(ctx context.Context, payload greeting) error {
+		received <- payload.Name
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.Start(context.Background())
+	defer q.Stop()
+
+	task, err := q.Enqueue(context.Background(), "greet", greeting{Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case name := <-received:
+		if name != "Ada" {
+			t.Errorf("got payload name %q, want %q", name, "Ada")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("task was never run")
+	}
+
+	waitFor(t, "task to be completed", func() bool { return store.get(task.ID) == nil })
+}
+
+// TestRetryAndDeadLetter tests that a failing task is retried until it runs
+// out of attempts and is then dead-lettered with its last error
+func TestRetryAndDeadLetter(t *testing.T) {
+	store := newMemoryStore()
+	q, logs := newTestQueue(store, Config{MaxAttempts: 3})
+
+	var calls atomic.Int32
+	q.Handle("flaky", func(ctx context.Context, task *Task) error {
+		calls.Add(1)
+		return errors.New("upstream unavailable")
+	})
+
+	q.Start(context.Background())
+	defer q.Stop()
+
+	task, _ := q.Enqueue(context.Background(), "flaky", nil)
+
+	waitFor(t, "task to be dead-lettered", func() bool {
+		stored := store.get(task.ID)
+		return stored != nil && stored.Status == StatusDead
+	})
+
+	stored := store.get(task.ID)
+	if stored.Attempts != 3 || calls.Load() != 3 {
+		t.Errorf("got %d attempts and %d calls, want 3 of each", stored.Attempts, calls.Load())
+	}
+	if stored.LastError != "upstream unavailable" {
+		t.Errorf("got last error %q", stored.LastError)
+	}
+	if !strings.Contains(logs.String(), "task dead-lettered") {
+		t.Error("dead-lettering was not logged")
+	}
+
+	dead, _ := q.Dead(context.Background(), 10)
+	if len(dead) != 1 || dead[0].ID != task.ID {
+		t.Errorf("Dead() = %v, want the failed task", dead)
+	}
+}
+
+// TestPermanentFailures tests that undecodable payloads and Permanent errors
+// are dead-lettered on the first attempt, and that panics are recorded as
+// failures
+func TestPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   any
+		wantError string
+	}{
+		{name: "Undecodable payload", payload: "not an object", wantError: "decoding payload"},
+		{name: "Permanent error", payload: map[string]int{"n": 1}, wantError: "bad request"},
+		{name: "Panic", payload: map[string]int{"n": 2}, wantError: "panic: boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			q, _ := newTestQueue(store, Config{MaxAttempts: 5})
+
+			Register(q, "job", func(ctx context.Context, payload struct{ N int }) error {
+				if payload.N == 2 {
+					panic("boom")
+				}
+				return Permanent(errors.New("bad request"))
+			})
+
+			q.Start(context.Background())
+			defer q.Stop()
+
+			task, _ := q.Enqueue(context.Background(), "job", tt.payload)
+
+			waitFor(t, "task to be dead-lettered", func() bool {
+				stored := store.get(task.ID)
+				return stored != nil && stored.Status == StatusDead
+			})
+
+			stored := store.get(task.ID)
+			if tt.name != "Panic" && stored.Attempts != 1 {
+				t.Errorf("got %d attempts, want 1", stored.Attempts)
+			}
+			if !strings.Contains(stored.LastError, tt.wantError) {
+				t.Errorf("got last error %q, want it to contain %q", stored.LastError, tt.wantError)
+			}
+		})
+	}
+}
+
+// TestVisibilityTimeout tests that a task abandoned by its worker is picked
+// up again, and dead-lettered once it has timed out on every attempt
+func TestVisibilityTimeout(t *testing.T) {
+	store := newMemoryStore()
+	q, _ := newTestQueue(store, Config{MaxAttempts: 2, VisibilityTimeout: 10 * time.Millisecond})
+
+	var calls atomic.Int32
+	q.Handle("slow", func(ctx context.Context, task *Task) error {
+		calls.Add(1)
+		return nil
+	})
+
+	task, _ := q.Enqueue(context.Background(), "slow", nil)
+
+	// Two workers that claim the task and die before finishing it.
+	for i := 0; i < 2; i++ {
+		waitFor(t, "task to be claimable", func() bool {
+			claimed, _ := store.Claim(context.Background(), []string{"slow"}, 10*time.Millisecond)
+			return claimed != nil
+		})
+	}
+
+	q.Start(context.Background())
+	defer q.Stop()
+
+	waitFor(t, "task to be dead-lettered", func() bool {
+		stored := store.get(task.ID)
+		return stored != nil && stored.Status == StatusDead
+	})
+
+	if calls.Load() != 0 {
+		t.Errorf("handler ran %d times, want 0", calls.Load())
+	}
+}
+
+// TestStop tests that a task interrupted by Stop is made available again
+// straight away
+func TestStop(t *testing.T) {
+	store := newMemoryStore()
+	q, _ := newTestQueue(store, Config{})
+
+	started := make(chan struct{})
+	q.Handle("long", func(ctx context.Context, task *Task) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	q.Start(context.Background())
+
+	task, _ := q.Enqueue(context.Background(), "long", nil)
+	<-started
+	q.Stop()
+
+	stored := store.get(task.ID)
+	if stored == nil || stored.Status != StatusPending {
+		t.Fatalf("got task %+v, want it pending", stored)
+	}
+	if stored.LastError != "interrupted by shutdown" {
+		t.Errorf("got last error %q", stored.LastError)
+	}
+	if stored.RunAt.After(time.Now()) {
+		t.Errorf("interrupted task was delayed until %v", stored.RunAt)
+	}
+}
+
+// TestRequeue tests that a dead task gets a fresh set of attempts
+func TestRequeue(t *testing.T) {
+	store := newMemoryStore()
+	q, _ := newTestQueue(store, Config{})
+
+	var fail atomic.Bool
+	fail.Store(true)
+	q.Handle("job", func(ctx context.Context, task *Task) error {
+		if fail.Load() {
+			return Permanent(errors.New("not yet"))
+		}
+		return nil
+	})
+
+	q.Start(context.Background())
+	defer q.Stop()
+
+	task, _ := q.Enqueue(context.Background(), "job", nil)
+	waitFor(t, "task to be dead-lettered", func() bool {
+		stored := store.get(task.ID)
+		return stored != nil && stored.Status == StatusDead
+	})
+
+	if err := q.Requeue(context.Background(), 999); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Requeue(999) = %v, want ErrTaskNotFound", err)
+	}
+
+	fail.Store(false)
+	if err := q.Requeue(context.Background(), task.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "task to be completed", func() bool { return store.get(task.ID) == nil })
+}
+
+// TestHandle tests the rules for registering handlers
+func TestHandle(t *testing.T) {
+	q, _ := newTestQueue(newMemoryStore(), Config{})
+	noop := func(ctx context.Context, task *Task) error { return nil }
+
+	if err := q.Handle("a", noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Handle("a", noop); !errors.Is(err, ErrDuplicateKind) {
+		t.Errorf("duplicate Handle() = %v, want ErrDuplicateKind", err)
+	}
+
+	q.Start(context.Background())
+	defer q.Stop()
+
+	if err := q.Handle("b", noop); !errors.Is(err, ErrStarted) {
+		t.Errorf("Handle() after Start = %v, want ErrStarted", err)
+	}
+}
+
+// TestBackoff tests that the retry delay doubles up to the maximum
+func TestBackoff(t *testing.T) {
+	q := New(nil, nil, Config{BackoffBase: time.Second, BackoffMax: 10 * time.Second})
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{60, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// TestEnqueueTxUnsupported tests that stores without transaction support are
+// reported rather than silently enqueueing outside the transaction
+func TestEnqueueTxUnsupported(t *testing.T) {
+	q, _ := newTestQueue(newMemoryStore(), Config{})
+
+	_, err := q.EnqueueTx(context.Background(), nil, "job", nil)
+	if !errors.Is(err, ErrNoTxSupport) {
+		t.Errorf("EnqueueTx() = %v, want ErrNoTxSupport", err)
+	}
+}
@@ -0,0 +1,242 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTaskNotFound = errors.New("queue: task not found")
+	ErrNoTxSupport  = errors.New("queue: store can't enqueue inside a transaction")
+)
+
+// Task is a unit of work stored in the queue.
+type Task struct {
+	ID          int64           `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// Task statuses. Finished tasks are deleted rather than marked done.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDead    = "dead"
+)
+
+// Store persists tasks. Complete, Retry and Bury only touch the task if it is
+// still on the attempt that was claimed, so a worker whose visibility timeout
+// ran out can't overwrite the outcome of the worker that took over.
+type Store interface {
+	// Enqueue saves a new task and fills in its ID and CreatedAt.
+	Enqueue(ctx context.Context, task *Task) error
+	// Claim takes the next due task of one of the given kinds, hiding it from
+	// other workers for visibility. It returns nil when there's nothing to do.
+	Claim(ctx context.Context, kinds []string, visibility time.Duration) (*Task, error)
+	Complete(ctx context.Context, task *Task) error
+	// Retry makes the task visible again at runAt.
+	Retry(ctx context.Context, task *Task, runAt time.Time, lastError string) error
+	// Bury moves the task to the dead-letter state.
+	Bury(ctx context.Context, task *Task, lastError string) error
+	// Dead lists dead-lettered tasks, newest first.
+	Dead(ctx context.Context, limit int) ([]*Task, error)
+	// Requeue gives a dead-lettered task a fresh set of attempts.
+	Requeue(ctx context.Context, id int64) error
+}
+
+// TxStore is implemented by stores that can add a task as part of the
+// caller's database transaction, so the task only exists if the transaction
+// commits.
+type TxStore interface {
+	EnqueueTx(ctx context.Context, tx *sql.Tx, task *Task) error
+}
+
+// PostgresStore keeps tasks in the tasks table. Workers claim them with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of processes can share it.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (store *PostgresStore) Enqueue(ctx context.Context, task *Task) error {
+	return insertTask(ctx, store.DB, task)
+}
+
+func (store *PostgresStore) EnqueueTx(ctx context.Context, tx *sql.Tx, task *Task) error {
+	return insertTask(ctx, tx, task)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertTask(ctx context.Context, q queryRower, task *Task) error {
+	query := `
+		INSERT INTO tasks (kind, payload, max_attempts, run_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, status
+	`
+
+	args := []any{task.Kind, task.Payload, task.MaxAttempts, task.RunAt}
+
+	return q.QueryRowContext(ctx, query, args...).Scan(&task.ID, &task.CreatedAt, &task.Status)
+}
+
+func (store *PostgresStore) Claim(ctx context.Context, kinds []string, visibility time.Duration) (*Task, error) {
+	// A running task whose lock has expired belongs to a worker that died or
+	// stalled, and is handed out again.
+	query := `
+		UPDATE tasks
+		SET status = 'running', attempts = attempts + 1, locked_until = NOW() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id FROM tasks
+			WHERE kind = ANY($1)
+			AND (
+				(status = 'pending' AND run_at <= NOW())
+				OR (status = 'running' AND locked_until < NOW())
+			)
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error
+	`
+
+	var task Task
+
+	err := store.DB.QueryRowContext(ctx, query, pq.Array(kinds), visibility.Seconds()).Scan(
+		&task.ID,
+		&task.CreatedAt,
+		&task.Kind,
+		&task.Payload,
+		&task.Status,
+		&task.Attempts,
+		&task.MaxAttempts,
+		&task.RunAt,
+		&task.LastError,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &task, nil
+}
+
+func (store *PostgresStore) Complete(ctx context.Context, task *Task) error {
+	query := `
+		DELETE FROM tasks
+		WHERE id = $1 AND status = 'running' AND attempts = $2
+	`
+
+	_, err := store.DB.ExecContext(ctx, query, task.ID, task.Attempts)
+	return err
+}
+
+func (store *PostgresStore) Retry(ctx context.Context, task *Task, runAt time.Time, lastError string) error {
+	query := `
+		UPDATE tasks
+		SET status = 'pending', run_at = $3, locked_until = NULL, last_error = $4
+		WHERE id = $1 AND status = 'running' AND attempts = $2
+	`
+
+	_, err := store.DB.ExecContext(ctx, query, task.ID, task.Attempts, runAt, lastError)
+	return err
+}
+
+func (store *PostgresStore) Bury(ctx context.Context, task *Task, lastError string) error {
+	query := `
+		UPDATE tasks
+		SET status = 'dead', locked_until = NULL, last_error = $3
+		WHERE id = $1 AND status = 'running' AND attempts = $2
+	`
+
+	_, err := store.DB.ExecContext(ctx, query, task.ID, task.Attempts, lastError)
+	return err
+}
+
+func (store *PostgresStore) Dead(ctx context.Context, limit int) ([]*Task, error) {
+	query := `
+		SELECT id, created_at, kind, payload, status, attempts, max_attempts, run_at, last_error
+		FROM tasks
+		WHERE status = 'dead'
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`
+
+	rows, err := store.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks := []*Task{}
+
+	for rows.Next() {
+		var task Task
+
+		err := rows.Scan(
+			&task.ID,
+			&task.CreatedAt,
+			&task.Kind,
+			&task.Payload,
+			&task.Status,
+			&task.Attempts,
+			&task.MaxAttempts,
+			&task.RunAt,
+			&task.LastError,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tasks = append(tasks, &task)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+func (store *PostgresStore) Requeue(ctx context.Context, id int64) error {
+	query := `
+		UPDATE tasks
+		SET status = 'pending', attempts = 0, run_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+	`
+
+	result, err := store.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrTaskNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// Locker hands out exclusive, per-key leases shared between processes.
+type Locker interface {
+	// TryAcquire takes the lock for key without waiting. It returns a nil
+	// Lease when another process holds the lock.
+	TryAcquire(ctx context.Context, key int64) (Lease, error)
+}
+
+// Lease is a held lock.
+type Lease interface {
+	// Alive returns an error if the lock may have been lost.
+	Alive(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+// PostgresLocker uses session-level advisory locks. Each lease pins a
+// connection from the pool, so if the process dies the lock is released with
+// its session and another replica can take over.
+type PostgresLocker struct {
+	DB *sql.DB
+}
+
+func NewPostgresLocker(db *sql.DB) *PostgresLocker {
+	return &PostgresLocker{DB: db}
+}
+
+func (locker *PostgresLocker) TryAcquire(ctx context.Context, key int64) (Lease, error) {
+	conn, err := locker.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &postgresLease{conn: conn, key: key}, nil
+}
+
+type postgresLease struct {
+	conn *sql.Conn
+	key  int64
+}
+
+func (lease *postgresLease) Alive(ctx context.Context) error {
+	return lease.conn.PingContext(ctx)
+}
+
+// Release unlocks and returns the connection to the pool. The connection is
+// closed rather than reused if unlocking fails, which drops the lock anyway.
+func (lease *postgresLease) Release(ctx context.Context) error {
+	_, err := lease.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lease.key)
+	if err != nil {
+		lease.conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+
+	closeErr := lease.conn.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
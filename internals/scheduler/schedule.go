@@ -0,0 +1,198 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule decides when a job runs next.
+type Schedule interface {
+	// Next returns the first run time strictly after t.
+	Next(t time.Time) time.Time
+	String() string
+}
+
+type interval time.Duration
+
+// Every runs a job at a fixed interval, measured from the previous run.
+func Every(d time.Duration) Schedule {
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+func (i interval) String() string {
+	return "every " + time.Duration(i).String()
+}
+
+// cron is a parsed five-field cron expression. Each field is a bit set of the
+// values it matches.
+type cron struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a standard five-field cron expression (minute, hour, day
+// of month, month, day of week) or one of the @hourly style aliases. Fields
+// accept *, single values, ranges (1-5), lists (1,15) and steps (*/10, 0-30/5).
+// As in Vixie cron, a job whose day of month and day of week are both
+// restricted runs when either matches. Times are evaluated in the location of
+// the time passed to Next.
+func ParseCron(spec string) (Schedule, error) {
+	expr := strings.TrimSpace(spec)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("scheduler: cron expression %q must have 5 fields", spec)
+	}
+
+	c := &cron{spec: spec}
+
+	bounds := []struct {
+		field    *uint64
+		min, max int
+		name     string
+	}{
+		{&c.minute, 0, 59, "minute"},
+		{&c.hour, 0, 23, "hour"},
+		{&c.dom, 1, 31, "day of month"},
+		{&c.month, 1, 12, "month"},
+		{&c.dow, 0, 7, "day of week"},
+	}
+
+	for i, b := range bounds {
+		set, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("scheduler: cron %s field %q: %w", b.name, fields[i], err)
+		}
+		*b.field = set
+	}
+
+	// Sunday can be written as 0 or 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domRestricted = fields[2] != "*"
+	c.dowRestricted = fields[4] != "*"
+
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step")
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = min, max
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			var err1, err2 error
+			low, err1 = strconv.Atoi(lowPart)
+			high, err2 = strconv.Atoi(highPart)
+			if err1 != nil || err2 != nil {
+				return 0, errors.New("invalid range")
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, errors.New("invalid value")
+			}
+			low, high = value, value
+			if hasStep {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+
+	return set, nil
+}
+
+func (c *cron) String() string {
+	return c.spec
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<t.Day()) != 0
+	dowMatch := c.dow&(1<<int(t.Weekday())) != 0
+
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
+
+// Next walks forward from t, skipping whole months, days and hours that
+// can't match. Five years is enough for any satisfiable expression (e.g.
+// February 29th); past that Next gives up and returns the zero time.
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, spec string) Schedule {
+	t.Helper()
+
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		t.Fatalf("ParseCron(%q) error = %v", spec, err)
+	}
+
+	return schedule
+}
+
+// TestCronNext tests next-run calculation for common expressions
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2025, time.January, 15, 10, 17, 42, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2025, 1, 15, 10, 18, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2025, 1, 15, 10, 30, 0, 0, time.UTC)},
+		{spec: "5/20 * * * *", want: time.Date(2025, 1, 15, 10, 25, 0, 0, time.UTC)},
+		{spec: "0 3 * * *", want: time.Date(2025, 1, 16, 3, 0, 0, 0, time.UTC)},
+		{spec: "@hourly", want: time.Date(2025, 1, 15, 11, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", want: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "30 9 * * 1-5", want: time.Date(2025, 1, 16, 9, 30, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", want: time.Date(2025, 1, 19, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 1,20 * *", want: time.Date(2025, 1, 20, 12, 0, 0, 0, time.UTC)},
+		// Day of month and day of week both restricted: either matches.
+		{spec: "0 0 1 * 5", want: time.Date(2025, 1, 17, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := mustParse(t, tt.spec).Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCronNextImpossible tests that unsatisfiable expressions give up
+func TestCronNextImpossible(t *testing.T) {
+	if got := mustParse(t, "0 0 31 2 *").Next(time.Now()); !got.IsZero() {
+		t.Errorf("Next() = %v, want the zero time", got)
+	}
+}
+
+// TestParseCronErrors tests that malformed expressions are rejected
+func TestParseCronErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@fortnightly",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// TestEvery tests interval schedules
+func TestEvery(t *testing.T) {
+	from := time.Date(2025, 1, 15, 10, 17, 42, 0, time.UTC)
+	schedule := Every(90 * time.Second)
+
+	if got, want := schedule.Next(from), from.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+
+	if schedule.String() != "every 1m30s" {
+		t.Errorf("String() = %q", schedule.String())
+	}
+}
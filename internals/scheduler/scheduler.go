@@ -0,0 +1,294 @@
+// Package scheduler runs recurring jobs inside the API process. Jobs run on
+// an interval or a cron schedule, panics are recovered and reported as
+// failures, and Stop waits for running jobs to finish. With a Locker, only
+// one process at a time (the job's leader) runs each job.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+var (
+	ErrDuplicateJob = errors.New("scheduler: a job with this name already exists")
+	ErrStarted      = errors.New("scheduler: jobs can't be added after Start")
+)
+
+// Job is a unit of recurring work. Run should return promptly once ctx is
+// cancelled.
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+// Status is a snapshot of a job for reporting.
+type Status struct {
+	Name           string     `json:"name"`
+	Schedule       string     `json:"schedule"`
+	Leader         bool       `json:"leader"`
+	Running        bool       `json:"running"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	LastStartedAt  *time.Time `json:"last_started_at,omitempty"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+	LastDuration   string     `json:"last_duration,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	Runs           int        `json:"runs"`
+	Failures       int        `json:"failures"`
+}
+
+type entry struct {
+	job    Job
+	lease  Lease
+	status Status
+}
+
+type Scheduler struct {
+	locker Locker
+	logger *jsonlog.Logger
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	started bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	// now is overridden in tests.
+	now func() time.Time
+}
+
+// New returns a scheduler. locker may be nil, in which case every process
+// runs every job.
+func New(locker Locker, logger *jsonlog.Logger) *Scheduler {
+	return &Scheduler{
+		locker:  locker,
+		logger:  logger,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Add registers a job. It must be called before Start.
+func (s *Scheduler) Add(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return ErrStarted
+	}
+
+	if _, exists := s.entries[job.Name]; exists {
+		return ErrDuplicateJob
+	}
+
+	s.entries[job.Name] = &entry{
+		job: job,
+		status: Status{
+			Name:     job.Name,
+			Schedule: job.Schedule.String(),
+		},
+	}
+
+	return nil
+}
+
+// Start launches a goroutine per job. Jobs stop when ctx is cancelled or Stop
+// is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go s.loop(ctx, e)
+	}
+}
+
+// Stop cancels the running jobs' contexts, waits for them to return and
+// gives up any leadership held.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	s.wg.Wait()
+}
+
+// Statuses returns a snapshot of every job, sorted by name.
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		statuses = append(statuses, e.status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	defer s.wg.Done()
+	defer s.release(e)
+
+	for {
+		next := e.job.Schedule.Next(s.now())
+		if next.IsZero() {
+			s.logger.PrintError(fmt.Errorf("scheduler: job %q will never run again", e.job.Name), nil)
+			return
+		}
+
+		s.mu.Lock()
+		e.status.NextRunAt = &next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(next.Sub(s.now()))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !s.lead(ctx, e) {
+			continue
+		}
+
+		s.run(ctx, e)
+	}
+}
+
+// lead reports whether this process should run the job, taking the job's
+// lock if it isn't held yet. The lock is kept between runs, so leadership
+// only moves when the leader stops or loses its database session.
+func (s *Scheduler) lead(ctx context.Context, e *entry) bool {
+	if s.locker == nil {
+		s.setLeader(e, true)
+		return true
+	}
+
+	if e.lease != nil {
+		if err := e.lease.Alive(ctx); err == nil {
+			return true
+		}
+
+		s.logger.PrintInfo("scheduler lost leadership", map[string]string{"job": e.job.Name})
+		s.release(e)
+	}
+
+	lease, err := s.locker.TryAcquire(ctx, lockKey(e.job.Name))
+	if err != nil {
+		if ctx.Err() == nil {
+			s.logger.PrintError(err, map[string]string{"job": e.job.Name})
+		}
+		return false
+	}
+
+	if lease == nil {
+		return false
+	}
+
+	e.lease = lease
+	s.setLeader(e, true)
+	s.logger.PrintInfo("scheduler took leadership", map[string]string{"job": e.job.Name})
+
+	return true
+}
+
+func (s *Scheduler) release(e *entry) {
+	if e.lease == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := e.lease.Release(ctx); err != nil {
+		s.logger.PrintError(err, map[string]string{"job": e.job.Name})
+	}
+
+	e.lease = nil
+	s.setLeader(e, false)
+}
+
+func (s *Scheduler) setLeader(e *entry, leader bool) {
+	s.mu.Lock()
+	e.status.Leader = leader
+	s.mu.Unlock()
+}
+
+// run executes the job once, turning a panic into an error.
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	started := s.now()
+
+	s.mu.Lock()
+	e.status.Running = true
+	e.status.LastStartedAt = &started
+	s.mu.Unlock()
+
+	panicked := false
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				// Logged from here so the trace shows where the panic happened.
+				panicked = true
+				err = fmt.Errorf("panic: %v", p)
+				s.logger.PrintError(err, map[string]string{"job": e.job.Name})
+			}
+		}()
+
+		return e.job.Run(ctx)
+	}()
+
+	finished := s.now()
+
+	s.mu.Lock()
+	e.status.Running = false
+	e.status.LastFinishedAt = &finished
+	e.status.LastDuration = finished.Sub(started).String()
+	e.status.Runs++
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil && !panicked {
+		s.logger.PrintError(err, map[string]string{
+			"job":      e.job.Name,
+			"failures": strconv.Itoa(e.status.Failures),
+		})
+	}
+}
+
+// lockKey maps a job name onto the 64-bit key space of advisory locks.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("scheduler:" + name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,241 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filmapi.zeyadtarek.net/internals/jsonlog"
+)
+
+// memoryLocker is a Locker shared by schedulers in the same test, standing in
+// for Postgres advisory locks.
+type memoryLocker struct {
+	mu   sync.Mutex
+	held map[int64]bool
+}
+
+func newMemoryLocker() *memoryLocker {
+	return &memoryLocker{held: make(map[int64]bool)}
+}
+
+func (l *memoryLocker) TryAcquire(ctx context.Context, key int64) (Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held[key] {
+		return nil, nil
+	}
+
+	l.held[key] = true
+	return &memoryLease{locker: l, key: key}, nil
+}
+
+type memoryLease struct {
+	locker *memoryLocker
+	key    int64
+}
+
+func (l *memoryLease) Alive(ctx context.Context) error {
+	return nil
+}
+
+func (l *memoryLease) Release(ctx context.Context) error {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+
+	delete(l.locker.held, l.key)
+	return nil
+}
+
+func newTestScheduler(locker Locker) (*Scheduler, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return New(locker, jsonlog.New(&syncWriter{w: &buf}, jsonlog.LevelInfo)), &buf
+}
+
+// syncWriter lets the test read the log while job goroutines write to it.
+type syncWriter struct {
+	mu sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSchedulerRunsJobs tests that interval jobs run repeatedly and that
+// failures and panics are recorded without stopping the job
+func TestSchedulerRunsJobs(t *testing.T) {
+	s, logs := newTestScheduler(nil)
+
+	var ok, failing, panicking atomic.Int32
+	jobs := []Job{
+		{Name: "ok", Schedule: Every(10 * time.Millisecond), Run: func(ctx context.Context) error {
+			ok.Add(1)
+			return nil
+		}},
+		{Name: "failing", Schedule: Every(10 * time.Millisecond), Run: func(ctx context.Context) error {
+			failing.Add(1)
+			return errors.New("database unavailable")
+		}},
+		{Name: "panicking", Schedule: Every(10 * time.Millisecond), Run: func(ctx context.Context) error {
+			panicking.Add(1)
+			panic("nil map")
+		}},
+	}
+
+	for _, job := range jobs {
+		if err := s.Add(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.Start(context.Background())
+	waitFor(t, "jobs to run twice", func() bool {
+		return ok.Load() >= 2 && failing.Load() >= 2 && panicking.Load() >= 2
+	})
+	s.Stop()
+
+	statuses := s.Statuses()
+	if len(statuses) != 3 || statuses[0].Name != "failing" || statuses[2].Name != "panicking" {
+		t.Fatalf("Statuses() = %+v, want three jobs sorted by name", statuses)
+	}
+
+	for _, status := range statuses {
+		if !status.Leader || status.Runs < 2 || status.LastStartedAt == nil || status.NextRunAt == nil {
+			t.Errorf("status %+v doesn't reflect the runs", status)
+		}
+	}
+
+	if statuses[0].Failures < 2 || statuses[0].LastError != "database unavailable" {
+		t.Errorf("failing job status = %+v", statuses[0])
+	}
+	if statuses[2].Failures < 2 || statuses[2].LastError != "panic: nil map" {
+		t.Errorf("panicking job status = %+v", statuses[2])
+	}
+	if statuses[1].Failures != 0 || statuses[1].LastError != "" {
+		t.Errorf("ok job status = %+v", statuses[1])
+	}
+
+	if !strings.Contains(logs.String(), "panic: nil map") {
+		t.Error("panic was not logged")
+	}
+}
+
+// TestSchedulerStopWaits tests that Stop cancels running jobs and waits for
+// them to return
+func TestSchedulerStopWaits(t *testing.T) {
+	s, _ := newTestScheduler(nil)
+
+	started := make(chan struct{})
+	var finished atomic.Bool
+
+	s.Add(Job{Name: "slow", Schedule: Every(time.Millisecond), Run: func(ctx context.Context) error {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+		return ctx.Err()
+	}})
+
+	s.Start(context.Background())
+	<-started
+	s.Stop()
+
+	if !finished.Load() {
+		t.Error("Stop() returned before the running job finished")
+	}
+}
+
+// TestSchedulerLeaderElection tests that only the process holding a job's
+// lock runs it, and that another takes over once the leader stops
+func TestSchedulerLeaderElection(t *testing.T) {
+	locker := newMemoryLocker()
+
+	var runsA, runsB atomic.Int32
+	a, _ := newTestScheduler(locker)
+	b, _ := newTestScheduler(locker)
+
+	a.Add(Job{Name: "sweep", Schedule: Every(5 * time.Millisecond), Run: func(ctx context.Context) error {
+		runsA.Add(1)
+		return nil
+	}})
+	b.Add(Job{Name: "sweep", Schedule: Every(5 * time.Millisecond), Run: func(ctx context.Context) error {
+		runsB.Add(1)
+		return nil
+	}})
+
+	a.Start(context.Background())
+	waitFor(t, "the first scheduler to lead", func() bool { return runsA.Load() > 0 })
+
+	b.Start(context.Background())
+	time.Sleep(50 * time.Millisecond)
+
+	if runsB.Load() != 0 {
+		t.Fatalf("follower ran the job %d times while the leader held the lock", runsB.Load())
+	}
+	if b.Statuses()[0].Leader {
+		t.Error("follower reports itself as leader")
+	}
+
+	a.Stop()
+	if a.Statuses()[0].Leader {
+		t.Error("stopped scheduler still reports itself as leader")
+	}
+
+	waitFor(t, "the second scheduler to take over", func() bool { return runsB.Load() > 0 })
+	b.Stop()
+}
+
+// TestSchedulerAdd tests job registration rules
+func TestSchedulerAdd(t *testing.T) {
+	s, _ := newTestScheduler(nil)
+	job := Job{Name: "sweep", Schedule: Every(time.Hour), Run: func(ctx context.Context) error { return nil }}
+
+	if err := s.Add(job); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.Add(job); !errors.Is(err, ErrDuplicateJob) {
+		t.Errorf("Add() duplicate error = %v, want ErrDuplicateJob", err)
+	}
+
+	s.Start(context.Background())
+	defer s.Stop()
+
+	job.Name = "late"
+	if err := s.Add(job); !errors.Is(err, ErrStarted) {
+		t.Errorf("Add() after Start error = %v, want ErrStarted", err)
+	}
+}
+
+// TestLockKey tests that job names map to distinct, stable lock keys
+func TestLockKey(t *testing.T) {
+	if lockKey("sweep") != lockKey("sweep") {
+		t.Error("lockKey() is not stable")
+	}
+	if lockKey("sweep") == lockKey("purge") {
+		t.Error("lockKey() collides for different names")
+	}
+}
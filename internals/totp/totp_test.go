@@ -0,0 +1,94 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 test key from RFC 6238 appendix B, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCode tests code generation against the RFC 6238 test vectors
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// TestValidate tests code validation with clock skew
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := Code(rfcSecret, now)
+	previous, _ := Code(rfcSecret, now.Add(-Period))
+	stale, _ := Code(rfcSecret, now.Add(-5*Period))
+
+	tests := []struct {
+		name     string
+		code     string
+		wantOK   bool
+		wantStep int64
+	}{
+		{name: "Current code", code: code, wantOK: true, wantStep: Step(now)},
+		{name: "Previous step within skew", code: previous, wantOK: true, wantStep: Step(now) - 1},
+		{name: "Stale code", code: stale, wantOK: false},
+		{name: "Wrong length", code: "12345", wantOK: false},
+		{name: "Empty", code: "", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, 1)
+			if ok != tt.wantOK {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("Validate() step = %v, want %v", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+// TestGenerateSecret tests that generated secrets produce codes
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secret) != 32 {
+		t.Errorf("GenerateSecret() length = %d, want 32", len(secret))
+	}
+
+	if _, err := Code(secret, time.Now()); err != nil {
+		t.Errorf("Code() with generated secret error = %v", err)
+	}
+}
+
+// TestProvisioningURI tests the otpauth URI format
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI(rfcSecret, "Film API", "alice@example.com")
+
+	for _, want := range []string{"otpauth://totp/Film%20API:alice@example.com?", "secret=" + rfcSecret, "issuer=Film+API", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Errorf("ProvisioningURI() = %q, missing %q", uri, want)
+		}
+	}
+}
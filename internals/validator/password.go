@@ -0,0 +1,208 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy decides whether a new password is acceptable. The zero value
+// only rejects passwords built from the user's own name or email.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength defaults to 72 bytes, the most bcrypt will look at.
+	MaxLength int
+	// MinEntropy is the minimum estimated strength in bits; see EstimateEntropy.
+	MinEntropy float64
+	// Breached, when set, is consulted to reject passwords known from breaches.
+	Breached *BreachedList
+}
+
+// Check runs every rule against password and records the first failure under
+// key. personal holds values the password must not contain, typically the
+// user's name and email address.
+func (policy PasswordPolicy) Check(v *Validator, key, password string, personal ...string) {
+	maxLength := policy.MaxLength
+	if maxLength == 0 {
+		maxLength = 72
+	}
+
+	v.Check(password != "", key, "must be provided")
+	v.Check(len(password) >= policy.MinLength, key, fmt.Sprintf("must be at least %d bytes long", policy.MinLength))
+	v.Check(len(password) <= maxLength, key, fmt.Sprintf("must not be more than %d bytes long", maxLength))
+	v.Check(!ContainsPersonalInfo(password, personal...), key, "must not contain your name or email address")
+	v.Check(EstimateEntropy(password) >= policy.MinEntropy, key, "is too easy to guess, try a longer password or mix in other kinds of characters")
+
+	if policy.Breached != nil {
+		v.Check(!policy.Breached.Contains(password), key, "has appeared in a data breach, please choose a different one")
+	}
+}
+
+// ContainsPersonalInfo reports whether password contains, ignoring case, any
+// of the values or the words they're made of: a name is split into its parts
+// and an email address contributes its local part. Fragments shorter than
+// three characters are ignored.
+func ContainsPersonalInfo(password string, values ...string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range values {
+		value = strings.ToLower(value)
+		if local, _, found := strings.Cut(value, "@"); found {
+			value = local
+		}
+
+		fragments := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		fragments = append(fragments, value)
+
+		for _, fragment := range fragments {
+			if len(fragment) >= 3 && strings.Contains(password, fragment) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// EstimateEntropy gives a rough strength for password in bits. Each character
+// is worth log2 of the size of the character classes used, except that
+// repeated characters ("aaaa") and runs of consecutive ones ("abcd", "4321")
+// only add a single bit each, in the spirit of zxcvbn's pattern matching.
+func EstimateEntropy(password string) float64 {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	perChar := math.Log2(float64(pool))
+	bits := perChar
+
+	for i := 1; i < len(runes); i++ {
+		step := runes[i] - runes[i-1]
+		if step >= -1 && step <= 1 {
+			bits++
+			continue
+		}
+
+		bits += perChar
+	}
+
+	return bits
+}
+
+// BreachedList holds SHA-1 hashes of breached passwords, indexed by the first
+// five hex characters of the hash. Lookups only ever ask for a range of
+// hashes sharing a prefix, the k-anonymity model used by Have I Been Pwned,
+// so the list can later be swapped for a remote range API unchanged.
+type BreachedList struct {
+	ranges map[string][]string
+	count  int
+}
+
+// LoadBreachedList reads a breached-password file; see ReadBreachedList.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadBreachedList(file)
+}
+
+// ReadBreachedList parses one upper or lower case hex SHA-1 hash per line,
+// optionally followed by ":count" as in the Have I Been Pwned downloads.
+// Blank lines and lines starting with # are skipped.
+func ReadBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: make(map[string][]string)}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+
+		if len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("breached list line %d: expected a 40 character SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("breached list line %d: %w", line, err)
+		}
+
+		list.ranges[hash[:5]] = append(list.ranges[hash[:5]], hash[5:])
+		list.count++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range list.ranges {
+		sort.Strings(suffixes)
+	}
+
+	return list, nil
+}
+
+// Len returns the number of hashes in the list.
+func (list *BreachedList) Len() int {
+	return list.count
+}
+
+// Range returns the sorted hash suffixes for a five character prefix.
+func (list *BreachedList) Range(prefix string) []string {
+	return list.ranges[strings.ToUpper(prefix)]
+}
+
+// Contains reports whether password is in the list.
+func (list *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := list.Range(hash[:5])
+	i := sort.SearchStrings(suffixes, hash[5:])
+
+	return i < len(suffixes) && suffixes[i] == hash[5:]
+}
@@ -0,0 +1,128 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// TestPasswordPolicyCheck tests that each rule reports its own message
+func TestPasswordPolicyCheck(t *testing.T) {
+	breached, err := ReadBreachedList(strings.NewReader(strings.ToUpper(sha1Hex("Tr0ub4dor&3")) + ":4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := PasswordPolicy{MinLength: 8, MinEntropy: 40, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  string
+	}{
+		{name: "Strong password", password: "kX9#mQ2p-lantern", wantErr: ""},
+		{name: "Empty", password: "", wantErr: "must be provided"},
+		{name: "Too short", password: "kX9#mQ", wantErr: "must be at least 8 bytes long"},
+		{name: "Too long", password: strings.Repeat("kX9#", 19), wantErr: "must not be more than 72 bytes long"},
+		{name: "Contains name", password: "Sinclair-1987!", wantErr: "must not contain your name or email address"},
+		{name: "Contains email local part", password: "xx-jane.s-99Q", wantErr: "must not contain your name or email address"},
+		{name: "Weak", password: "abcdefgh1234", wantErr: "is too easy to guess, try a longer password or mix in other kinds of characters"},
+		{name: "Breached", password: "Tr0ub4dor&3", wantErr: "has appeared in a data breach, please choose a different one"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			policy.Check(v, "password", tt.password, "Jane Sinclair", "jane.s@example.com")
+
+			if got := v.Errors["password"]; got != tt.wantErr {
+				t.Errorf("error = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestEstimateEntropy tests that patterns score lower than random characters
+func TestEstimateEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		min, max float64
+	}{
+		{name: "Empty", password: "", min: 0, max: 0},
+		{name: "Repeated", password: "aaaaaaaa", min: 11, max: 12},
+		{name: "Sequence", password: "12345678", min: 10, max: 11},
+		{name: "Descending sequence", password: "hgfedcba", min: 11, max: 12},
+		{name: "Mixed classes", password: "kX9#mQ2p", min: 52, max: 53},
+		{name: "Passphrase", password: "correct horse battery staple", min: 100, max: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EstimateEntropy(tt.password)
+			if got < tt.min || got > tt.max {
+				t.Errorf("EstimateEntropy(%q) = %.1f, want between %.0f and %.0f", tt.password, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+// TestContainsPersonalInfo tests matching of names and email addresses
+func TestContainsPersonalInfo(t *testing.T) {
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{password: "JANE-rocks-2024", want: true},
+		{password: "xyzsinclairxyz", want: true},
+		{password: "jane.s!!", want: true},
+		{password: "example-dot-com", want: false},
+		{password: "Jo-9-long-enough", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := ContainsPersonalInfo(tt.password, "Jo Jane Sinclair", "jane.s@example.com"); got != tt.want {
+			t.Errorf("ContainsPersonalInfo(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+// TestBreachedList tests loading and range lookups
+func TestBreachedList(t *testing.T) {
+	file := "# top passwords\n\n" +
+		strings.ToUpper(sha1Hex("password")) + ":9545824\n" +
+		sha1Hex("123456") + "\n"
+
+	list, err := ReadBreachedList(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ReadBreachedList() error = %v", err)
+	}
+
+	if list.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", list.Len())
+	}
+
+	if !list.Contains("password") || !list.Contains("123456") {
+		t.Error("Contains() should find listed passwords, whatever the case of the hash")
+	}
+
+	if list.Contains("Password") {
+		t.Error("Contains() should not find unlisted passwords")
+	}
+
+	hash := strings.ToUpper(sha1Hex("password"))
+	suffixes := list.Range(strings.ToLower(hash[:5]))
+	if len(suffixes) != 1 || suffixes[0] != hash[5:] {
+		t.Errorf("Range(%q) = %v, want [%s]", hash[:5], suffixes, hash[5:])
+	}
+
+	if _, err := ReadBreachedList(strings.NewReader("not-a-hash\n")); err == nil {
+		t.Error("ReadBreachedList() should reject malformed lines")
+	}
+}
@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignAndVerify tests that signatures round-trip and that tampering,
+// wrong secrets and stale timestamps are rejected
+func TestSignAndVerify(t *testing.T) {
+	sentAt := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"film.created"}`)
+	header := Sign("secret", sentAt, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		body      []byte
+		now       time.Time
+		tolerance time.Duration
+		wantErr   error
+	}{
+		{name: "Valid", secret: "secret", header: header, body: body, now: sentAt.Add(time.Minute), tolerance: 5 * time.Minute},
+		{name: "No tolerance check", secret: "secret", header: header, body: body, now: sentAt.Add(24 * time.Hour)},
+		{name: "Tampered body", secret: "secret", header: header, body: []byte(`{"event":"film.deleted"}`), now: sentAt, wantErr: ErrInvalidSignature},
+		{name: "Wrong secret", secret: "other", header: header, body: body, now: sentAt, wantErr: ErrInvalidSignature},
+		{name: "Too old", secret: "secret", header: header, body: body, now: sentAt.Add(time.Hour), tolerance: 5 * time.Minute, wantErr: ErrTimestampTooOld},
+		{name: "From the future", secret: "secret", header: header, body: body, now: sentAt.Add(-time.Hour), tolerance: 5 * time.Minute, wantErr: ErrTimestampTooOld},
+		{name: "Rotated secret", secret: "secret", header: Sign("old", sentAt, body) + "," + strings.Split(header, ",")[1], body: body, now: sentAt},
+		{name: "Missing signature", secret: "secret", header: "t=1700000000", body: body, now: sentAt, wantErr: ErrInvalidHeader},
+		{name: "Missing timestamp", secret: "secret", header: strings.Split(header, ",")[1], body: body, now: sentAt, wantErr: ErrInvalidHeader},
+		{name: "Garbage", secret: "secret", header: "nonsense", body: body, now: sentAt, wantErr: ErrInvalidHeader},
+		{name: "Empty secret", secret: "", header: header, body: body, now: sentAt, wantErr: ErrSecretNotProvided},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, tt.now, tt.tolerance)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestNewSecret tests that secrets are prefixed and unique
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewSecret()
+
+	if !strings.HasPrefix(a, "whsec_") || len(a) != len("whsec_")+64 {
+		t.Errorf("unexpected secret format %q", a)
+	}
+	if a == b {
+		t.Error("two secrets were identical")
+	}
+}
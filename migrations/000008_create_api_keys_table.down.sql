@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_api_keys_user_id;
+
+ALTER TABLE api_keys DROP CONSTRAINT IF EXISTS api_keys_user_name_unique;
+
+DROP TABLE IF EXISTS api_keys;
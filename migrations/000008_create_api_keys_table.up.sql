@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    permissions text[] NOT NULL DEFAULT '{}',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    expiry timestamp(0) with time zone
+);
+
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_user_name_unique UNIQUE (user_id, name);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys (user_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_user_identities_user_id;
+
+ALTER TABLE user_identities DROP CONSTRAINT IF EXISTS user_identities_issuer_subject_unique;
+
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    actor_id bigint,
+    action text NOT NULL,
+    resource_type text NOT NULL,
+    resource_id bigint NOT NULL,
+    before jsonb,
+    after jsonb,
+    request_id text,
+    ip text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_resource_idx ON audit_events (resource_type, resource_id, created_at);
+CREATE INDEX IF NOT EXISTS audit_events_created_at_idx ON audit_events (created_at);
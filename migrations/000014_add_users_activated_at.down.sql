@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_never_activated_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS activated_at;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS tasks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    kind text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_until timestamp with time zone,
+    last_error text NOT NULL DEFAULT '',
+    CONSTRAINT tasks_status_check CHECK (status IN ('pending', 'running', 'dead')),
+    CONSTRAINT tasks_max_attempts_check CHECK (max_attempts > 0)
+);
+
+CREATE INDEX IF NOT EXISTS tasks_pending_idx ON tasks (kind, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS tasks_running_idx ON tasks (kind, locked_until) WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS tasks_dead_idx ON tasks (created_at) WHERE status = 'dead';
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events text[] NOT NULL,
+    active boolean NOT NULL DEFAULT true,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+-- Events are written here in the same transaction as the change that caused
+-- them, then relayed into deliveries.
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id bigserial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    payload jsonb NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    webhook_id bigint NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+    event_id bigint NOT NULL,
+    event text NOT NULL,
+    payload jsonb NOT NULL,
+    occurred_at timestamp with time zone NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    last_attempt_at timestamp with time zone,
+    response_status integer,
+    response_body text NOT NULL DEFAULT '',
+    error text NOT NULL DEFAULT '',
+    CONSTRAINT webhook_deliveries_status_check CHECK (status IN ('pending', 'succeeded', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+
+INSERT INTO permissions (code)
+VALUES ('webhooks:manage');
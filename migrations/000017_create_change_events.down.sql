@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS watchlist_change_events ON watchlist;
+DROP TRIGGER IF EXISTS films_change_events ON films;
+DROP FUNCTION IF EXISTS publish_watchlist_change();
+DROP FUNCTION IF EXISTS publish_film_change();
+DROP TABLE IF EXISTS change_events;
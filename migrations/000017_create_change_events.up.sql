@@ -0,0 +1,66 @@
+-- A short history of catalogue and watchlist changes for streaming clients.
+-- Rows are pruned by age, so resuming only works within that window.
+CREATE TABLE IF NOT EXISTS change_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    event text NOT NULL,
+    user_id bigint,
+    payload jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS change_events_created_at_idx ON change_events (created_at);
+
+CREATE OR REPLACE FUNCTION publish_film_change() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO change_events (event, payload)
+        VALUES ('film.deleted', jsonb_build_object('id', OLD.id))
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO change_events (event, payload)
+        VALUES (
+            CASE TG_OP WHEN 'INSERT' THEN 'film.created' ELSE 'film.updated' END,
+            jsonb_build_object('id', NEW.id, 'title', NEW.title, 'version', NEW.version)
+        )
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('change_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION publish_watchlist_change() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO change_events (event, user_id, payload)
+        VALUES ('watchlist.removed', OLD.user_id, jsonb_build_object('id', OLD.id, 'film_id', OLD.film_id))
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO change_events (event, user_id, payload)
+        VALUES (
+            CASE TG_OP WHEN 'INSERT' THEN 'watchlist.added' ELSE 'watchlist.updated' END,
+            NEW.user_id,
+            jsonb_build_object('id', NEW.id, 'film_id', NEW.film_id, 'watched', NEW.watched, 'version', NEW.version)
+        )
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('change_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS films_change_events ON films;
+CREATE TRIGGER films_change_events
+AFTER INSERT OR UPDATE OR DELETE ON films
+FOR EACH ROW EXECUTE FUNCTION publish_film_change();
+
+DROP TRIGGER IF EXISTS watchlist_change_events ON watchlist;
+CREATE TRIGGER watchlist_change_events
+AFTER INSERT OR UPDATE OR DELETE ON watchlist
+FOR EACH ROW EXECUTE FUNCTION publish_watchlist_change();
@@ -0,0 +1,7 @@
+-- Events about one user's data, such as a watchlist entry being watched,
+-- record their owner so they're only delivered to that user's webhooks.
+ALTER TABLE webhook_outbox ADD COLUMN IF NOT EXISTS user_id bigint;
+
+UPDATE webhook_outbox
+SET user_id = (payload->>'user_id')::bigint
+WHERE event = 'watchlist.watched' AND user_id IS NULL;
@@ -0,0 +1,5 @@
+-- Databases migrated before this existed already have the permission, and
+-- permissions.code isn't unique, so only insert it when it's missing.
+INSERT INTO permissions (code)
+SELECT 'users:admin'
+WHERE NOT EXISTS (SELECT 1 FROM permissions WHERE code = 'users:admin');
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations, so the binary can apply them
+// itself with the migrate subcommand or -migrate-on-start.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"testing"
+
+	"filmapi.zeyadtarek.net/internals/migrate"
+	"filmapi.zeyadtarek.net/internals/models"
+)
+
+// TestSchemaVersion tests that the newest embedded migration is the one the models expect
+func TestSchemaVersion(t *testing.T) {
+	migrations, err := migrate.Load(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest := migrate.New(nil, migrations).Latest()
+	if latest != models.SchemaVersion {
+		t.Errorf("newest migration is %d, but models.SchemaVersion is %d", latest, models.SchemaVersion)
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != int64(i+1) {
+			t.Errorf("migration %s has version %d, want %d", migration.Name, migration.Version, i+1)
+		}
+		if migration.Down == "" {
+			t.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+		}
+	}
+}